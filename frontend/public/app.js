@@ -7,16 +7,37 @@ const API_BASE = {
 
 // State
 let token = localStorage.getItem('token');
+let refreshToken = localStorage.getItem('refreshToken');
 let user = JSON.parse(localStorage.getItem('user') || 'null');
+let mfaToken = null;
 
 // Initialize
 document.addEventListener('DOMContentLoaded', () => {
     updateAuthUI();
     loadOverallStats();
     loadRecentLinks();
+    loadLoginProviders();
 
     // Form submission
     document.getElementById('shorten-form').addEventListener('submit', handleShorten);
+
+    // Arrived from a password reset or verification email
+    const params = new URLSearchParams(window.location.search);
+    if (params.has('reset_token')) {
+        showModal('reset');
+    }
+    if (params.has('verify_token')) {
+        verifyEmail(params.get('verify_token'));
+    }
+
+    // Back from logging in with an identity provider
+    if (params.has('oidc_code')) {
+        completeProviderLogin(params.get('oidc_code'));
+    }
+    if (params.has('oidc_error')) {
+        showToast(params.get('oidc_error'), 'error');
+        clearQueryParam('oidc_error');
+    }
 });
 
 // ===== Auth Functions =====
@@ -30,6 +51,7 @@ function updateAuthUI() {
         authButtons.classList.add('hidden');
         userMenu.classList.remove('hidden');
         userName.textContent = user.name || user.email;
+        document.getElementById('verify-button').classList.toggle('hidden', !!user.email_verified_at);
     } else {
         authButtons.classList.remove('hidden');
         userMenu.classList.add('hidden');
@@ -51,24 +73,122 @@ async function handleLogin(e) {
 
         const data = await response.json();
 
+        if (response.status === 429 && data.retry_after) {
+            throw new Error(`Too many failed attempts, try again in ${formatWait(data.retry_after)}`);
+        }
         if (!response.ok) {
             throw new Error(data.error || 'Login failed');
         }
 
-        token = data.token;
-        user = data.user;
-        localStorage.setItem('token', token);
-        localStorage.setItem('user', JSON.stringify(user));
+        // Accounts with two-factor authentication need a code first
+        if (data.mfa_required) {
+            mfaToken = data.mfa_token;
+            document.getElementById('mfa-form').reset();
+            showModal('mfa');
+            return;
+        }
 
-        closeModals();
-        updateAuthUI();
-        showToast('Welcome back! 👋');
-        loadRecentLinks();
+        completeLogin(data);
     } catch (error) {
         showToast(error.message, 'error');
     }
 }
 
+function formatWait(seconds) {
+    if (seconds < 60) return `${seconds}s`;
+    return `${Math.ceil(seconds / 60)} min`;
+}
+
+async function loadLoginProviders() {
+    try {
+        const response = await fetch(`${API_BASE.user}/api/users/oidc/providers`);
+        if (!response.ok) return;
+
+        const providers = await response.json();
+        const container = document.getElementById('oidc-providers');
+        container.innerHTML = '';
+        providers.forEach(provider => {
+            const link = document.createElement('a');
+            link.className = 'btn btn-ghost btn-full';
+            link.href = `${API_BASE.user}${provider.login_url}`;
+            link.textContent = `Continue with ${provider.display_name}`;
+            container.appendChild(link);
+        });
+    } catch (error) {
+        // Password login still works
+    }
+}
+
+async function completeProviderLogin(code) {
+    clearQueryParam('oidc_code');
+
+    try {
+        const response = await fetch(`${API_BASE.user}/api/users/oidc/token`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ code })
+        });
+
+        const data = await response.json();
+
+        if (!response.ok) {
+            throw new Error(data.error || 'Login failed');
+        }
+
+        if (data.mfa_required) {
+            mfaToken = data.mfa_token;
+            document.getElementById('mfa-form').reset();
+            showModal('mfa');
+            return;
+        }
+
+        completeLogin(data);
+    } catch (error) {
+        showToast(error.message, 'error');
+    }
+}
+
+function clearQueryParam(name) {
+    const params = new URLSearchParams(window.location.search);
+    params.delete(name);
+    const query = params.toString();
+    window.history.replaceState(null, '', window.location.pathname + (query ? `?${query}` : ''));
+}
+
+async function handleMFALogin(e) {
+    e.preventDefault();
+
+    const code = document.getElementById('mfa-code').value.trim();
+
+    try {
+        const response = await fetch(`${API_BASE.user}/api/users/login/mfa`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ mfa_token: mfaToken, code })
+        });
+
+        const data = await response.json();
+
+        if (!response.ok) {
+            throw new Error(data.error || 'Verification failed');
+        }
+
+        mfaToken = null;
+        completeLogin(data);
+    } catch (error) {
+        showToast(error.message, 'error');
+    }
+}
+
+function completeLogin(data) {
+    saveSession(data);
+
+    closeModals();
+    updateAuthUI();
+    showToast('Welcome back! 👋');
+    loadRecentLinks();
+}
+
 async function handleRegister(e) {
     e.preventDefault();
 
@@ -89,24 +209,200 @@ async function handleRegister(e) {
             throw new Error(data.error || 'Registration failed');
         }
 
-        token = data.token;
-        user = data.user;
-        localStorage.setItem('token', token);
-        localStorage.setItem('user', JSON.stringify(user));
+        saveSession(data);
+
+        closeModals();
+        updateAuthUI();
+        showToast('Account created! Check your inbox to verify your email 📬');
+    } catch (error) {
+        showToast(error.message, 'error');
+    }
+}
+
+async function handleForgotPassword(e) {
+    e.preventDefault();
+
+    const email = document.getElementById('forgot-email').value;
+
+    try {
+        const response = await fetch(`${API_BASE.user}/api/users/password/forgot`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ email })
+        });
+
+        const data = await response.json();
+
+        if (!response.ok) {
+            throw new Error(data.error || 'Could not send reset link');
+        }
 
         closeModals();
+        showToast('Check your inbox for a reset link 📬');
+    } catch (error) {
+        showToast(error.message, 'error');
+    }
+}
+
+async function handleResetPassword(e) {
+    e.preventDefault();
+
+    const params = new URLSearchParams(window.location.search);
+    const password = document.getElementById('reset-password').value;
+
+    try {
+        const response = await fetch(`${API_BASE.user}/api/users/password/reset`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ token: params.get('reset_token'), password })
+        });
+
+        const data = await response.json();
+
+        if (!response.ok) {
+            throw new Error(data.error || 'Password reset failed');
+        }
+
+        // Drop the spent token from the address bar
+        params.delete('reset_token');
+        const query = params.toString();
+        window.history.replaceState(null, '', window.location.pathname + (query ? `?${query}` : ''));
+
+        clearSession();
         updateAuthUI();
-        showToast('Account created successfully! 🎉');
+        showModal('login');
+        showToast('Password updated, please log in 🔑');
     } catch (error) {
         showToast(error.message, 'error');
     }
 }
 
-function logout() {
+async function verifyEmail(verifyToken) {
+    // Drop the token from the address bar whatever the outcome
+    const params = new URLSearchParams(window.location.search);
+    params.delete('verify_token');
+    const query = params.toString();
+    window.history.replaceState(null, '', window.location.pathname + (query ? `?${query}` : ''));
+
+    try {
+        const response = await fetch(`${API_BASE.user}/api/users/verify-email`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ token: verifyToken })
+        });
+
+        const data = await response.json();
+
+        if (!response.ok) {
+            throw new Error(data.error || 'Verification failed');
+        }
+
+        // The current access token still says unverified
+        if (refreshToken) {
+            await refreshSession();
+        }
+        updateAuthUI();
+        showToast('Email verified ✅');
+    } catch (error) {
+        showToast(error.message, 'error');
+    }
+}
+
+async function resendVerification() {
+    try {
+        const response = await authFetch(`${API_BASE.user}/api/users/verify-email/resend`, {
+            method: 'POST'
+        });
+
+        const data = await response.json();
+
+        if (!response.ok) {
+            throw new Error(data.error || 'Could not send verification email');
+        }
+
+        showToast('Verification email sent 📬');
+    } catch (error) {
+        showToast(error.message, 'error');
+    }
+}
+
+function saveSession(data) {
+    token = data.token;
+    refreshToken = data.refresh_token;
+    user = data.user;
+    localStorage.setItem('token', token);
+    localStorage.setItem('refreshToken', refreshToken);
+    localStorage.setItem('user', JSON.stringify(user));
+}
+
+function clearSession() {
     token = null;
+    refreshToken = null;
     user = null;
     localStorage.removeItem('token');
+    localStorage.removeItem('refreshToken');
     localStorage.removeItem('user');
+}
+
+// Exchange the refresh token for a new access token; false if the session is gone.
+// Concurrent callers share one exchange, since each refresh token works once.
+let pendingRefresh = null;
+
+function refreshSession() {
+    if (!pendingRefresh) {
+        pendingRefresh = exchangeRefreshToken().finally(() => {
+            pendingRefresh = null;
+        });
+    }
+    return pendingRefresh;
+}
+
+async function exchangeRefreshToken() {
+    if (!refreshToken) return false;
+
+    try {
+        const response = await fetch(`${API_BASE.user}/api/users/refresh`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ refresh_token: refreshToken })
+        });
+        if (!response.ok) {
+            clearSession();
+            updateAuthUI();
+            return false;
+        }
+        saveSession(await response.json());
+        return true;
+    } catch (error) {
+        return false;
+    }
+}
+
+// fetch with the access token, refreshing it once if it has expired
+async function authFetch(url, options = {}) {
+    const withToken = () => {
+        const headers = { ...(options.headers || {}) };
+        if (token) {
+            headers['Authorization'] = `Bearer ${token}`;
+        }
+        return fetch(url, { ...options, headers });
+    };
+
+    const sentToken = token;
+    const response = await withToken();
+    // Another request may already have refreshed the token meanwhile
+    if (response.status === 401 && token && (token !== sentToken || await refreshSession())) {
+        return withToken();
+    }
+    return response;
+}
+
+async function logout() {
+    if (token) {
+        // Revoke the session server-side; log out locally regardless
+        await authFetch(`${API_BASE.user}/api/users/logout`, { method: 'POST' }).catch(() => {});
+    }
+    clearSession();
     updateAuthUI();
     showToast('Logged out successfully');
     loadRecentLinks();
@@ -130,14 +426,9 @@ async function handleShorten(e) {
     }
 
     try {
-        const headers = { 'Content-Type': 'application/json' };
-        if (token) {
-            headers['Authorization'] = `Bearer ${token}`;
-        }
-
-        const response = await fetch(`${API_BASE.url}/api/urls`, {
+        const response = await authFetch(`${API_BASE.url}/api/urls`, {
             method: 'POST',
-            headers,
+            headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify(body)
         });
 
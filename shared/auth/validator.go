@@ -0,0 +1,318 @@
+// Package auth validates user-service access tokens and API keys for the
+// other services. Tokens are verified locally against the published keys
+// and, unless disabled, confirmed with user-service so logouts take effect
+// within CacheTTL. API keys are always looked up at user-service.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/shared/jwks"
+)
+
+var (
+	// ErrInvalidToken means the token is malformed, expired or revoked.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrUnavailable means the token could not be checked either way
+	// because user-service did not answer in time.
+	ErrUnavailable = errors.New("auth service unavailable")
+)
+
+const (
+	// Cache bound; expired entries are swept once it is reached
+	maxCacheEntries = 10000
+	// Marks API keys issued by user-service
+	apiKeyPrefix = "lsk_"
+)
+
+// Scopes an API key can grant; access tokens grant all of them.
+const (
+	ScopeLinksRead  = "links:read"
+	ScopeLinksWrite = "links:write"
+	ScopeStatsRead  = "stats:read"
+)
+
+// Identity is the authenticated caller behind a token.
+type Identity struct {
+	UserID        uuid.UUID
+	Email         string
+	EmailVerified bool
+	// Set for API keys, nil for access tokens
+	Scopes []string
+}
+
+// IsAPIKey reports whether token is an API key rather than an access token.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// Allows reports whether the caller may act with scope.
+func (i *Identity) Allows(scope string) bool {
+	if i.Scopes == nil {
+		return true
+	}
+	for _, s := range i.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type Config struct {
+	UserServiceURL string
+	Issuer         string
+	// Per-request timeout for calls to user-service, key set fetches included
+	Timeout time.Duration
+	// How long a validation result is reused
+	CacheTTL time.Duration
+	// Ask user-service whether the token was revoked (logout, password change)
+	CheckRevocation bool
+	// Consecutive failures that open the breaker, and how long it stays open
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+type cacheEntry struct {
+	identity *Identity
+	expires  time.Time
+}
+
+// Validator is shared by all requests: one HTTP client with pooled
+// connections, one result cache and one circuit breaker.
+type Validator struct {
+	keys        *jwks.Verifier
+	validateURL string
+	client      *http.Client
+	timeout     time.Duration
+	cacheTTL    time.Duration
+	revocation  bool
+	breaker     *breaker
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+func NewValidator(cfg Config) *Validator {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = 30 * time.Second
+	}
+	if cfg.BreakerThreshold <= 0 {
+		cfg.BreakerThreshold = 5
+	}
+	if cfg.BreakerCooldown <= 0 {
+		cfg.BreakerCooldown = 30 * time.Second
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   cfg.Timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   32,
+		IdleConnTimeout:       90 * time.Second,
+		ResponseHeaderTimeout: cfg.Timeout,
+	}
+	client := &http.Client{Transport: transport, Timeout: cfg.Timeout}
+
+	return &Validator{
+		keys:        jwks.NewVerifier(cfg.UserServiceURL+"/.well-known/jwks.json", cfg.Issuer, cfg.Timeout),
+		validateURL: cfg.UserServiceURL + "/api/users/validate",
+		client:      client,
+		timeout:     cfg.Timeout,
+		cacheTTL:    cfg.CacheTTL,
+		revocation:  cfg.CheckRevocation,
+		breaker:     newBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		cache:       make(map[string]cacheEntry),
+	}
+}
+
+// Validate returns the caller behind token. clientIP is the address the
+// request came from, passed on to user-service so API key usage shows it
+// rather than this service's address. Errors are ErrInvalidToken or
+// ErrUnavailable (possibly wrapped).
+func (v *Validator) Validate(ctx context.Context, token, clientIP string) (*Identity, error) {
+	key := tokenHash(token)
+	if entry, ok := v.cached(key); ok {
+		if entry.identity == nil {
+			return nil, ErrInvalidToken
+		}
+		return entry.identity, nil
+	}
+
+	if IsAPIKey(token) {
+		return v.validateAPIKey(ctx, key, token, clientIP)
+	}
+
+	// Waiting for the key set is bounded like a call to user-service; the
+	// verifier itself starts at most one fetch every 30 seconds, so while
+	// user-service is down requests fail fast as with an open breaker
+	keyCtx, cancel := context.WithTimeout(ctx, v.timeout)
+	claims, err := v.keys.Verify(keyCtx, token)
+	cancel()
+	if errors.Is(err, jwks.ErrKeysUnavailable) {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	if err != nil {
+		v.store(key, nil, time.Now().Add(v.cacheTTL))
+		return nil, ErrInvalidToken
+	}
+
+	identity := &Identity{UserID: claims.UserID, Email: claims.Email, EmailVerified: claims.Verified()}
+	if v.revocation {
+		if _, err := v.checkRemote(ctx, token, clientIP); err != nil {
+			if errors.Is(err, ErrInvalidToken) {
+				v.store(key, nil, time.Now().Add(v.cacheTTL))
+			}
+			return nil, err
+		}
+	}
+
+	expires := time.Now().Add(v.cacheTTL)
+	if claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(expires) {
+		expires = claims.ExpiresAt.Time
+	}
+	v.store(key, identity, expires)
+	return identity, nil
+}
+
+// validateAPIKey looks an API key up at user-service; there is nothing to
+// verify locally.
+func (v *Validator) validateAPIKey(ctx context.Context, key, token, clientIP string) (*Identity, error) {
+	result, err := v.checkRemote(ctx, token, clientIP)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			v.store(key, nil, time.Now().Add(v.cacheTTL))
+		}
+		return nil, err
+	}
+
+	identity := &Identity{
+		UserID:        result.UserID,
+		Email:         result.Email,
+		EmailVerified: result.EmailVerified,
+		Scopes:        result.Scopes,
+	}
+	if identity.Scopes == nil {
+		// A key without scopes grants nothing, unlike an access token
+		identity.Scopes = []string{}
+	}
+	v.store(key, identity, time.Now().Add(v.cacheTTL))
+	return identity, nil
+}
+
+type validateResponse struct {
+	Valid         bool      `json:"valid"`
+	UserID        uuid.UUID `json:"user_id"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Scopes        []string  `json:"scopes"`
+}
+
+// checkRemote asks user-service whether a correctly signed token, or an API
+// key, is still valid, going through the breaker so an outage fails fast.
+// user-service only takes clientIP from X-Forwarded-For if it trusts this
+// service as a proxy (TRUSTED_PROXIES).
+func (v *Validator) checkRemote(ctx context.Context, token, clientIP string) (*validateResponse, error) {
+	if !v.breaker.allow() {
+		return nil, fmt.Errorf("%w: circuit open", ErrUnavailable)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.validateURL, nil)
+	if err != nil {
+		v.breaker.abandon()
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if clientIP != "" {
+		req.Header.Set("X-Forwarded-For", clientIP)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		// A client that went away says nothing about user-service
+		if ctx.Err() != nil {
+			v.breaker.abandon()
+		} else {
+			v.breaker.failure()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		var result validateResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			v.breaker.failure()
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+		v.breaker.success()
+		if !result.Valid {
+			return nil, ErrInvalidToken
+		}
+		return &result, nil
+	case resp.StatusCode == http.StatusUnauthorized:
+		v.breaker.success()
+		return nil, ErrInvalidToken
+	default:
+		v.breaker.failure()
+		return nil, fmt.Errorf("%w: validate returned %s", ErrUnavailable, resp.Status)
+	}
+}
+
+func (v *Validator) cached(key string) (cacheEntry, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, ok := v.cache[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(v.cache, key)
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (v *Validator) store(key string, identity *Identity, expires time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(v.cache) >= maxCacheEntries {
+		now := time.Now()
+		for k, entry := range v.cache {
+			if now.After(entry.expires) {
+				delete(v.cache, k)
+			}
+		}
+		// Still full of live entries: start over rather than grow unbounded
+		if len(v.cache) >= maxCacheEntries {
+			v.cache = make(map[string]cacheEntry)
+		}
+	}
+	v.cache[key] = cacheEntry{identity: identity, expires: expires}
+}
+
+// tokenHash keys the cache so raw tokens are not kept in memory.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
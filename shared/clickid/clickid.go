@@ -0,0 +1,62 @@
+// Package clickid signs the click IDs url-service hands out on redirect, so
+// that stats-service only attributes conversions to click IDs it issued.
+package clickid
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Param names the signed click ID in destination URLs and conversion
+// requests.
+const Param = "click_id"
+
+// ErrInvalid is returned for click IDs that are malformed or carry a bad
+// signature.
+var ErrInvalid = errors.New("invalid click ID")
+
+// Signer signs and verifies click IDs with a secret shared by url-service
+// and stats-service.
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner returns a signer for secret, or nil if secret is empty.
+func NewSigner(secret string) *Signer {
+	if secret == "" {
+		return nil
+	}
+	return &Signer{secret: []byte(secret)}
+}
+
+// Sign returns the click ID followed by a dot and its signature.
+func (s *Signer) Sign(id uuid.UUID) string {
+	return id.String() + "." + s.mac(id)
+}
+
+// Verify checks a value produced by Sign and returns the click ID.
+func (s *Signer) Verify(value string) (uuid.UUID, error) {
+	raw, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return uuid.Nil, ErrInvalid
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.Nil, ErrInvalid
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.mac(id))) {
+		return uuid.Nil, ErrInvalid
+	}
+	return id, nil
+}
+
+func (s *Signer) mac(id uuid.UUID) string {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write(id[:])
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,8 @@
+module github.com/urlshortener/shared
+
+go 1.21
+
+require (
+	github.com/golang-jwt/jwt/v5 v5.2.0
+	github.com/google/uuid v1.5.0
+)
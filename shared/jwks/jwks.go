@@ -0,0 +1,191 @@
+// Package jwks verifies user-service access tokens locally, against the
+// public keys user-service publishes at /.well-known/jwks.json.
+package jwks
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/urlshortener/shared/jwk"
+)
+
+// ErrKeysUnavailable means the key set could not be fetched, so the token
+// could not be checked either way.
+var ErrKeysUnavailable = errors.New("token signing keys unavailable")
+
+const (
+	// How long a fetched key set is used before it is refreshed
+	defaultTTL = 10 * time.Minute
+	// Fetches start at most this often, whatever triggers them
+	minRefetchInterval = 30 * time.Second
+)
+
+// Claims mirrors the access token claims issued by user-service.
+type Claims struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	SessionID uuid.UUID `json:"sid,omitempty"`
+	// Absent from tokens issued before email verification existed, whose
+	// users count as verified
+	EmailVerified *bool `json:"email_verified,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Verified reports whether the user has confirmed their email address.
+func (c *Claims) Verified() bool {
+	return c.EmailVerified == nil || *c.EmailVerified
+}
+
+// Verifier checks token signatures against a cached JWKS. Tokens revoked at
+// user-service stay valid here until they expire.
+type Verifier struct {
+	url    string
+	issuer string
+	client *http.Client
+	ttl    time.Duration
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	lastErr     error
+	// Closed when the running fetch completes; nil when none is running
+	fetching chan struct{}
+}
+
+// NewVerifier creates a verifier for the key set at url. timeout bounds each
+// fetch (default 5s).
+func NewVerifier(url, issuer string, timeout time.Duration) *Verifier {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &Verifier{
+		url:    url,
+		issuer: issuer,
+		client: &http.Client{Timeout: timeout},
+		ttl:    defaultTTL,
+	}
+}
+
+// Verify parses and validates a token, returning its claims. ctx bounds the
+// wait for the key set when it has to be fetched first.
+func (v *Verifier) Verify(ctx context.Context, tokenString string) (*Claims, error) {
+	var keyErr error
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := v.key(ctx, kid)
+		if err != nil {
+			keyErr = err
+			return nil, err
+		}
+		return key, nil
+	}, jwt.WithValidMethods([]string{"RS256", "EdDSA"}), jwt.WithIssuer(v.issuer))
+
+	if errors.Is(keyErr, ErrKeysUnavailable) {
+		return nil, keyErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// key returns the public key for kid. A stale key set keeps being used while
+// it is refreshed in the background; callers only wait for a fetch when there
+// are no keys yet or kid is unknown (the signer may have rotated keys).
+func (v *Verifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	if key, ok := v.keys[kid]; ok {
+		if time.Since(v.fetchedAt) > v.ttl {
+			v.refresh()
+		}
+		v.mu.Unlock()
+		return key, nil
+	}
+	done := v.refresh()
+	v.mu.Unlock()
+
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if v.keys == nil {
+		err := v.lastErr
+		if err == nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// refresh starts fetching the key set unless a fetch is running or one
+// started less than minRefetchInterval ago. It returns a channel closed when
+// the running fetch completes, or nil if there is none. v.mu must be held.
+func (v *Verifier) refresh() <-chan struct{} {
+	if v.fetching != nil {
+		return v.fetching
+	}
+	if time.Since(v.lastAttempt) < minRefetchInterval {
+		return nil
+	}
+
+	done := make(chan struct{})
+	v.fetching = done
+	v.lastAttempt = time.Now()
+
+	go func() {
+		keys, err := v.fetch()
+
+		v.mu.Lock()
+		// On a failed fetch keep using the keys we have
+		if err == nil {
+			v.keys = keys
+			v.fetchedAt = time.Now()
+		}
+		v.lastErr = err
+		v.fetching = nil
+		v.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+func (v *Verifier) fetch() (map[string]crypto.PublicKey, error) {
+	resp, err := v.client.Get(v.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", v.url, resp.Status)
+	}
+
+	var set jwk.Set
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	return set.PublicKeys()
+}
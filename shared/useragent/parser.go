@@ -0,0 +1,125 @@
+// Package useragent classifies user-agents by browser, OS, device and bot.
+// url-service and stats-service share its rules, so both agree on which
+// clicks come from bots.
+package useragent
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	DeviceDesktop = "Desktop"
+	DeviceMobile  = "Mobile"
+	DeviceTablet  = "Tablet"
+	DeviceBot     = "Bot"
+
+	Unknown = "Other"
+)
+
+// Result is the parsed form of a user-agent string.
+type Result struct {
+	Browser        string
+	BrowserVersion string
+	OS             string
+	OSVersion      string
+	Device         string
+	IsBot          bool
+	BotName        string
+}
+
+// Parser classifies user-agents using a set of rules that can be swapped at
+// runtime with Reload.
+type Parser struct {
+	mu       sync.RWMutex
+	bots     []compiledRule
+	browsers []compiledRule
+	os       []compiledRule
+	devices  []compiledRule
+}
+
+func NewParser(rules *Rules) (*Parser, error) {
+	p := &Parser{}
+	if err := p.Reload(rules); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload compiles rules and replaces the active set. On error the previous
+// rules stay in effect.
+func (p *Parser) Reload(rules *Rules) error {
+	bots, err := compile(rules.Bots)
+	if err != nil {
+		return err
+	}
+	browsers, err := compile(rules.Browsers)
+	if err != nil {
+		return err
+	}
+	osRules, err := compile(rules.OS)
+	if err != nil {
+		return err
+	}
+	devices, err := compile(rules.Devices)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.bots, p.browsers, p.os, p.devices = bots, browsers, osRules, devices
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *Parser) Parse(userAgent string) Result {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	ua := strings.TrimSpace(userAgent)
+	result := Result{
+		Browser: Unknown,
+		OS:      Unknown,
+		Device:  DeviceDesktop,
+	}
+
+	result.Browser, result.BrowserVersion = match(p.browsers, ua)
+	result.OS, result.OSVersion = match(p.os, ua)
+
+	if name, _ := match(p.bots, ua); name != Unknown {
+		result.IsBot = true
+		result.BotName = name
+		result.Device = DeviceBot
+		return result
+	}
+
+	if device, _ := match(p.devices, ua); device != Unknown {
+		result.Device = device
+	}
+	return result
+}
+
+// IsBot reports whether the user-agent matches one of the bot rules,
+// without parsing the rest of it.
+func (p *Parser) IsBot(userAgent string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	name, _ := match(p.bots, strings.TrimSpace(userAgent))
+	return name != Unknown
+}
+
+func match(rules []compiledRule, ua string) (string, string) {
+	for _, rule := range rules {
+		m := rule.re.FindStringSubmatch(ua)
+		if m == nil {
+			continue
+		}
+		version := ""
+		if len(m) > 1 {
+			version = strings.ReplaceAll(m[1], "_", ".")
+		}
+		return rule.name, version
+	}
+	return Unknown, ""
+}
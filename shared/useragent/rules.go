@@ -0,0 +1,76 @@
+package useragent
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+//go:embed rules.json
+var defaultRules []byte
+
+// Rule matches a user-agent against a case-insensitive regular expression.
+// When the pattern has a capture group, its first match is used as the version.
+type Rule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// Rules is the on-disk format of the parser rules. Rules within each list are
+// evaluated in order and the first match wins, so more specific entries
+// (e.g. Edge, Opera) must come before the generic ones they imitate (Chrome).
+type Rules struct {
+	Bots     []Rule `json:"bots"`
+	Browsers []Rule `json:"browsers"`
+	OS       []Rule `json:"os"`
+	Devices  []Rule `json:"devices"`
+}
+
+type compiledRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// DefaultRules returns the rules bundled with the binary.
+func DefaultRules() (*Rules, error) {
+	return parseRules(defaultRules)
+}
+
+// LoadRules reads rules from a JSON file. An empty path returns the bundled rules.
+func LoadRules(path string) (*Rules, error) {
+	if path == "" {
+		return DefaultRules()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseRules(data)
+}
+
+func parseRules(data []byte) (*Rules, error) {
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid user-agent rules: %w", err)
+	}
+	if len(rules.Browsers) == 0 || len(rules.OS) == 0 {
+		return nil, errors.New("invalid user-agent rules: browsers and os must not be empty")
+	}
+	return &rules, nil
+}
+
+func compile(rules []Rule) ([]compiledRule, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		re, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for %q: %w", rule.Name, err)
+		}
+		compiled = append(compiled, compiledRule{name: rule.Name, re: re})
+	}
+	return compiled, nil
+}
@@ -2,19 +2,34 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/urlshortener/shared/auth"
+	"github.com/urlshortener/shared/mailer"
+	"github.com/urlshortener/shared/useragent"
+	"github.com/urlshortener/stats-service/internal/compactor"
 	"github.com/urlshortener/stats-service/internal/consumer"
 	"github.com/urlshortener/stats-service/internal/handlers"
+	"github.com/urlshortener/stats-service/internal/middleware"
 	"github.com/urlshortener/stats-service/internal/models"
 	"github.com/urlshortener/stats-service/internal/repository"
 	"github.com/urlshortener/stats-service/internal/service"
+	"github.com/urlshortener/stats-service/internal/stream"
+	"github.com/urlshortener/stats-service/pkg/ipanon"
+	"github.com/urlshortener/stats-service/pkg/referrer"
+	"github.com/urlshortener/stats-service/pkg/webhook"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -32,7 +47,7 @@ func main() {
 	}
 
 	// Auto migrate
-	if err := db.AutoMigrate(&models.Click{}); err != nil {
+	if err := db.AutoMigrate(&models.Click{}, &models.HourlyClickRollup{}, &models.DailyClickRollup{}, &models.AggregateHourlyClickRollup{}, &models.AggregateDailyClickRollup{}, &models.RollupState{}, &models.RetentionOverride{}, &models.LinkOwner{}, &models.LinkTag{}, &models.AlertRule{}, &models.Alert{}, &models.WebhookEndpoint{}, &models.WebhookDelivery{}, &models.Conversion{}, &models.ReportSubscription{}, &models.ReportDelivery{}); err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
@@ -45,25 +60,224 @@ func main() {
 		Addr: redisAddr,
 	})
 
+	// User-agent rules (bundled defaults unless UA_RULES_FILE is set)
+	uaRules, err := useragent.LoadRules(os.Getenv("UA_RULES_FILE"))
+	if err != nil {
+		log.Fatal("Failed to load user-agent rules:", err)
+	}
+	uaParser, err := useragent.NewParser(uaRules)
+	if err != nil {
+		log.Fatal("Failed to compile user-agent rules:", err)
+	}
+
+	// Referrer-to-channel mapping (bundled defaults unless REFERRER_SOURCES_FILE is set)
+	referrerSources, err := referrer.LoadSources(os.Getenv("REFERRER_SOURCES_FILE"))
+	if err != nil {
+		log.Fatal("Failed to load referrer sources:", err)
+	}
+
+	// Client IP storage: truncate (default), hash, full or none
+	ipAnon, err := ipanon.NewAnonymizer(ipanon.Mode(os.Getenv("IP_STORAGE_MODE")), os.Getenv("IP_HASH_KEY"))
+	if err != nil {
+		log.Fatal("Failed to configure IP storage:", err)
+	}
+
 	// Initialize layers
 	statsRepo := repository.NewStatsRepository(db)
-	statsService := service.NewStatsService(statsRepo)
+	statsService := service.NewStatsService(statsRepo, uaParser, referrer.NewClassifier(referrerSources), ipAnon)
 	statsHandler := handlers.NewStatsHandler(statsService)
 
+	retentionDays, _ := strconv.Atoi(os.Getenv("CLICK_RETENTION_DAYS"))
+	purgeBatchSize, _ := strconv.Atoi(os.Getenv("CLICK_PURGE_BATCH_SIZE"))
+	retentionService := service.NewRetentionService(statsRepo, retentionDays, purgeBatchSize)
+	retentionHandler := handlers.NewRetentionHandler(retentionService)
+
+	if err := statsRepo.BackfillLinkOwners(); err != nil {
+		log.Fatal("Failed to backfill link owners:", err)
+	}
+
+	exportHandler := handlers.NewExportHandler(service.NewExportService(statsRepo))
+	queryHandler := handlers.NewQueryHandler(service.NewQueryService(statsRepo))
+	comparisonHandler := handlers.NewComparisonHandler(service.NewComparisonService(statsRepo))
+
+	conversionWindowDays, _ := strconv.Atoi(os.Getenv("CONVERSION_WINDOW_DAYS"))
+	conversionService := service.NewConversionService(statsRepo, os.Getenv("CLICK_ID_SECRET"), conversionWindowDays, os.Getenv("DEFAULT_CURRENCY"))
+	conversionHandler := handlers.NewConversionHandler(conversionService)
+
+	// Maintenance commands run once and exit:
+	//   stats-service rebuild-rollups          recompute rollups from raw clicks
+	//   stats-service purge-clicks [--dry-run] apply the retention policy
+	//   stats-service purge-user <user-id>     delete a deleted account's data
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rebuild-rollups":
+			log.Println("Rebuilding click rollups...")
+			if err := statsService.RebuildRollups(); err != nil {
+				log.Fatal("Failed to rebuild rollups:", err)
+			}
+			log.Println("Rollups rebuilt")
+		case "purge-clicks":
+			dryRun := len(os.Args) > 2 && os.Args[2] == "--dry-run"
+			report, err := retentionService.Purge(dryRun)
+			if err != nil {
+				log.Fatal("Failed to purge clicks:", err)
+			}
+			out, _ := json.MarshalIndent(report, "", "  ")
+			fmt.Println(string(out))
+		case "purge-user":
+			if len(os.Args) < 3 {
+				log.Fatal("Usage: stats-service purge-user <user-id>")
+			}
+			userID, err := uuid.Parse(os.Args[2])
+			if err != nil {
+				log.Fatal("Invalid user ID:", err)
+			}
+			clicks, err := retentionService.PurgeUser(userID)
+			if err != nil {
+				log.Fatal("Failed to purge user data:", err)
+			}
+			log.Printf("Purged %d clicks and the other data of user %s", clicks, userID)
+		default:
+			log.Fatalf("Unknown command %q", os.Args[1])
+		}
+		return
+	}
+
+	// Live click stream: keep 1000 events for resume, buffer 256 per client
+	clickHub := stream.NewHub(1000, 256)
+	streamTickets := stream.NewTickets(redisClient)
+	streamHandler := handlers.NewStreamHandler(clickHub, streamTickets)
+
+	// Leaderboards and trending links, kept in Redis
+	trendingMinClicks, _ := strconv.ParseInt(os.Getenv("TRENDING_MIN_CLICKS"), 10, 64)
+	trendingMinGrowth, _ := strconv.ParseFloat(os.Getenv("TRENDING_MIN_GROWTH"), 64)
+	leaderboardService := service.NewLeaderboardService(redisClient, trendingMinClicks, trendingMinGrowth)
+	leaderboardHandler := handlers.NewLeaderboardHandler(leaderboardService)
+
+	// Alert rules, evaluated on the click stream and delivered to webhooks
+	allowPrivateWebhooks, _ := strconv.ParseBool(os.Getenv("WEBHOOK_ALLOW_PRIVATE_TARGETS"))
+	webhookSender := webhook.NewSender(10*time.Second, allowPrivateWebhooks)
+	alertService := service.NewAlertService(statsRepo, webhookSender)
+	alertHandler := handlers.NewAlertHandler(alertService)
+
+	// Outgoing webhooks for link lifecycle and click events
+	webhookService := service.NewWebhookService(statsRepo, webhookSender)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+
+	// Scheduled email reports, sent over SMTP when SMTP_HOST is set
+	var reportMailer *mailer.Mailer
+	if smtpHost := os.Getenv("SMTP_HOST"); smtpHost != "" {
+		smtpPort, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		smtpFrom := os.Getenv("SMTP_FROM")
+		if smtpFrom == "" {
+			smtpFrom = "URL Shortener <reports@localhost>"
+		}
+		reportMailer, err = mailer.New(mailer.Config{
+			Host:     smtpHost,
+			Port:     smtpPort,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     smtpFrom,
+		})
+		if err != nil {
+			log.Fatal("Failed to configure SMTP:", err)
+		}
+	} else {
+		log.Println("SMTP_HOST not set, email reports are disabled")
+	}
+	reportSendHour := 8
+	if hour, err := strconv.Atoi(os.Getenv("REPORT_SEND_HOUR")); err == nil {
+		reportSendHour = hour
+	}
+	publicURL := os.Getenv("PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:8083"
+	}
+	dashboardURL := os.Getenv("DASHBOARD_URL")
+	if dashboardURL == "" {
+		dashboardURL = "http://localhost:3000"
+	}
+	reportService := service.NewReportService(statsRepo, reportMailer, reportSendHour, publicURL, dashboardURL)
+	reportHandler := handlers.NewReportHandler(reportService)
+
 	// Start consumer in background
 	ctx, cancel := context.WithCancel(context.Background())
-	clickConsumer := consumer.NewClickConsumer(redisClient, statsService)
+	accountPurger := compactor.NewAccountPurger(retentionService, redisClient, 100)
+	go accountPurger.Start(ctx)
+	clickConsumer := consumer.NewClickConsumer(redisClient, statsService, clickHub, leaderboardService, alertService, webhookService, accountPurger, reportService)
 	go clickConsumer.Start(ctx)
 
+	// Start alert dispatcher in background
+	alertDispatcher := compactor.NewAlertDispatcher(alertService, 10*time.Second)
+	go alertDispatcher.Start(ctx)
+
+	// Start webhook dispatcher in background
+	webhookDispatcher := compactor.NewWebhookDispatcher(webhookService, 5*time.Second)
+	go webhookDispatcher.Start(ctx)
+
+	// Start report scheduler in background
+	if reportMailer != nil {
+		reportScheduler := compactor.NewReportScheduler(reportService, time.Minute)
+		go reportScheduler.Start(ctx)
+	}
+
+	// Start rollup compactor in background
+	rollupInterval, err := time.ParseDuration(os.Getenv("ROLLUP_INTERVAL"))
+	if err != nil || rollupInterval <= 0 {
+		rollupInterval = 5 * time.Minute
+	}
+	rollupCompactor := compactor.NewRollupCompactor(statsService, rollupInterval)
+	go rollupCompactor.Start(ctx)
+
+	// Start retention purger in background
+	purgeInterval, err := time.ParseDuration(os.Getenv("CLICK_PURGE_INTERVAL"))
+	if err != nil || purgeInterval <= 0 {
+		purgeInterval = time.Hour
+	}
+	retentionPurger := compactor.NewRetentionPurger(retentionService, purgeInterval)
+	go retentionPurger.Start(ctx)
+
+	// Token validation, shared by all authenticated routes
+	userServiceURL := os.Getenv("USER_SERVICE_URL")
+	if userServiceURL == "" {
+		userServiceURL = "http://localhost:8081"
+	}
+	issuer := os.Getenv("JWT_ISSUER")
+	if issuer == "" {
+		issuer = "urlshortener"
+	}
+	authTimeout, _ := time.ParseDuration(os.Getenv("AUTH_TIMEOUT"))
+	authCacheTTL, _ := time.ParseDuration(os.Getenv("AUTH_CACHE_TTL"))
+	validator := auth.NewValidator(auth.Config{
+		UserServiceURL:  userServiceURL,
+		Issuer:          issuer,
+		Timeout:         authTimeout,
+		CacheTTL:        authCacheTTL,
+		CheckRevocation: os.Getenv("AUTH_CHECK_REVOCATION") != "false",
+	})
+
 	// Setup Gin
 	r := gin.Default()
 
+	// Client IPs (passed on to user-service for API key usage) are only taken
+	// from X-Forwarded-For when the request comes through one of these
+	// proxies (comma-separated IPs or CIDRs); by default from none
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+
 	// CORS
 	r.Use(cors.New(cors.Config{
 		AllowAllOrigins:  true,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Accept"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Accept", "Last-Event-ID"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Disposition"},
 		AllowCredentials: false,
 	}))
 
@@ -73,10 +287,98 @@ func main() {
 	api := r.Group("/api/stats")
 	{
 		api.GET("/overall", statsHandler.GetOverallStats)
-		api.GET("/recent", statsHandler.GetRecentClicks)
+		api.GET("/recent", middleware.ReadAuthMiddleware(validator), statsHandler.GetRecentClicks)
+		api.GET("/query", middleware.ReadAuthMiddleware(validator), queryHandler.Query)
+		api.GET("/compare", middleware.ReadAuthMiddleware(validator), comparisonHandler.Compare)
+		api.POST("/stream/ticket", middleware.AuthMiddleware(validator), streamHandler.IssueTicket)
+		api.GET("/stream", middleware.StreamAuth(validator, streamTickets), streamHandler.Stream)
+		api.GET("/stream/ws", middleware.StreamAuth(validator, streamTickets), streamHandler.StreamWS)
+
+		retention := api.Group("/retention")
+		retention.Use(middleware.AuthMiddleware(validator))
+		{
+			retention.GET("", retentionHandler.GetPolicy)
+			retention.PUT("", retentionHandler.SetOverride)
+			retention.DELETE("", retentionHandler.ClearOverride)
+			retention.GET("/report", retentionHandler.GetPurgeReport)
+		}
+
+		api.GET("/leaderboard", middleware.ReadAuthMiddleware(validator), leaderboardHandler.GetLeaderboard)
+		api.GET("/trending", middleware.ReadAuthMiddleware(validator), leaderboardHandler.GetTrending)
+
+		admin := api.Group("/admin")
+		admin.Use(middleware.AuthMiddleware(validator), middleware.AdminOnly())
+		{
+			admin.GET("/leaderboard", leaderboardHandler.GetGlobalLeaderboard)
+			admin.GET("/trending", leaderboardHandler.GetGlobalTrending)
+		}
+
+		alerts := api.Group("/alerts")
+		alerts.Use(middleware.AuthMiddleware(validator))
+		{
+			alerts.GET("", alertHandler.ListAlerts)
+			alerts.GET("/rules", alertHandler.ListRules)
+			alerts.POST("/rules", alertHandler.CreateRule)
+			alerts.PUT("/rules/:id", alertHandler.UpdateRule)
+			alerts.DELETE("/rules/:id", alertHandler.DeleteRule)
+		}
+
+		api.POST("/conversions", middleware.AuthMiddleware(validator), conversionHandler.TrackConversion)
+		api.GET("/conversions/pixel.gif", conversionHandler.ConversionPixel)
+		api.POST("/conversions/beacon", conversionHandler.ConversionBeacon)
+
+		webhooks := api.Group("/webhooks")
+		webhooks.Use(middleware.AuthMiddleware(validator))
+		{
+			webhooks.GET("", webhookHandler.ListEndpoints)
+			webhooks.POST("", webhookHandler.CreateEndpoint)
+			webhooks.PUT("/:id", webhookHandler.UpdateEndpoint)
+			webhooks.DELETE("/:id", webhookHandler.DeleteEndpoint)
+			webhooks.GET("/deliveries", webhookHandler.ListDeliveries)
+			webhooks.POST("/deliveries/:id/redeliver", webhookHandler.Redeliver)
+		}
+
+		api.GET("/reports/unsubscribe", reportHandler.ConfirmUnsubscribe)
+		api.POST("/reports/unsubscribe", reportHandler.Unsubscribe)
+		reports := api.Group("/reports")
+		reports.Use(middleware.AuthMiddleware(validator))
+		{
+			reports.GET("", reportHandler.ListSubscriptions)
+			reports.PUT("", reportHandler.Subscribe)
+			reports.GET("/preview", reportHandler.Preview)
+			reports.GET("/deliveries", reportHandler.ListDeliveries)
+			reports.DELETE("/:id", reportHandler.DeleteSubscription)
+			reports.POST("/:id/send", reportHandler.SendNow)
+		}
+
+		exports := api.Group("/export")
+		exports.Use(middleware.ReadAuthMiddleware(validator))
+		{
+			exports.GET("/clicks", exportHandler.ExportClicks)
+			exports.GET("/stats", exportHandler.ExportStats)
+		}
+
 		api.GET("/:code", statsHandler.GetURLStats)
+		api.GET("/:code/conversions", middleware.ReadAuthMiddleware(validator), conversionHandler.GetConversionStats)
 	}
 
+	// Reload user-agent rules on SIGHUP
+	go func() {
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		for range hupChan {
+			rules, err := useragent.LoadRules(os.Getenv("UA_RULES_FILE"))
+			if err == nil {
+				err = uaParser.Reload(rules)
+			}
+			if err != nil {
+				log.Printf("Failed to reload user-agent rules: %v", err)
+				continue
+			}
+			log.Println("Reloaded user-agent rules")
+		}
+	}()
+
 	// Graceful shutdown
 	go func() {
 		sigChan := make(chan os.Signal, 1)
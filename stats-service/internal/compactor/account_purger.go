@@ -0,0 +1,66 @@
+package compactor
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/service"
+)
+
+// AccountPurger drops the data of accounts deleted in user-service away from
+// the click consumer, since purging a busy account takes a while, and
+// acknowledges each deletion once done. Deletions it cannot take or fails to
+// purge go unacknowledged, so user-service announces them again.
+type AccountPurger struct {
+	service *service.RetentionService
+	redis   *redis.Client
+	queue   chan uuid.UUID
+}
+
+func NewAccountPurger(service *service.RetentionService, redis *redis.Client, queueSize int) *AccountPurger {
+	return &AccountPurger{
+		service: service,
+		redis:   redis,
+		queue:   make(chan uuid.UUID, queueSize),
+	}
+}
+
+// Enqueue schedules the purge of a deleted user without waiting for it.
+func (p *AccountPurger) Enqueue(userID uuid.UUID) {
+	select {
+	case p.queue <- userID:
+	default:
+		log.Printf("Account Purger: Queue full, leaving user %s for the next announcement", userID)
+	}
+}
+
+func (p *AccountPurger) Start(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Account Purger: Shutting down...")
+			return
+		case userID := <-p.queue:
+			p.purge(ctx, userID)
+		}
+	}
+}
+
+func (p *AccountPurger) purge(ctx context.Context, userID uuid.UUID) {
+	clicks, err := p.service.PurgeUser(userID)
+	if err != nil {
+		log.Printf("Account Purger: Error purging data of user %s: %v", userID, err)
+		return
+	}
+	log.Printf("Account Purger: Purged %d clicks of deleted user %s", clicks, userID)
+
+	ack, _ := json.Marshal(models.UserEvent{Type: models.UserDeletionAcked, UserID: userID, Service: "stats-service", Timestamp: time.Now()})
+	if err := p.redis.Publish(ctx, "user:acks", ack).Err(); err != nil {
+		log.Printf("Account Purger: Error acknowledging deletion of user %s: %v", userID, err)
+	}
+}
@@ -0,0 +1,50 @@
+package compactor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/urlshortener/stats-service/internal/service"
+)
+
+// AlertDispatcher evaluates time-based alert rules once a minute and
+// delivers pending alerts every interval.
+type AlertDispatcher struct {
+	service  *service.AlertService
+	interval time.Duration
+}
+
+func NewAlertDispatcher(service *service.AlertService, interval time.Duration) *AlertDispatcher {
+	return &AlertDispatcher{
+		service:  service,
+		interval: interval,
+	}
+}
+
+func (d *AlertDispatcher) Start(ctx context.Context) {
+	if err := d.service.ReloadRules(); err != nil {
+		log.Printf("Alert Dispatcher: Error loading rules: %v", err)
+	}
+
+	deliveries := time.NewTicker(d.interval)
+	defer deliveries.Stop()
+	evaluations := time.NewTicker(time.Minute)
+	defer evaluations.Stop()
+
+	log.Printf("Alert Dispatcher: Delivering every %s", d.interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Alert Dispatcher: Shutting down...")
+			return
+		case now := <-evaluations.C:
+			d.service.Tick(now)
+		case <-deliveries.C:
+			if err := d.service.Dispatch(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Alert Dispatcher: Error delivering alerts: %v", err)
+			}
+		}
+	}
+}
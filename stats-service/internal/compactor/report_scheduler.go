@@ -0,0 +1,41 @@
+package compactor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/urlshortener/stats-service/internal/service"
+)
+
+// ReportScheduler emails due analytics reports every interval.
+type ReportScheduler struct {
+	service  *service.ReportService
+	interval time.Duration
+}
+
+func NewReportScheduler(service *service.ReportService, interval time.Duration) *ReportScheduler {
+	return &ReportScheduler{
+		service:  service,
+		interval: interval,
+	}
+}
+
+func (s *ReportScheduler) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	log.Printf("Report Scheduler: Checking for due reports every %s", s.interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Report Scheduler: Shutting down...")
+			return
+		case <-ticker.C:
+			if err := s.service.SendDue(ctx, time.Now()); err != nil && ctx.Err() == nil {
+				log.Printf("Report Scheduler: Error sending reports: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package compactor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/urlshortener/stats-service/internal/service"
+)
+
+type RetentionPurger struct {
+	service  *service.RetentionService
+	interval time.Duration
+}
+
+func NewRetentionPurger(service *service.RetentionService, interval time.Duration) *RetentionPurger {
+	return &RetentionPurger{
+		service:  service,
+		interval: interval,
+	}
+}
+
+func (p *RetentionPurger) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	log.Printf("Retention Purger: Running every %s", p.interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Retention Purger: Shutting down...")
+			return
+		case <-ticker.C:
+			if _, err := p.service.Purge(false); err != nil {
+				log.Printf("Retention Purger: Error purging clicks: %v", err)
+			}
+		}
+	}
+}
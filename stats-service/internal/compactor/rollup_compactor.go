@@ -0,0 +1,41 @@
+package compactor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/urlshortener/stats-service/internal/service"
+)
+
+type RollupCompactor struct {
+	service  *service.StatsService
+	interval time.Duration
+}
+
+func NewRollupCompactor(service *service.StatsService, interval time.Duration) *RollupCompactor {
+	return &RollupCompactor{
+		service:  service,
+		interval: interval,
+	}
+}
+
+func (c *RollupCompactor) Start(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	log.Printf("Rollup Compactor: Running every %s", c.interval)
+
+	for {
+		if err := c.service.CompactRollups(); err != nil {
+			log.Printf("Rollup Compactor: Error compacting clicks: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Rollup Compactor: Shutting down...")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package compactor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/urlshortener/stats-service/internal/service"
+)
+
+// WebhookDispatcher delivers queued webhook events every interval and trims
+// the delivery log hourly.
+type WebhookDispatcher struct {
+	service  *service.WebhookService
+	interval time.Duration
+}
+
+func NewWebhookDispatcher(service *service.WebhookService, interval time.Duration) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		service:  service,
+		interval: interval,
+	}
+}
+
+func (d *WebhookDispatcher) Start(ctx context.Context) {
+	if err := d.service.ReloadEndpoints(); err != nil {
+		log.Printf("Webhook Dispatcher: Error loading endpoints: %v", err)
+	}
+
+	deliveries := time.NewTicker(d.interval)
+	defer deliveries.Stop()
+	maintenance := time.NewTicker(time.Hour)
+	defer maintenance.Stop()
+
+	log.Printf("Webhook Dispatcher: Delivering every %s", d.interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Webhook Dispatcher: Shutting down...")
+			return
+		case <-maintenance.C:
+			if err := d.service.PurgeDeliveries(); err != nil {
+				log.Printf("Webhook Dispatcher: Error purging delivery log: %v", err)
+			}
+		case <-deliveries.C:
+			if err := d.service.Dispatch(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Webhook Dispatcher: Error delivering webhooks: %v", err)
+			}
+		}
+	}
+}
@@ -6,24 +6,38 @@ import (
 	"log"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/urlshortener/stats-service/internal/compactor"
 	"github.com/urlshortener/stats-service/internal/models"
 	"github.com/urlshortener/stats-service/internal/service"
+	"github.com/urlshortener/stats-service/internal/stream"
 )
 
 type ClickConsumer struct {
 	redisClient *redis.Client
 	service     *service.StatsService
+	hub         *stream.Hub
+	leaderboard *service.LeaderboardService
+	alerts      *service.AlertService
+	webhooks    *service.WebhookService
+	purger      *compactor.AccountPurger
+	reports     *service.ReportService
 }
 
-func NewClickConsumer(redisClient *redis.Client, service *service.StatsService) *ClickConsumer {
+func NewClickConsumer(redisClient *redis.Client, service *service.StatsService, hub *stream.Hub, leaderboard *service.LeaderboardService, alerts *service.AlertService, webhooks *service.WebhookService, purger *compactor.AccountPurger, reports *service.ReportService) *ClickConsumer {
 	return &ClickConsumer{
 		redisClient: redisClient,
 		service:     service,
+		hub:         hub,
+		leaderboard: leaderboard,
+		alerts:      alerts,
+		webhooks:    webhooks,
+		purger:      purger,
+		reports:     reports,
 	}
 }
 
 func (c *ClickConsumer) Start(ctx context.Context) {
-	pubsub := c.redisClient.Subscribe(ctx, "url:click")
+	pubsub := c.redisClient.Subscribe(ctx, "url:click", "url:events", "user:events")
 	defer pubsub.Close()
 
 	log.Println("Stats Consumer: Listening for click events...")
@@ -43,18 +57,78 @@ func (c *ClickConsumer) Start(ctx context.Context) {
 				continue
 			}
 
-			var event models.ClickEvent
-			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
-				log.Printf("Stats Consumer: Error unmarshaling event: %v", err)
-				continue
+			switch msg.Channel {
+			case "url:events":
+				c.handleLinkEvent(msg.Payload)
+			case "user:events":
+				c.handleUserEvent(msg.Payload)
+			default:
+				c.handleClick(ctx, msg.Payload)
 			}
+		}
+	}
+}
 
-			if err := c.service.RecordClick(&event); err != nil {
-				log.Printf("Stats Consumer: Error recording click: %v", err)
-				continue
-			}
+func (c *ClickConsumer) handleClick(ctx context.Context, payload string) {
+	var event models.ClickEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		log.Printf("Stats Consumer: Error unmarshaling event: %v", err)
+		return
+	}
+
+	click, err := c.service.RecordClick(&event)
+	if err != nil {
+		log.Printf("Stats Consumer: Error recording click: %v", err)
+		return
+	}
+	c.hub.Publish(click)
+	if err := c.leaderboard.Record(ctx, click); err != nil {
+		log.Printf("Stats Consumer: Error updating leaderboard: %v", err)
+	}
+	c.alerts.Observe(click)
+	// Owners are not notified of crawlers and link previews
+	if !click.IsBot {
+		if err := c.webhooks.Publish(click.UserID, models.EventLinkClicked, click, click.CreatedAt); err != nil {
+			log.Printf("Stats Consumer: Error queueing click webhooks: %v", err)
+		}
+	}
+
+	log.Printf("Stats Consumer: Recorded click for %s", event.ShortCode)
+}
+
+// handleLinkEvent keeps track of link tags and forwards link lifecycle
+// events from url-service to webhooks.
+func (c *ClickConsumer) handleLinkEvent(payload string) {
+	var event models.LinkEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		log.Printf("Stats Consumer: Error unmarshaling link event: %v", err)
+		return
+	}
+
+	if err := c.service.RecordLinkTags(&event); err != nil {
+		log.Printf("Stats Consumer: Error recording link tags: %v", err)
+	}
+
+	if err := c.webhooks.Publish(event.UserID, event.Type, event.Link, event.Timestamp); err != nil {
+		log.Printf("Stats Consumer: Error queueing %s webhooks: %v", event.Type, err)
+	}
+}
+
+// handleUserEvent hands accounts deleted in user-service to the purger and
+// sends reports to changed addresses.
+func (c *ClickConsumer) handleUserEvent(payload string) {
+	var event models.UserEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		log.Printf("Stats Consumer: Error unmarshaling user event: %v", err)
+		return
+	}
 
-			log.Printf("Stats Consumer: Recorded click for %s", event.ShortCode)
+	switch event.Type {
+	case models.UserDeleted:
+		c.purger.Enqueue(event.UserID)
+	case models.UserEmailChanged:
+		if err := c.reports.UpdateEmail(event.UserID, event.Email, event.EmailVerified); err != nil {
+			log.Printf("Stats Consumer: Error updating report address of user %s: %v", event.UserID, err)
 		}
 	}
 }
@@ -0,0 +1,103 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Row is a flat export record. Row types also carry parquet struct tags.
+type Row interface {
+	CSVHeader() []string
+	CSVRecord() []string
+}
+
+// Encoder writes rows to an output stream one at a time. Close must be called
+// to flush buffered output (and, for Parquet, write the footer).
+type Encoder interface {
+	Encode(row Row) error
+	Close() error
+}
+
+// Parquet rows are buffered per row group; keep groups small so memory stays
+// bounded regardless of the export size.
+const parquetRowGroupSize = 8 * 1024 * 1024
+
+// ContentType returns the MIME type and file extension for format.
+func ContentType(format models.ExportFormat) (string, string) {
+	switch format {
+	case models.ExportNDJSON:
+		return "application/x-ndjson", "ndjson"
+	case models.ExportParquet:
+		return "application/vnd.apache.parquet", "parquet"
+	default:
+		return "text/csv; charset=utf-8", "csv"
+	}
+}
+
+// NewEncoder returns an encoder writing rows shaped like proto (a pointer to
+// the row struct) to w.
+func NewEncoder(format models.ExportFormat, w io.Writer, proto Row) (Encoder, error) {
+	switch format {
+	case models.ExportCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(proto.CSVHeader()); err != nil {
+			return nil, err
+		}
+		return &csvEncoder{w: cw}, nil
+	case models.ExportNDJSON:
+		return &ndjsonEncoder{enc: json.NewEncoder(w)}, nil
+	case models.ExportParquet:
+		pw, err := writer.NewParquetWriterFromWriter(w, proto, 1)
+		if err != nil {
+			return nil, err
+		}
+		pw.RowGroupSize = parquetRowGroupSize
+		pw.CompressionType = parquet.CompressionCodec_SNAPPY
+		return &parquetEncoder{w: pw}, nil
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) Encode(row Row) error {
+	return e.w.Write(row.CSVRecord())
+}
+
+func (e *csvEncoder) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) Encode(row Row) error {
+	return e.enc.Encode(row)
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return nil
+}
+
+type parquetEncoder struct {
+	w *writer.ParquetWriter
+}
+
+func (e *parquetEncoder) Encode(row Row) error {
+	return e.w.Write(row)
+}
+
+func (e *parquetEncoder) Close() error {
+	return e.w.WriteStop()
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/service"
+)
+
+type ComparisonHandler struct {
+	service *service.ComparisonService
+}
+
+func NewComparisonHandler(service *service.ComparisonService) *ComparisonHandler {
+	return &ComparisonHandler{service: service}
+}
+
+// Compare godoc
+// @Summary Compare links, or periods of a link, side by side
+// @Description With several codes, compares the links over one period; deltas are relative to the first. Otherwise compares a period of one link (or all the user's links) with a baseline period of the same length. Only the user's own links are counted.
+// @Tags stats
+// @Produce json
+// @Security BearerAuth
+// @Param codes query string false "Comma-separated short codes (up to 10)"
+// @Param mode query string false "links or periods (default: links when several codes are given)"
+// @Param from query string false "Start, RFC 3339 or YYYY-MM-DD (default: 30 days before to)"
+// @Param to query string false "End, exclusive, RFC 3339 or YYYY-MM-DD (default: now)"
+// @Param baseline query string false "previous (default), year, or the baseline start as RFC 3339 or YYYY-MM-DD"
+// @Param granularity query string false "day or hour (default: hour for ranges up to 48h)"
+// @Param breakdowns query string false "Comma-separated dimensions (default: device,country,referer,channel)"
+// @Param include_bots query bool false "Include bot and crawler traffic"
+// @Success 200 {object} models.Comparison
+// @Router /api/stats/compare [get]
+func (h *ComparisonHandler) Compare(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	q, err := comparisonQuery(c, userID.(uuid.UUID))
+	if err == nil {
+		err = h.service.Normalize(&q)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	comparison, err := h.service.Compare(q)
+	if err != nil {
+		log.Printf("Comparison for user %s failed: %v", q.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compare"})
+		return
+	}
+
+	c.JSON(http.StatusOK, comparison)
+}
+
+func comparisonQuery(c *gin.Context, userID uuid.UUID) (models.ComparisonQuery, error) {
+	export, err := exportQuery(c, userID)
+	if err != nil {
+		return models.ComparisonQuery{}, err
+	}
+
+	q := models.ComparisonQuery{
+		UserID:      userID,
+		Mode:        c.Query("mode"),
+		ShortCodes:  listParam(c, "codes"),
+		From:        export.From,
+		To:          export.To,
+		Granularity: c.Query("granularity"),
+		Breakdowns:  listParam(c, "breakdowns"),
+		Filter:      export.Filter,
+	}
+	if export.ShortCode != "" {
+		q.ShortCodes = append([]string{export.ShortCode}, q.ShortCodes...)
+	}
+
+	switch baseline := c.DefaultQuery("baseline", "previous"); baseline {
+	case "previous":
+	case "year":
+		q.BaselineFrom = q.From.AddDate(-1, 0, 0)
+	default:
+		if q.BaselineFrom, err = parseExportTime(baseline); err != nil {
+			return q, errors.New("invalid baseline: use previous, year, RFC 3339 or YYYY-MM-DD")
+		}
+	}
+	return q, nil
+}
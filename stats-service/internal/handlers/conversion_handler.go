@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/service"
+)
+
+// transparentGIF is a 1x1 transparent GIF.
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+type ConversionHandler struct {
+	service *service.ConversionService
+}
+
+func NewConversionHandler(service *service.ConversionService) *ConversionHandler {
+	return &ConversionHandler{service: service}
+}
+
+// TrackConversion godoc
+// @Summary Record a conversion from a server
+// @Description The click must be on one of the caller's links.
+// @Tags conversions
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.ConversionRequest true "Conversion"
+// @Success 201 {object} models.Conversion
+// @Success 200 {object} models.Conversion "Already recorded"
+// @Router /api/stats/conversions [post]
+func (h *ConversionHandler) TrackConversion(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req models.ConversionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	owner := userID.(uuid.UUID)
+	conversion, created, err := h.service.Record(&req, "api", &owner)
+	if err != nil {
+		c.JSON(conversionErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, conversion)
+}
+
+// ConversionPixel godoc
+// @Summary Record a conversion from a tracking pixel
+// @Description Embed on the conversion page with the signed click_id url-service appended to the landing page URL. A click converts once per name this way, without revenue; report revenue from your server. Always returns a 1x1 GIF.
+// @Tags conversions
+// @Produce image/gif
+// @Param click_id query string true "Signed click ID"
+// @Param name query string false "Conversion name (default: conversion)"
+// @Success 200
+// @Router /api/stats/conversions/pixel.gif [get]
+func (h *ConversionHandler) ConversionPixel(c *gin.Context) {
+	var req models.ConversionRequest
+	if err := c.ShouldBindQuery(&req); err == nil {
+		// The pixel renders the same whatever happens
+		h.service.Record(&req, "pixel", nil)
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/gif", transparentGIF)
+}
+
+// ConversionBeacon godoc
+// @Summary Record a conversion from the browser (navigator.sendBeacon or fetch)
+// @Description The body is JSON whatever the content type; click_id is the signed click ID url-service appended to the landing page URL. As with the pixel, a click converts once per name and external_id, revenue and currency are ignored.
+// @Tags conversions
+// @Accept json
+// @Param request body models.ConversionRequest true "Conversion"
+// @Success 204
+// @Router /api/stats/conversions/beacon [post]
+func (h *ConversionHandler) ConversionBeacon(c *gin.Context) {
+	var req models.ConversionRequest
+	// sendBeacon posts as text/plain, so decode regardless of content type
+	if err := json.NewDecoder(io.LimitReader(c.Request.Body, 8*1024)).Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON body"})
+		return
+	}
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, _, err := h.service.Record(&req, "beacon", nil); err != nil {
+		c.JSON(conversionErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetConversionStats godoc
+// @Summary Get conversion stats for one of the caller's links
+// @Tags conversions
+// @Produce json
+// @Security BearerAuth
+// @Param code path string true "Short code"
+// @Param include_bots query bool false "Include bot and crawler traffic"
+// @Success 200 {object} models.ConversionStats
+// @Failure 404 {object} map[string]interface{}
+// @Router /api/stats/{code}/conversions [get]
+func (h *ConversionHandler) GetConversionStats(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	stats, err := h.service.GetStats(userID.(uuid.UUID), c.Param("code"), statsFilter(c))
+	if err != nil {
+		c.JSON(conversionErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+func conversionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrUnknownClick), errors.Is(err, service.ErrUnknownLink):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrAttributionWindow):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
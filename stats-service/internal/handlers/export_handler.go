@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/export"
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/service"
+)
+
+type ExportHandler struct {
+	service *service.ExportService
+}
+
+func NewExportHandler(service *service.ExportService) *ExportHandler {
+	return &ExportHandler{service: service}
+}
+
+// ExportClicks godoc
+// @Summary Export raw clicks on the user's links
+// @Tags export
+// @Produce text/csv,application/x-ndjson,application/vnd.apache.parquet
+// @Security BearerAuth
+// @Param format query string false "csv (default), ndjson or parquet"
+// @Param code query string false "Only this short code (default: all the user's links)"
+// @Param tag query string false "Only links with this tag"
+// @Param from query string false "Start, RFC 3339 or YYYY-MM-DD (default: 30 days before to)"
+// @Param to query string false "End, exclusive, RFC 3339 or YYYY-MM-DD (default: now)"
+// @Param include_bots query bool false "Include bot and crawler traffic"
+// @Success 200
+// @Router /api/stats/export/clicks [get]
+func (h *ExportHandler) ExportClicks(c *gin.Context) {
+	h.export(c, "clicks", h.service.ExportClicks)
+}
+
+// ExportStats godoc
+// @Summary Export aggregated stats for the user's links
+// @Description One row per short code, dimension (total, day, device, browser, os, referer, channel, and bot_total/bot with include_bots) and value.
+// @Tags export
+// @Produce text/csv,application/x-ndjson,application/vnd.apache.parquet
+// @Security BearerAuth
+// @Param format query string false "csv (default), ndjson or parquet"
+// @Param code query string false "Only this short code (default: all the user's links)"
+// @Param tag query string false "Only links with this tag"
+// @Param from query string false "Start, RFC 3339 or YYYY-MM-DD (default: 30 days before to)"
+// @Param to query string false "End, exclusive, RFC 3339 or YYYY-MM-DD (default: now)"
+// @Param include_bots query bool false "Include bot and crawler traffic"
+// @Success 200
+// @Router /api/stats/export/stats [get]
+func (h *ExportHandler) ExportStats(c *gin.Context) {
+	h.export(c, "stats", h.service.ExportStats)
+}
+
+type exportFunc func(w io.Writer, format models.ExportFormat, q models.ExportQuery) error
+
+func (h *ExportHandler) export(c *gin.Context, kind string, run exportFunc) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	format := models.ExportFormat(c.DefaultQuery("format", string(models.ExportCSV)))
+	q, err := exportQuery(c, userID.(uuid.UUID))
+	if err == nil {
+		err = h.service.ValidateQuery(format, q)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contentType, ext := export.ContentType(format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s-%s.%s"`,
+		kind, q.From.Format("20060102"), q.To.Format("20060102"), ext))
+	c.Status(http.StatusOK)
+
+	// Headers are already sent, so a failure can only cut the stream short
+	if err := run(c.Writer, format, q); err != nil {
+		log.Printf("Export of %s for user %s failed: %v", kind, q.UserID, err)
+		c.Abort()
+	}
+}
+
+func exportQuery(c *gin.Context, userID uuid.UUID) (models.ExportQuery, error) {
+	q := models.ExportQuery{
+		UserID:    userID,
+		ShortCode: c.Query("code"),
+		Tag:       strings.ToLower(strings.TrimSpace(c.Query("tag"))),
+		To:        time.Now().UTC(),
+		Filter:    statsFilter(c),
+	}
+
+	var err error
+	if to := c.Query("to"); to != "" {
+		if q.To, err = parseExportTime(to); err != nil {
+			return q, errors.New("invalid to: use RFC 3339 or YYYY-MM-DD")
+		}
+	}
+	q.From = q.To.AddDate(0, 0, -30)
+	if from := c.Query("from"); from != "" {
+		if q.From, err = parseExportTime(from); err != nil {
+			return q, errors.New("invalid from: use RFC 3339 or YYYY-MM-DD")
+		}
+	}
+	return q, nil
+}
+
+func parseExportTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
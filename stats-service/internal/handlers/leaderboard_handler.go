@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/service"
+)
+
+type LeaderboardHandler struct {
+	service *service.LeaderboardService
+}
+
+func NewLeaderboardHandler(service *service.LeaderboardService) *LeaderboardHandler {
+	return &LeaderboardHandler{service: service}
+}
+
+// GetLeaderboard godoc
+// @Summary Top links of the user by clicks
+// @Tags leaderboard
+// @Produce json
+// @Security BearerAuth
+// @Param window query string false "hour, day (default) or week"
+// @Param limit query int false "Limit (default 10, max 100)"
+// @Success 200 {object} models.Leaderboard
+// @Router /api/stats/leaderboard [get]
+func (h *LeaderboardHandler) GetLeaderboard(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	h.leaderboard(c, userID.(uuid.UUID))
+}
+
+// GetGlobalLeaderboard godoc
+// @Summary Top links across all users by clicks (admin only)
+// @Tags leaderboard
+// @Produce json
+// @Security BearerAuth
+// @Param window query string false "hour, day (default) or week"
+// @Param limit query int false "Limit (default 10, max 100)"
+// @Success 200 {object} models.Leaderboard
+// @Router /api/stats/admin/leaderboard [get]
+func (h *LeaderboardHandler) GetGlobalLeaderboard(c *gin.Context) {
+	h.leaderboard(c, uuid.Nil)
+}
+
+// GetTrending godoc
+// @Summary The user's links whose clicks are rising sharply
+// @Tags leaderboard
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Limit (default 10, max 100)"
+// @Success 200 {array} models.TrendingEntry
+// @Router /api/stats/trending [get]
+func (h *LeaderboardHandler) GetTrending(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	h.trending(c, userID.(uuid.UUID))
+}
+
+// GetGlobalTrending godoc
+// @Summary Links across all users whose clicks are rising sharply (admin only)
+// @Tags leaderboard
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Limit (default 10, max 100)"
+// @Success 200 {array} models.TrendingEntry
+// @Router /api/stats/admin/trending [get]
+func (h *LeaderboardHandler) GetGlobalTrending(c *gin.Context) {
+	h.trending(c, uuid.Nil)
+}
+
+func (h *LeaderboardHandler) leaderboard(c *gin.Context, userID uuid.UUID) {
+	window := models.LeaderboardWindow(c.DefaultQuery("window", string(models.WindowDay)))
+	switch window {
+	case models.WindowHour, models.WindowDay, models.WindowWeek:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "window must be hour, day or week"})
+		return
+	}
+
+	board, err := h.service.Top(c.Request.Context(), userID, window, leaderboardLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, board)
+}
+
+func (h *LeaderboardHandler) trending(c *gin.Context, userID uuid.UUID) {
+	trending, err := h.service.Trending(c.Request.Context(), userID, leaderboardLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, trending)
+}
+
+func leaderboardLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 {
+		return 10
+	}
+	if limit > 100 {
+		return 100
+	}
+	return limit
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/service"
+)
+
+type QueryHandler struct {
+	service *service.QueryService
+}
+
+func NewQueryHandler(service *service.QueryService) *QueryHandler {
+	return &QueryHandler{service: service}
+}
+
+// Query godoc
+// @Summary Query click metrics over the user's links
+// @Description Groups clicks by up to three dimensions (day, hour, link, device, browser, os, country, referer, channel, bot, tag). Filters on different dimensions are ANDed; values of one filter are ORed. A link with several tags counts once per tag when grouping by tag. Uniques are counted from raw clicks and only cover the retention period and links that store clicks.
+// @Tags stats
+// @Produce json
+// @Security BearerAuth
+// @Param metrics query string false "Comma-separated: clicks (default), uniques"
+// @Param group_by query string false "Comma-separated dimensions, e.g. day,device"
+// @Param filter query []string false "dimension:value[,value...], e.g. country:TR; repeatable"
+// @Param code query string false "Only this short code (default: all the user's links)"
+// @Param from query string false "Start, RFC 3339 or YYYY-MM-DD (default: 30 days before to)"
+// @Param to query string false "End, exclusive, RFC 3339 or YYYY-MM-DD (default: now)"
+// @Param include_bots query bool false "Include bot and crawler traffic"
+// @Param limit query int false "Maximum rows (default 100, max 1000)"
+// @Success 200 {object} models.AnalyticsResult
+// @Router /api/stats/query [get]
+func (h *QueryHandler) Query(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	q, err := analyticsQuery(c, userID.(uuid.UUID))
+	if err == nil {
+		err = h.service.Normalize(&q)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.service.Run(q)
+	if err != nil {
+		log.Printf("Analytics query for user %s failed: %v", q.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to run query"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func analyticsQuery(c *gin.Context, userID uuid.UUID) (models.AnalyticsQuery, error) {
+	// The time range is parsed the same way as for exports
+	export, err := exportQuery(c, userID)
+	if err != nil {
+		return models.AnalyticsQuery{}, err
+	}
+
+	q := models.AnalyticsQuery{
+		UserID:    userID,
+		ShortCode: export.ShortCode,
+		Metrics:   listParam(c, "metrics"),
+		GroupBy:   listParam(c, "group_by"),
+		From:      export.From,
+		To:        export.To,
+		Filter:    export.Filter,
+	}
+
+	for _, raw := range c.QueryArray("filter") {
+		dim, values, ok := strings.Cut(raw, ":")
+		if !ok || dim == "" || values == "" {
+			return q, errors.New("invalid filter: use dimension:value[,value...]")
+		}
+		q.Filters = append(q.Filters, models.QueryFilter{
+			Dimension: strings.TrimSpace(dim),
+			Values:    strings.Split(values, ","),
+		})
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil {
+			return q, errors.New("invalid limit")
+		}
+	}
+	return q, nil
+}
+
+// listParam collects a query parameter given either comma-separated or
+// repeated.
+func listParam(c *gin.Context, name string) []string {
+	var list []string
+	for _, value := range c.QueryArray(name) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/report"
+	"github.com/urlshortener/stats-service/internal/service"
+	"gorm.io/gorm"
+)
+
+// The unsubscribe link only shows this page, so mail scanners and link
+// previews that fetch it do not unsubscribe anyone; the form posts back to
+// the same URL
+var confirmUnsubscribePage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body style="font-family:Arial,Helvetica,sans-serif;text-align:center;padding:48px;">
+<h1>Unsubscribe from {{.Frequency}} link reports?</h1>
+<p>{{.Email}} will no longer receive them.</p>
+<form method="post" action=""><button type="submit">Unsubscribe</button></form>
+</body></html>`))
+
+var unsubscribedPage = template.Must(template.New("unsubscribed").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Unsubscribed</title></head>
+<body style="font-family:Arial,Helvetica,sans-serif;text-align:center;padding:48px;">
+<h1>You are unsubscribed</h1>
+<p>{{.Email}} will no longer receive {{.Frequency}} link reports.</p>
+</body></html>`))
+
+type ReportHandler struct {
+	service *service.ReportService
+}
+
+func NewReportHandler(service *service.ReportService) *ReportHandler {
+	return &ReportHandler{service: service}
+}
+
+// ListSubscriptions godoc
+// @Summary List the user's report subscriptions
+// @Tags reports
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.ReportSubscription
+// @Router /api/stats/reports [get]
+func (h *ReportHandler) ListSubscriptions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	subs, err := h.service.ListSubscriptions(userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, subs)
+}
+
+// Subscribe godoc
+// @Summary Subscribe to daily, weekly or monthly email reports
+// @Description Creates or updates the subscription for the frequency. Reports go to the account's verified email address at REPORT_SEND_HOUR (UTC) after each period ends.
+// @Tags reports
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.ReportSubscriptionRequest true "Subscription"
+// @Success 200 {object} models.ReportSubscription
+// @Failure 403 {object} map[string]interface{}
+// @Router /api/stats/reports [put]
+func (h *ReportHandler) Subscribe(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req models.ReportSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sub, err := h.service.Subscribe(userID.(uuid.UUID), c.GetString("email"), c.GetBool("email_verified"), &req)
+	if errors.Is(err, service.ErrEmailUnverified) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, sub)
+}
+
+// DeleteSubscription godoc
+// @Summary Delete a report subscription and its send log
+// @Tags reports
+// @Security BearerAuth
+// @Param id path string true "Subscription ID"
+// @Success 204
+// @Router /api/stats/reports/{id} [delete]
+func (h *ReportHandler) DeleteSubscription(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid subscription ID"})
+		return
+	}
+
+	err = h.service.DeleteSubscription(userID.(uuid.UUID), id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "report subscription not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// SendNow godoc
+// @Summary Email the report for the last complete period now
+// @Tags reports
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Subscription ID"
+// @Success 200 {object} models.ReportDelivery
+// @Failure 502 {object} models.ReportDelivery
+// @Router /api/stats/reports/{id}/send [post]
+func (h *ReportHandler) SendNow(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid subscription ID"})
+		return
+	}
+
+	delivery, err := h.service.SendNow(userID.(uuid.UUID), id)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "report subscription not found"})
+	case errors.Is(err, service.ErrMailDisabled):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case delivery != nil && delivery.Status == models.ReportFailed:
+		c.JSON(http.StatusBadGateway, delivery)
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, delivery)
+	}
+}
+
+// Preview godoc
+// @Summary Render the report for the last complete period
+// @Tags reports
+// @Produce html,plain,json
+// @Security BearerAuth
+// @Param frequency query string false "daily, weekly (default) or monthly"
+// @Param format query string false "html (default), text or json"
+// @Success 200
+// @Router /api/stats/reports/preview [get]
+func (h *ReportHandler) Preview(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	frequency := c.DefaultQuery("frequency", models.ReportWeekly)
+	switch frequency {
+	case models.ReportDaily, models.ReportWeekly, models.ReportMonthly:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "frequency must be daily, weekly or monthly"})
+		return
+	}
+
+	r, err := h.service.Preview(userID.(uuid.UUID), frequency)
+	if err != nil {
+		log.Printf("Report preview for user %s failed: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build report"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "html")
+	if format == "json" {
+		c.JSON(http.StatusOK, r)
+		return
+	}
+
+	html, text, err := report.Render(r)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if format == "text" {
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(text))
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(html))
+}
+
+// ListDeliveries godoc
+// @Summary Report send log
+// @Tags reports
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.ReportDelivery
+// @Router /api/stats/reports/deliveries [get]
+func (h *ReportHandler) ListDeliveries(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	deliveries, err := h.service.ListDeliveries(userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// ConfirmUnsubscribe godoc
+// @Summary Ask to confirm unsubscribing via the link in a report email
+// @Tags reports
+// @Produce html
+// @Param token query string true "Unsubscribe token"
+// @Success 200
+// @Router /api/stats/reports/unsubscribe [get]
+func (h *ReportHandler) ConfirmUnsubscribe(c *gin.Context) {
+	sub, err := h.service.FindByUnsubscribeToken(c.Query("token"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown unsubscribe link"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	confirmUnsubscribePage.Execute(c.Writer, sub)
+}
+
+// Unsubscribe godoc
+// @Summary Unsubscribe from a report
+// @Description Posted by the confirmation page, and by mail clients as one-click unsubscribe (RFC 8058).
+// @Tags reports
+// @Produce html
+// @Param token query string true "Unsubscribe token"
+// @Success 200
+// @Router /api/stats/reports/unsubscribe [post]
+func (h *ReportHandler) Unsubscribe(c *gin.Context) {
+	sub, err := h.service.Unsubscribe(c.Query("token"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown unsubscribe link"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	unsubscribedPage.Execute(c.Writer, sub)
+}
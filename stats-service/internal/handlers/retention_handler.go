@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/service"
+)
+
+type RetentionHandler struct {
+	service *service.RetentionService
+}
+
+func NewRetentionHandler(service *service.RetentionService) *RetentionHandler {
+	return &RetentionHandler{service: service}
+}
+
+// GetPolicy godoc
+// @Summary Get the raw click retention for the user's links
+// @Tags retention
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.RetentionPolicy
+// @Router /api/stats/retention [get]
+func (h *RetentionHandler) GetPolicy(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	policy, err := h.service.GetPolicy(userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// SetOverride godoc
+// @Summary Override the raw click retention for the user's links
+// @Tags retention
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.RetentionRequest true "Retention in days, at most CLICK_RETENTION_DAYS (0 keeps forever, only when there is no default)"
+// @Success 200 {object} models.RetentionPolicy
+// @Router /api/stats/retention [put]
+func (h *RetentionHandler) SetOverride(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req models.RetentionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy, err := h.service.SetOverride(userID.(uuid.UUID), *req.Days)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// ClearOverride godoc
+// @Summary Revert to the default raw click retention
+// @Tags retention
+// @Security BearerAuth
+// @Success 204
+// @Router /api/stats/retention [delete]
+func (h *RetentionHandler) ClearOverride(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if err := h.service.ClearOverride(userID.(uuid.UUID)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetPurgeReport godoc
+// @Summary Dry-run report of the user's raw clicks due to be purged
+// @Tags retention
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.PurgeReport
+// @Router /api/stats/retention/report [get]
+func (h *RetentionHandler) GetPurgeReport(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	report, err := h.service.Report(userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -5,6 +5,8 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
 	"github.com/urlshortener/stats-service/internal/service"
 )
 
@@ -21,12 +23,13 @@ func NewStatsHandler(service *service.StatsService) *StatsHandler {
 // @Tags stats
 // @Produce json
 // @Param code path string true "Short code"
+// @Param include_bots query bool false "Include bot and crawler traffic"
 // @Success 200 {object} models.URLStats
 // @Router /api/stats/{code} [get]
 func (h *StatsHandler) GetURLStats(c *gin.Context) {
 	code := c.Param("code")
 
-	stats, err := h.service.GetURLStats(code)
+	stats, err := h.service.GetURLStats(code, statsFilter(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -39,10 +42,11 @@ func (h *StatsHandler) GetURLStats(c *gin.Context) {
 // @Summary Get overall stats
 // @Tags stats
 // @Produce json
+// @Param include_bots query bool false "Include bot and crawler traffic"
 // @Success 200 {object} models.OverallStats
 // @Router /api/stats/overall [get]
 func (h *StatsHandler) GetOverallStats(c *gin.Context) {
-	stats, err := h.service.GetOverallStats()
+	stats, err := h.service.GetOverallStats(statsFilter(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -55,13 +59,21 @@ func (h *StatsHandler) GetOverallStats(c *gin.Context) {
 // @Summary Get recent clicks
 // @Tags stats
 // @Produce json
+// @Security BearerAuth
 // @Param limit query int false "Limit"
+// @Param include_bots query bool false "Include bot and crawler traffic"
 // @Success 200 {array} models.Click
 // @Router /api/stats/recent [get]
 func (h *StatsHandler) GetRecentClicks(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 
-	clicks, err := h.service.GetRecentClicks(limit)
+	clicks, err := h.service.GetRecentClicks(userID.(uuid.UUID), limit, statsFilter(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -70,6 +82,12 @@ func (h *StatsHandler) GetRecentClicks(c *gin.Context) {
 	c.JSON(http.StatusOK, clicks)
 }
 
+// statsFilter reads the common stats query parameters.
+func statsFilter(c *gin.Context) models.StatsFilter {
+	includeBots, _ := strconv.ParseBool(c.DefaultQuery("include_bots", "false"))
+	return models.StatsFilter{IncludeBots: includeBots}
+}
+
 func (h *StatsHandler) Health(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "healthy", "service": "stats-service"})
 }
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/urlshortener/stats-service/internal/stream"
+)
+
+const (
+	heartbeatInterval = 15 * time.Second
+	wsWriteTimeout    = 10 * time.Second
+)
+
+type StreamHandler struct {
+	hub      *stream.Hub
+	tickets  *stream.Tickets
+	upgrader websocket.Upgrader
+}
+
+func NewStreamHandler(hub *stream.Hub, tickets *stream.Tickets) *StreamHandler {
+	return &StreamHandler{
+		hub:     hub,
+		tickets: tickets,
+		upgrader: websocket.Upgrader{
+			// Origins are already open via CORS; auth is by bearer token
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+// IssueTicket godoc
+// @Summary Get a ticket for opening a stream without an Authorization header
+// @Description For EventSource and browser WebSockets: pass it as ?ticket= to /stream or /stream/ws within 30 seconds. Each ticket opens one stream.
+// @Tags stats
+// @Produce json
+// @Security BearerAuth
+// @Success 201 {object} map[string]interface{}
+// @Router /api/stats/stream/ticket [post]
+func (h *StreamHandler) IssueTicket(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	ticket, err := h.tickets.Issue(c.Request.Context(), stream.Ticket{
+		UserID:        userID.(uuid.UUID),
+		Email:         c.GetString("email"),
+		EmailVerified: c.GetBool("email_verified"),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"ticket": ticket, "expires_in": int(stream.TicketTTL.Seconds())})
+}
+
+// Stream godoc
+// @Summary Live clicks on the user's links (Server-Sent Events)
+// @Tags stats
+// @Produce text/event-stream
+// @Security BearerAuth
+// @Param ticket query string false "Ticket from /stream/ticket, instead of the Authorization header"
+// @Param code query []string false "Only these short codes"
+// @Param include_bots query bool false "Include bot and crawler traffic"
+// @Param Last-Event-ID header string false "Resume after this event"
+// @Success 200
+// @Router /api/stats/stream [get]
+func (h *StreamHandler) Stream(c *gin.Context) {
+	filter, ok := streamFilter(c)
+	if !ok {
+		return
+	}
+
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+	resumeFrom, _ := strconv.ParseUint(lastID, 10, 64)
+
+	sub := h.hub.Subscribe(filter, resumeFrom)
+	defer h.hub.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	fmt.Fprint(w, "retry: 3000\n\n")
+	w.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			w.Flush()
+		case event, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind: tell the client to reconnect,
+				// it will resume from its Last-Event-ID
+				if sub.Lagged() {
+					fmt.Fprint(w, "event: lagged\ndata: {}\n\n")
+					w.Flush()
+				}
+				return
+			}
+			data, err := json.Marshal(event.Click)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: click\ndata: %s\n\n", event.ID, data)
+			w.Flush()
+		}
+	}
+}
+
+// StreamWS godoc
+// @Summary Live clicks on the user's links (WebSocket)
+// @Tags stats
+// @Security BearerAuth
+// @Param ticket query string false "Ticket from /stream/ticket, instead of the Authorization header"
+// @Param code query []string false "Only these short codes"
+// @Param include_bots query bool false "Include bot and crawler traffic"
+// @Param last_event_id query int false "Resume after this event"
+// @Success 101
+// @Router /api/stats/stream/ws [get]
+func (h *StreamHandler) StreamWS(c *gin.Context) {
+	filter, ok := streamFilter(c)
+	if !ok {
+		return
+	}
+	resumeFrom, _ := strconv.ParseUint(c.Query("last_event_id"), 10, 64)
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sub := h.hub.Subscribe(filter, resumeFrom)
+	defer h.hub.Unsubscribe(sub)
+
+	// Read side only handles control frames; any error ends the stream
+	closed := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(2 * heartbeatInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * heartbeatInterval))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				reason := "stream closed"
+				if sub.Lagged() {
+					reason = "lagged"
+				}
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, reason),
+					time.Now().Add(wsWriteTimeout))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func streamFilter(c *gin.Context) (stream.Filter, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return stream.Filter{}, false
+	}
+
+	filter := stream.Filter{
+		UserID:      userID.(uuid.UUID),
+		IncludeBots: statsFilter(c).IncludeBots,
+	}
+	if codes := c.QueryArray("code"); len(codes) > 0 {
+		filter.ShortCodes = make(map[string]bool, len(codes))
+		for _, code := range codes {
+			filter.ShortCodes[code] = true
+		}
+	}
+	return filter, true
+}
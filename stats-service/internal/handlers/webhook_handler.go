@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/service"
+	"gorm.io/gorm"
+)
+
+type WebhookHandler struct {
+	service *service.WebhookService
+}
+
+func NewWebhookHandler(service *service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{service: service}
+}
+
+// ListEndpoints godoc
+// @Summary List the user's webhook endpoints
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.WebhookEndpoint
+// @Router /api/stats/webhooks [get]
+func (h *WebhookHandler) ListEndpoints(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	endpoints, err := h.service.ListEndpoints(userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, endpoints)
+}
+
+// CreateEndpoint godoc
+// @Summary Subscribe a webhook endpoint
+// @Description Events: link.created, link.updated, link.deleted, link.expired, link.clicked (not sent for bots; all when empty). Payloads are signed in X-Webhook-Signature with the endpoint's secret, which only this response includes.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.WebhookEndpointRequest true "Endpoint"
+// @Success 201 {object} models.CreatedWebhookEndpoint
+// @Router /api/stats/webhooks [post]
+func (h *WebhookHandler) CreateEndpoint(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req models.WebhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	endpoint, err := h.service.CreateEndpoint(userID.(uuid.UUID), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.CreatedWebhookEndpoint{WebhookEndpoint: *endpoint, Secret: endpoint.Secret})
+}
+
+// UpdateEndpoint godoc
+// @Summary Replace a webhook endpoint's settings (set enabled to re-enable it)
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Endpoint ID"
+// @Param request body models.WebhookEndpointRequest true "Endpoint"
+// @Success 200 {object} models.WebhookEndpoint
+// @Router /api/stats/webhooks/{id} [put]
+func (h *WebhookHandler) UpdateEndpoint(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid endpoint ID"})
+		return
+	}
+
+	var req models.WebhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	endpoint, err := h.service.UpdateEndpoint(userID.(uuid.UUID), id, &req)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook endpoint not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, endpoint)
+}
+
+// DeleteEndpoint godoc
+// @Summary Delete a webhook endpoint and its delivery log
+// @Tags webhooks
+// @Security BearerAuth
+// @Param id path string true "Endpoint ID"
+// @Success 204
+// @Router /api/stats/webhooks/{id} [delete]
+func (h *WebhookHandler) DeleteEndpoint(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid endpoint ID"})
+		return
+	}
+
+	err = h.service.DeleteEndpoint(userID.(uuid.UUID), id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook endpoint not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListDeliveries godoc
+// @Summary Webhook delivery log
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param endpoint_id query string false "Only deliveries to this endpoint"
+// @Param limit query int false "Limit (default 50, max 500)"
+// @Success 200 {array} models.WebhookDelivery
+// @Router /api/stats/webhooks/deliveries [get]
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var endpointID *uuid.UUID
+	if value := c.Query("endpoint_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid endpoint ID"})
+			return
+		}
+		endpointID = &id
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	deliveries, err := h.service.ListDeliveries(userID.(uuid.UUID), endpointID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// Redeliver godoc
+// @Summary Queue a past delivery's event again
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Delivery ID"
+// @Success 202 {object} models.WebhookDelivery
+// @Router /api/stats/webhooks/deliveries/{id}/redeliver [post]
+func (h *WebhookHandler) Redeliver(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid delivery ID"})
+		return
+	}
+
+	delivery, err := h.service.Redeliver(userID.(uuid.UUID), id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "delivery not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/urlshortener/shared/auth"
+	"github.com/urlshortener/stats-service/internal/stream"
+)
+
+// AuthMiddleware accepts access tokens only: managing alerts, webhooks and
+// the like needs a login. All routes share validator, and with it one HTTP
+// client, cache and breaker.
+func AuthMiddleware(validator *auth.Validator) gin.HandlerFunc {
+	return authenticate(validator, false)
+}
+
+// ReadAuthMiddleware also accepts API keys with the stats:read scope, for
+// the read-only stats, query and export routes.
+func ReadAuthMiddleware(validator *auth.Validator) gin.HandlerFunc {
+	return authenticate(validator, true)
+}
+
+func authenticate(validator *auth.Validator, allowAPIKeys bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "authorization header required"})
+			c.Abort()
+			return
+		}
+
+		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+		if tokenString == authHeader {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "bearer token required"})
+			c.Abort()
+			return
+		}
+
+		apiKey := auth.IsAPIKey(tokenString)
+		if apiKey && !allowAPIKeys {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot be used here, log in instead"})
+			c.Abort()
+			return
+		}
+
+		identity, err := validator.Validate(c.Request.Context(), tokenString, c.ClientIP())
+		if errors.Is(err, auth.ErrUnavailable) {
+			log.Printf("Auth: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "authentication temporarily unavailable"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			message := "invalid token"
+			if apiKey {
+				message = "invalid API key"
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": message})
+			c.Abort()
+			return
+		}
+
+		if apiKey {
+			if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+				c.JSON(http.StatusForbidden, gin.H{"error": "API keys can only read stats"})
+				c.Abort()
+				return
+			}
+			if !identity.Allows(auth.ScopeStatsRead) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + auth.ScopeStatsRead + " scope"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Set("user_id", identity.UserID)
+		c.Set("email", identity.Email)
+		c.Set("email_verified", identity.EmailVerified)
+		c.Next()
+	}
+}
+
+// StreamAuth authenticates the live stream routes. Clients that cannot set
+// headers (EventSource, browser WebSockets) pass a ticket from
+// POST /api/stats/stream/ticket as ?ticket= instead: bearer tokens are never
+// taken from the URL, where access logs would keep them.
+func StreamAuth(validator *auth.Validator, tickets *stream.Tickets) gin.HandlerFunc {
+	headerAuth := AuthMiddleware(validator)
+
+	return func(c *gin.Context) {
+		value := c.Query("ticket")
+		if value == "" {
+			headerAuth(c)
+			return
+		}
+
+		ticket, err := tickets.Redeem(c.Request.Context(), value)
+		if errors.Is(err, stream.ErrInvalidTicket) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			log.Printf("Auth: redeeming stream ticket: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "authentication temporarily unavailable"})
+			c.Abort()
+			return
+		}
+
+		c.Set("user_id", ticket.UserID)
+		c.Set("email", ticket.Email)
+		c.Set("email_verified", ticket.EmailVerified)
+		c.Next()
+	}
+}
+
+// AdminOnly allows only users whose email is listed in ADMIN_EMAILS
+// (comma-separated). It must run after AuthMiddleware.
+func AdminOnly() gin.HandlerFunc {
+	admins := make(map[string]bool)
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			admins[email] = true
+		}
+	}
+
+	return func(c *gin.Context) {
+		if !admins[strings.ToLower(c.GetString("email"))] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
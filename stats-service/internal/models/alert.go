@@ -0,0 +1,108 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type AlertType string
+
+const (
+	// AlertSpike fires when a link gets more than Threshold clicks in a minute.
+	AlertSpike AlertType = "spike"
+	// AlertDrop fires when a link that had at least Threshold clicks in an
+	// hour then gets none for WindowMinutes.
+	AlertDrop AlertType = "drop"
+	// AlertNewCountry fires on the first click on a link from a country it
+	// had no clicks from before.
+	AlertNewCountry AlertType = "new_country"
+)
+
+type AlertDeliveryStatus string
+
+const (
+	AlertPending   AlertDeliveryStatus = "pending"
+	AlertDelivered AlertDeliveryStatus = "delivered"
+	AlertFailed    AlertDeliveryStatus = "failed"
+)
+
+// AlertRule is evaluated against the owner's clicks, on one link or on all
+// of their links when ShortCode is empty. Alerts are posted to WebhookURL,
+// signed with Secret.
+type AlertRule struct {
+	ID              uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID          uuid.UUID  `gorm:"type:uuid;index;not null" json:"user_id"`
+	ShortCode       string     `json:"short_code"`
+	Type            AlertType  `gorm:"not null" json:"type"`
+	Threshold       int64      `json:"threshold"`
+	WindowMinutes   int        `json:"window_minutes"`
+	WebhookURL      string     `gorm:"not null" json:"webhook_url"`
+	Secret          string     `gorm:"not null" json:"-"`
+	Enabled         bool       `gorm:"default:true" json:"enabled"`
+	CooldownMinutes int        `gorm:"default:60" json:"cooldown_minutes"`
+	LastTriggeredAt *time.Time `json:"last_triggered_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// CreatedAlertRule is the response to creating a rule, the only one that
+// includes its secret.
+type CreatedAlertRule struct {
+	AlertRule
+	Secret string `json:"secret"`
+}
+
+func (r *AlertRule) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+type AlertRuleRequest struct {
+	ShortCode       string    `json:"short_code"`
+	Type            AlertType `json:"type" binding:"required,oneof=spike drop new_country"`
+	Threshold       int64     `json:"threshold" binding:"min=0"`
+	WindowMinutes   int       `json:"window_minutes" binding:"min=0"`
+	WebhookURL      string    `json:"webhook_url" binding:"required,url"`
+	Enabled         *bool     `json:"enabled"`
+	CooldownMinutes *int      `json:"cooldown_minutes" binding:"omitempty,min=0"`
+}
+
+// Alert is one triggering of a rule together with the state of its webhook
+// delivery.
+type Alert struct {
+	ID            uuid.UUID           `gorm:"type:uuid;primary_key" json:"id"`
+	RuleID        uuid.UUID           `gorm:"type:uuid;index;not null" json:"rule_id"`
+	UserID        uuid.UUID           `gorm:"type:uuid;index;not null" json:"user_id"`
+	ShortCode     string              `json:"short_code"`
+	Type          AlertType           `json:"type"`
+	Message       string              `json:"message"`
+	Value         float64             `json:"value"`
+	TriggeredAt   time.Time           `gorm:"index" json:"triggered_at"`
+	Status        AlertDeliveryStatus `gorm:"index;not null" json:"status"`
+	Attempts      int                 `json:"attempts"`
+	LastError     string              `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time          `gorm:"index" json:"next_attempt_at,omitempty"`
+	DeliveredAt   *time.Time          `json:"delivered_at,omitempty"`
+}
+
+func (a *Alert) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// AlertPayload is the JSON body posted to a rule's webhook.
+type AlertPayload struct {
+	ID          uuid.UUID `json:"id"`
+	RuleID      uuid.UUID `json:"rule_id"`
+	Type        AlertType `json:"type"`
+	ShortCode   string    `json:"short_code"`
+	Message     string    `json:"message"`
+	Value       float64   `json:"value"`
+	TriggeredAt time.Time `json:"triggered_at"`
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Comparison modes: several links over one period, or one link (or all the
+// user's links) over a period and a baseline period of the same length.
+const (
+	CompareLinks   = "links"
+	ComparePeriods = "periods"
+)
+
+type ComparisonQuery struct {
+	UserID       uuid.UUID
+	Mode         string
+	ShortCodes   []string
+	From         time.Time
+	To           time.Time
+	BaselineFrom time.Time // periods mode only
+	Granularity  string    // day or hour
+	Breakdowns   []string
+	Filter       StatsFilter
+}
+
+// ComparisonValue is a click count with its difference from the reference
+// series. DeltaPct is omitted when the reference count is zero.
+type ComparisonValue struct {
+	Clicks   int64    `json:"clicks"`
+	Delta    int64    `json:"delta"`
+	DeltaPct *float64 `json:"delta_pct"`
+}
+
+// ComparisonPoint is one time bucket. Points at the same index in different
+// series cover the same offset from the start of their period.
+type ComparisonPoint struct {
+	Bucket string `json:"bucket"`
+	ComparisonValue
+}
+
+type ComparisonBreakdown struct {
+	Value string `json:"value"`
+	ComparisonValue
+}
+
+type ComparisonSeries struct {
+	Label      string                           `json:"label"`
+	ShortCode  string                           `json:"short_code,omitempty"`
+	From       time.Time                        `json:"from"`
+	To         time.Time                        `json:"to"`
+	Total      ComparisonValue                  `json:"total"`
+	Points     []ComparisonPoint                `json:"points"`
+	Breakdowns map[string][]ComparisonBreakdown `json:"breakdowns"`
+}
+
+// Comparison lays series side by side. Deltas are relative to the series
+// labelled Reference: the first link, or the baseline period.
+type Comparison struct {
+	Mode        string             `json:"mode"`
+	Granularity string             `json:"granularity"`
+	Reference   string             `json:"reference"`
+	Series      []ComparisonSeries `json:"series"`
+}
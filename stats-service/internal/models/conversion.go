@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Conversion is a goal reached by a visitor, attributed to the click that
+// brought them. A click converts at most once per name and external ID, so
+// a reloaded thank-you page is not counted twice.
+type Conversion struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	ClickID    uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_conversions_dedupe" json:"click_id"`
+	Name       string     `gorm:"not null;uniqueIndex:idx_conversions_dedupe" json:"name"`
+	ExternalID string     `gorm:"not null;default:'';uniqueIndex:idx_conversions_dedupe" json:"external_id,omitempty"`
+	ShortCode  string     `gorm:"index;not null" json:"short_code"`
+	UserID     *uuid.UUID `gorm:"type:uuid;index" json:"user_id,omitempty"`
+	IsBot      bool       `gorm:"default:false" json:"is_bot"`
+	Revenue    float64    `gorm:"type:numeric(14,2);default:0" json:"revenue"`
+	Currency   string     `gorm:"size:3" json:"currency,omitempty"`
+	Source     string     `json:"source"` // api, pixel or beacon
+	ClickedAt  time.Time  `json:"clicked_at"`
+	CreatedAt  time.Time  `gorm:"index" json:"created_at"`
+}
+
+func (c *Conversion) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+type ConversionRequest struct {
+	ClickID    string  `json:"click_id" form:"click_id" binding:"required"`
+	Name       string  `json:"name" form:"name" binding:"max=64"`
+	ExternalID string  `json:"external_id" form:"external_id" binding:"max=128"`
+	Revenue    float64 `json:"revenue" form:"revenue" binding:"min=0"`
+	Currency   string  `json:"currency" form:"currency" binding:"omitempty,len=3"`
+}
+
+// ConversionStats sums up a link's conversions. Only the link's owner may
+// see them, unlike its click stats.
+type ConversionStats struct {
+	ShortCode      string             `json:"short_code"`
+	Clicks         int64              `json:"clicks"`
+	Conversions    int64              `json:"conversions"`
+	ConversionRate float64            `json:"conversion_rate"`
+	Revenue        map[string]float64 `json:"revenue"`
+}
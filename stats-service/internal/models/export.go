@@ -0,0 +1,109 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ExportFormat string
+
+const (
+	ExportCSV     ExportFormat = "csv"
+	ExportNDJSON  ExportFormat = "ndjson"
+	ExportParquet ExportFormat = "parquet"
+)
+
+// ExportQuery selects the clicks of an export: the user's links, only
+// ShortCode among them or only those tagged Tag, created in [From, To).
+type ExportQuery struct {
+	UserID    uuid.UUID
+	ShortCode string
+	Tag       string
+	From      time.Time
+	To        time.Time
+	Filter    StatsFilter
+}
+
+// ClickExportRow is one raw click as exported. Client IPs are never exported.
+type ClickExportRow struct {
+	ID             string `json:"id" parquet:"name=id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ShortCode      string `json:"short_code" parquet:"name=short_code, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CreatedAt      string `json:"created_at" parquet:"name=created_at, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Referer        string `json:"referer" parquet:"name=referer, type=BYTE_ARRAY, convertedtype=UTF8"`
+	RefererDomain  string `json:"referer_domain" parquet:"name=referer_domain, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Channel        string `json:"channel" parquet:"name=channel, type=BYTE_ARRAY, convertedtype=UTF8"`
+	UTMSource      string `json:"utm_source" parquet:"name=utm_source, type=BYTE_ARRAY, convertedtype=UTF8"`
+	UTMMedium      string `json:"utm_medium" parquet:"name=utm_medium, type=BYTE_ARRAY, convertedtype=UTF8"`
+	UTMCampaign    string `json:"utm_campaign" parquet:"name=utm_campaign, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Country        string `json:"country" parquet:"name=country, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Device         string `json:"device" parquet:"name=device, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Browser        string `json:"browser" parquet:"name=browser, type=BYTE_ARRAY, convertedtype=UTF8"`
+	BrowserVersion string `json:"browser_version" parquet:"name=browser_version, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OS             string `json:"os" parquet:"name=os, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OSVersion      string `json:"os_version" parquet:"name=os_version, type=BYTE_ARRAY, convertedtype=UTF8"`
+	IsBot          bool   `json:"is_bot" parquet:"name=is_bot, type=BOOLEAN"`
+	BotName        string `json:"bot_name" parquet:"name=bot_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	UserAgent      string `json:"user_agent" parquet:"name=user_agent, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+func NewClickExportRow(click *Click) ClickExportRow {
+	return ClickExportRow{
+		ID:             click.ID.String(),
+		ShortCode:      click.ShortCode,
+		CreatedAt:      click.CreatedAt.UTC().Format(time.RFC3339Nano),
+		Referer:        click.Referer,
+		RefererDomain:  click.RefererDomain,
+		Channel:        click.Channel,
+		UTMSource:      click.UTMSource,
+		UTMMedium:      click.UTMMedium,
+		UTMCampaign:    click.UTMCampaign,
+		Country:        click.Country,
+		Device:         click.Device,
+		Browser:        click.Browser,
+		BrowserVersion: click.BrowserVersion,
+		OS:             click.OS,
+		OSVersion:      click.OSVersion,
+		IsBot:          click.IsBot,
+		BotName:        click.BotName,
+		UserAgent:      click.UserAgent,
+	}
+}
+
+func (ClickExportRow) CSVHeader() []string {
+	return []string{
+		"id", "short_code", "created_at", "referer", "referer_domain", "channel",
+		"utm_source", "utm_medium", "utm_campaign", "country", "device",
+		"browser", "browser_version", "os", "os_version", "is_bot", "bot_name",
+		"user_agent",
+	}
+}
+
+func (r ClickExportRow) CSVRecord() []string {
+	return []string{
+		r.ID, r.ShortCode, r.CreatedAt, r.Referer, r.RefererDomain, r.Channel,
+		r.UTMSource, r.UTMMedium, r.UTMCampaign, r.Country, r.Device,
+		r.Browser, r.BrowserVersion, r.OS, r.OSVersion, strconv.FormatBool(r.IsBot), r.BotName,
+		r.UserAgent,
+	}
+}
+
+// StatsExportRow is one figure of a link's URLStats in long form: the click
+// count for one value of one dimension (day, device, browser, os, bot,
+// referer or channel), or the link's total when Dimension is "total" or
+// "bot_total".
+type StatsExportRow struct {
+	ShortCode string `json:"short_code" parquet:"name=short_code, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Dimension string `json:"dimension" parquet:"name=dimension, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Value     string `json:"value" parquet:"name=value, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Clicks    int64  `json:"clicks" parquet:"name=clicks, type=INT64"`
+}
+
+func (StatsExportRow) CSVHeader() []string {
+	return []string{"short_code", "dimension", "value", "clicks"}
+}
+
+func (r StatsExportRow) CSVRecord() []string {
+	return []string{r.ShortCode, r.Dimension, r.Value, strconv.FormatInt(r.Clicks, 10)}
+}
@@ -0,0 +1,29 @@
+package models
+
+// LeaderboardWindow is the period a leaderboard covers, ending now.
+type LeaderboardWindow string
+
+const (
+	WindowHour LeaderboardWindow = "hour"
+	WindowDay  LeaderboardWindow = "day"
+	WindowWeek LeaderboardWindow = "week"
+)
+
+type LeaderboardEntry struct {
+	ShortCode string `json:"short_code"`
+	Clicks    int64  `json:"clicks"`
+}
+
+type Leaderboard struct {
+	Window  LeaderboardWindow  `json:"window"`
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+// TrendingEntry compares a link's clicks in the last hour with its average
+// hourly clicks over the preceding day.
+type TrendingEntry struct {
+	ShortCode    string  `json:"short_code"`
+	RecentClicks int64   `json:"recent_clicks"`
+	BaselineRate float64 `json:"baseline_rate"`
+	Growth       float64 `json:"growth"`
+}
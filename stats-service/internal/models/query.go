@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Metrics accepted by the analytics query API.
+const (
+	MetricClicks  = "clicks"
+	MetricUniques = "uniques"
+)
+
+// Dimensions accepted by the analytics query API for grouping and filtering.
+const (
+	DimensionDay     = "day"
+	DimensionHour    = "hour"
+	DimensionLink    = "link"
+	DimensionDevice  = "device"
+	DimensionBrowser = "browser"
+	DimensionOS      = "os"
+	DimensionCountry = "country"
+	DimensionReferer = "referer"
+	DimensionChannel = "channel"
+	DimensionBot     = "bot"
+	DimensionTag     = "tag"
+)
+
+// QueryFilter matches clicks whose dimension equals any of Values.
+type QueryFilter struct {
+	Dimension string   `json:"dimension"`
+	Values    []string `json:"values"`
+}
+
+// AnalyticsQuery selects metrics over the user's clicks in [From, To),
+// grouped by GroupBy. Filters on different dimensions are ANDed together.
+type AnalyticsQuery struct {
+	UserID    uuid.UUID     `json:"-"`
+	ShortCode string        `json:"short_code,omitempty"`
+	Metrics   []string      `json:"metrics"`
+	GroupBy   []string      `json:"group_by"`
+	Filters   []QueryFilter `json:"filters,omitempty"`
+	From      time.Time     `json:"from"`
+	To        time.Time     `json:"to"`
+	Limit     int           `json:"limit"`
+	Filter    StatsFilter   `json:"-"`
+}
+
+// AnalyticsRow holds the metrics for one combination of GroupBy values.
+type AnalyticsRow struct {
+	Dimensions map[string]string `json:"dimensions"`
+	Metrics    map[string]int64  `json:"metrics"`
+}
+
+type AnalyticsResult struct {
+	Query AnalyticsQuery `json:"query"`
+	Rows  []AnalyticsRow `json:"rows"`
+}
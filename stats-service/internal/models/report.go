@@ -0,0 +1,90 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	ReportDaily   = "daily"
+	ReportWeekly  = "weekly"
+	ReportMonthly = "monthly"
+)
+
+// ReportSubscription emails the user a summary of the previous day, week
+// (Monday to Sunday) or calendar month. Unsubscribing disables it.
+type ReportSubscription struct {
+	ID               uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID           uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_report_subscriptions_user_frequency" json:"user_id"`
+	Frequency        string     `gorm:"not null;uniqueIndex:idx_report_subscriptions_user_frequency" json:"frequency"`
+	Email            string     `gorm:"not null" json:"email"`
+	Enabled          bool       `json:"enabled"`
+	UnsubscribeToken string     `gorm:"uniqueIndex;not null" json:"-"`
+	NextRunAt        time.Time  `gorm:"index" json:"next_run_at"`
+	LastSentAt       *time.Time `json:"last_sent_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+func (s *ReportSubscription) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// ReportSubscriptionRequest subscribes to reports sent to the account's
+// verified email address; no other address can be given, so reports cannot
+// be used to mail strangers.
+type ReportSubscriptionRequest struct {
+	Frequency string `json:"frequency" binding:"required,oneof=daily weekly monthly"`
+	Enabled   *bool  `json:"enabled"`
+}
+
+type ReportDeliveryStatus string
+
+const (
+	ReportSent   ReportDeliveryStatus = "sent"
+	ReportFailed ReportDeliveryStatus = "failed"
+)
+
+// ReportDelivery logs each attempt to send a report.
+type ReportDelivery struct {
+	ID             uuid.UUID            `gorm:"type:uuid;primary_key" json:"id"`
+	SubscriptionID uuid.UUID            `gorm:"type:uuid;index;not null" json:"subscription_id"`
+	UserID         uuid.UUID            `gorm:"type:uuid;index;not null" json:"user_id"`
+	Email          string               `json:"email"`
+	Frequency      string               `json:"frequency"`
+	PeriodFrom     time.Time            `json:"period_from"`
+	PeriodTo       time.Time            `json:"period_to"`
+	Status         ReportDeliveryStatus `gorm:"not null" json:"status"`
+	Error          string               `json:"error,omitempty"`
+	CreatedAt      time.Time            `gorm:"index" json:"created_at"`
+}
+
+func (d *ReportDelivery) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// Report is the content of one report email. Changes are relative to the
+// period before.
+type Report struct {
+	Frequency      string          `json:"frequency"`
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	Total          ComparisonValue `json:"total"`
+	Links          int             `json:"links"`
+	TopLinks       []ReportLink    `json:"top_links"`
+	DashboardURL   string          `json:"dashboard_url"`
+	UnsubscribeURL string          `json:"unsubscribe_url,omitempty"`
+}
+
+type ReportLink struct {
+	ShortCode string `json:"short_code"`
+	ComparisonValue
+}
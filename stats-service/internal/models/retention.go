@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RetentionOverride replaces the default raw click retention for clicks on a
+// user's links. Days == 0 keeps them forever.
+type RetentionOverride struct {
+	UserID    uuid.UUID `gorm:"type:uuid;primary_key" json:"user_id"`
+	Days      int       `gorm:"not null" json:"days"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type RetentionRequest struct {
+	Days *int `json:"days" binding:"required,min=0"`
+}
+
+type RetentionPolicy struct {
+	Days       int  `json:"days"`
+	Overridden bool `json:"overridden"`
+}
+
+// PurgeScope is one retention rule applied by a purge run: either a single
+// user's override or the default for everyone else.
+type PurgeScope struct {
+	UserID *uuid.UUID `json:"user_id,omitempty"`
+	Days   int        `json:"days"`
+	Cutoff time.Time  `json:"cutoff"`
+	Clicks int64      `json:"clicks"`
+}
+
+type PurgeReport struct {
+	DryRun      bool         `json:"dry_run"`
+	GeneratedAt time.Time    `json:"generated_at"`
+	Scopes      []PurgeScope `json:"scopes"`
+	TotalClicks int64        `json:"total_clicks"`
+}
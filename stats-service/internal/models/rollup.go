@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// ClickRollup counts clicks sharing the same dimensions within one bucket.
+// Hourly and daily rollups share this layout; every dimension is part of the
+// primary key so the compactor can upsert into it.
+type ClickRollup struct {
+	Bucket        time.Time `gorm:"primaryKey;autoIncrement:false"`
+	ShortCode     string    `gorm:"primaryKey;index"`
+	Device        string    `gorm:"primaryKey"`
+	Browser       string    `gorm:"primaryKey"`
+	OS            string    `gorm:"primaryKey"`
+	Country       string    `gorm:"primaryKey"`
+	RefererDomain string    `gorm:"primaryKey"`
+	Channel       string    `gorm:"primaryKey"`
+	IsBot         bool      `gorm:"primaryKey;autoIncrement:false"`
+	BotName       string    `gorm:"primaryKey"`
+	Clicks        int64     `gorm:"not null;default:0"`
+}
+
+type HourlyClickRollup struct {
+	ClickRollup
+}
+
+func (HourlyClickRollup) TableName() string {
+	return "click_rollups_hourly"
+}
+
+type DailyClickRollup struct {
+	ClickRollup
+}
+
+func (DailyClickRollup) TableName() string {
+	return "click_rollups_daily"
+}
+
+// AggregateHourlyClickRollup and AggregateDailyClickRollup count the clicks
+// of links that skip raw click storage. Nothing else records those clicks,
+// so they are kept apart from the rollups compacted from raw clicks, which a
+// rebuild discards and recomputes.
+type AggregateHourlyClickRollup struct {
+	ClickRollup
+}
+
+func (AggregateHourlyClickRollup) TableName() string {
+	return "aggregate_click_rollups_hourly"
+}
+
+type AggregateDailyClickRollup struct {
+	ClickRollup
+}
+
+func (AggregateDailyClickRollup) TableName() string {
+	return "aggregate_click_rollups_daily"
+}
+
+// RollupState records how far raw clicks have been compacted: every click
+// with created_at before Watermark is reflected in the rollup tables.
+// Retention may have purged raw clicks before PurgedBefore, so the rollups
+// up to there can no longer be recomputed.
+type RollupState struct {
+	ID           int       `gorm:"primaryKey;autoIncrement:false"`
+	Watermark    time.Time `gorm:"not null"`
+	PurgedBefore time.Time
+	UpdatedAt    time.Time
+}
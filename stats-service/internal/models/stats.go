@@ -8,15 +8,26 @@ import (
 )
 
 type Click struct {
-	ID        uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
-	ShortCode string    `gorm:"index;not null" json:"short_code"`
-	UserAgent string    `json:"user_agent"`
-	IP        string    `json:"ip"`
-	Referer   string    `json:"referer"`
-	Country   string    `json:"country"`
-	Device    string    `json:"device"`
-	Browser   string    `json:"browser"`
-	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	ShortCode      string     `gorm:"index;not null" json:"short_code"`
+	UserID         *uuid.UUID `gorm:"type:uuid;index" json:"user_id,omitempty"`
+	UserAgent      string     `json:"user_agent"`
+	IP             string     `json:"-"`
+	Referer        string     `json:"referer"`
+	RefererDomain  string     `gorm:"index" json:"referer_domain"`
+	Channel        string     `gorm:"index" json:"channel"`
+	UTMSource      string     `json:"utm_source,omitempty"`
+	UTMMedium      string     `json:"utm_medium,omitempty"`
+	UTMCampaign    string     `json:"utm_campaign,omitempty"`
+	Country        string     `json:"country"`
+	Device         string     `json:"device"`
+	Browser        string     `json:"browser"`
+	BrowserVersion string     `json:"browser_version"`
+	OS             string     `json:"os"`
+	OSVersion      string     `json:"os_version"`
+	IsBot          bool       `gorm:"default:false" json:"is_bot"`
+	BotName        string     `json:"bot_name,omitempty"`
+	CreatedAt      time.Time  `gorm:"index" json:"created_at"`
 }
 
 func (c *Click) BeforeCreate(tx *gorm.DB) error {
@@ -26,21 +37,63 @@ func (c *Click) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// LinkOwner remembers who owns a short code so account-wide stats can be
+// answered from the rollups, which are not keyed by user.
+type LinkOwner struct {
+	ShortCode string    `gorm:"primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;index;not null"`
+	CreatedAt time.Time
+}
+
+// LinkTag holds one of the tags set on a link in url-service, so clicks can
+// be grouped and filtered by tag.
+type LinkTag struct {
+	ShortCode string `gorm:"primary_key"`
+	Tag       string `gorm:"primary_key;index"`
+}
+
 type ClickEvent struct {
-	ShortCode string    `json:"short_code"`
-	UserAgent string    `json:"user_agent"`
-	IP        string    `json:"ip"`
-	Referer   string    `json:"referer"`
-	Timestamp time.Time `json:"timestamp"`
+	ClickID   *uuid.UUID `json:"click_id,omitempty"`
+	ShortCode string     `json:"short_code"`
+	UserID    *uuid.UUID `json:"user_id,omitempty"`
+	UserAgent string     `json:"user_agent"`
+	IP        string     `json:"ip"`
+	Referer   string     `json:"referer"`
+	Country   string     `json:"country,omitempty"`
+	IsBot     bool       `json:"is_bot"`
+	Timestamp time.Time  `json:"timestamp"`
+	UTM
+
+	// DoNotTrack is set when the visitor sent DNT or Sec-GPC; only derived,
+	// non-identifying fields are stored for such clicks.
+	DoNotTrack bool `json:"do_not_track,omitempty"`
+	// SkipClickStorage marks links whose clicks are counted in aggregates only.
+	SkipClickStorage bool `json:"skip_click_storage,omitempty"`
+}
+
+type UTM struct {
+	Source   string `json:"utm_source,omitempty"`
+	Medium   string `json:"utm_medium,omitempty"`
+	Campaign string `json:"utm_campaign,omitempty"`
+}
+
+// StatsFilter narrows which clicks are counted. Bot traffic is excluded
+// unless IncludeBots is set.
+type StatsFilter struct {
+	IncludeBots bool
 }
 
 type URLStats struct {
 	ShortCode   string         `json:"short_code"`
 	TotalClicks int64          `json:"total_clicks"`
+	BotClicks   int64          `json:"bot_clicks,omitempty"`
 	ByDay       []DayStats     `json:"by_day"`
 	ByDevice    []DeviceStats  `json:"by_device"`
 	ByBrowser   []BrowserStats `json:"by_browser"`
+	ByOS        []OSStats      `json:"by_os"`
+	ByBot       []BotStats     `json:"by_bot,omitempty"`
 	ByReferer   []RefererStats `json:"by_referer"`
+	ByChannel   []ChannelStats `json:"by_channel"`
 }
 
 type DayStats struct {
@@ -58,14 +111,53 @@ type BrowserStats struct {
 	Count   int64  `json:"count"`
 }
 
+type OSStats struct {
+	OS    string `json:"os"`
+	Count int64  `json:"count"`
+}
+
+type BotStats struct {
+	Bot   string `json:"bot"`
+	Count int64  `json:"count"`
+}
+
 type RefererStats struct {
 	Referer string `json:"referer"`
 	Count   int64  `json:"count"`
 }
 
+type ChannelStats struct {
+	Channel string `json:"channel"`
+	Count   int64  `json:"count"`
+}
+
 type OverallStats struct {
-	TotalURLs    int64 `json:"total_urls"`
-	TotalClicks  int64 `json:"total_clicks"`
-	TodayClicks  int64 `json:"today_clicks"`
-	ActiveURLs   int64 `json:"active_urls"`
+	TotalURLs   int64 `json:"total_urls"`
+	TotalClicks int64 `json:"total_clicks"`
+	TodayClicks int64 `json:"today_clicks"`
+	ActiveURLs  int64 `json:"active_urls"`
+}
+
+// UserDeleted is published by user-service on the "user:events" channel
+// once an account is gone, and repeated until answered with
+// UserDeletionAcked on the "user:acks" channel.
+const (
+	UserDeleted       = "user.deleted"
+	UserDeletionAcked = "user.deletion_acked"
+)
+
+// UserEmailChanged is published by user-service when a user changes their
+// email address, unverified at first, and again once they verify it.
+const UserEmailChanged = "user.email_changed"
+
+// UserEvent announces a change to an account, or acknowledges one.
+type UserEvent struct {
+	Type   string    `json:"type"`
+	UserID uuid.UUID `json:"user_id"`
+	// The acknowledging service
+	Service string `json:"service,omitempty"`
+	// The new address, for UserEmailChanged
+	Email         string    `json:"email,omitempty"`
+	EmailVerified bool      `json:"email_verified,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
 }
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Webhook event types. Link lifecycle events come from url-service, clicks
+// from the click stream.
+const (
+	EventLinkCreated = "link.created"
+	EventLinkUpdated = "link.updated"
+	EventLinkDeleted = "link.deleted"
+	EventLinkExpired = "link.expired"
+	EventLinkClicked = "link.clicked"
+)
+
+var WebhookEventTypes = []string{
+	EventLinkCreated, EventLinkUpdated, EventLinkDeleted, EventLinkExpired, EventLinkClicked,
+}
+
+// LinkEvent is a link lifecycle event as published by url-service.
+type LinkEvent struct {
+	Type      string          `json:"type"`
+	UserID    *uuid.UUID      `json:"user_id,omitempty"`
+	Link      json.RawMessage `json:"link"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// WebhookEndpoint receives the owner's events of the listed types (all types
+// when Events is empty). Endpoints that keep failing are disabled.
+type WebhookEndpoint struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID         uuid.UUID  `gorm:"type:uuid;index;not null" json:"user_id"`
+	URL            string     `gorm:"not null" json:"url"`
+	Secret         string     `gorm:"not null" json:"-"`
+	Events         []string   `gorm:"serializer:json" json:"events"`
+	Enabled        bool       `gorm:"default:true" json:"enabled"`
+	FailureCount   int        `json:"failure_count"`
+	FailingSince   *time.Time `json:"failing_since,omitempty"`
+	DisabledReason string     `json:"disabled_reason,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// CreatedWebhookEndpoint is the response to creating an endpoint, the only
+// one that includes its secret.
+type CreatedWebhookEndpoint struct {
+	WebhookEndpoint
+	Secret string `json:"secret"`
+}
+
+func (e *WebhookEndpoint) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
+
+// Subscribed reports whether the endpoint wants events of eventType.
+func (e *WebhookEndpoint) Subscribed(eventType string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookEndpointRequest struct {
+	URL     string   `json:"url" binding:"required,url"`
+	Events  []string `json:"events"`
+	Enabled *bool    `json:"enabled"`
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	DeliveryPending   WebhookDeliveryStatus = "pending"
+	DeliveryDelivered WebhookDeliveryStatus = "delivered"
+	DeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one attempt series to deliver an event to an endpoint.
+// Redeliveries create a new delivery for the same EventID.
+type WebhookDelivery struct {
+	ID             uuid.UUID             `gorm:"type:uuid;primary_key" json:"id"`
+	EndpointID     uuid.UUID             `gorm:"type:uuid;index;not null" json:"endpoint_id"`
+	UserID         uuid.UUID             `gorm:"type:uuid;index;not null" json:"user_id"`
+	EventID        uuid.UUID             `gorm:"type:uuid;index;not null" json:"event_id"`
+	EventType      string                `gorm:"not null" json:"event_type"`
+	Payload        string                `gorm:"type:text;not null" json:"payload"`
+	Status         WebhookDeliveryStatus `gorm:"index;not null" json:"status"`
+	Attempts       int                   `json:"attempts"`
+	ResponseStatus int                   `json:"response_status,omitempty"`
+	LastError      string                `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time            `gorm:"index" json:"next_attempt_at,omitempty"`
+	DeliveredAt    *time.Time            `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time             `gorm:"index" json:"created_at"`
+}
+
+func (d *WebhookDelivery) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// WebhookPayload is the JSON body posted for every event.
+type WebhookPayload struct {
+	ID        uuid.UUID   `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
@@ -0,0 +1,86 @@
+// Package report renders analytics report emails.
+package report
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+
+	"github.com/urlshortener/stats-service/internal/models"
+)
+
+//go:embed report.html
+var htmlSource string
+
+//go:embed report.txt
+var textSource string
+
+var funcs = map[string]interface{}{
+	"title":  title,
+	"period": period,
+	"change": change,
+}
+
+var (
+	htmlTemplate = htmltemplate.Must(htmltemplate.New("report.html").Funcs(funcs).Parse(htmlSource))
+	textTemplate = texttemplate.Must(texttemplate.New("report.txt").Funcs(funcs).Parse(textSource))
+)
+
+// Subject returns the email subject for r.
+func Subject(r *models.Report) string {
+	return fmt.Sprintf("%s link report: %s", title(r.Frequency), period(r))
+}
+
+// Render returns the HTML and plain-text bodies for r.
+func Render(r *models.Report) (html, text string, err error) {
+	var h, t bytes.Buffer
+	if err := htmlTemplate.Execute(&h, r); err != nil {
+		return "", "", err
+	}
+	if err := textTemplate.Execute(&t, r); err != nil {
+		return "", "", err
+	}
+	return h.String(), t.String(), nil
+}
+
+func title(frequency string) string {
+	switch frequency {
+	case models.ReportDaily:
+		return "Daily"
+	case models.ReportWeekly:
+		return "Weekly"
+	default:
+		return "Monthly"
+	}
+}
+
+// period describes the report's range; To is exclusive.
+func period(r *models.Report) string {
+	last := r.To.Add(-1)
+	switch r.Frequency {
+	case models.ReportDaily:
+		return r.From.Format("Mon 2 Jan 2006")
+	case models.ReportMonthly:
+		return r.From.Format("January 2006")
+	default:
+		return r.From.Format("2 Jan") + " – " + last.Format("2 Jan 2006")
+	}
+}
+
+// change formats a delta as "+12 (+25.0%)", or "+12 (new)" when there were
+// no clicks before.
+func change(v models.ComparisonValue) string {
+	sign := ""
+	if v.Delta > 0 {
+		sign = "+"
+	}
+	if v.DeltaPct == nil {
+		if v.Delta == 0 {
+			return "no change"
+		}
+		return fmt.Sprintf("%s%d (new)", sign, v.Delta)
+	}
+	return fmt.Sprintf("%s%d (%s%.1f%%)", sign, v.Delta, sign, *v.DeltaPct)
+}
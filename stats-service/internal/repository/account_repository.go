@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"gorm.io/gorm"
+)
+
+// DeleteUserData removes what is kept about a user besides raw clicks
+// (which PurgeClicks removes in batches): the rollups and tags of their
+// links, link ownership, conversions, alerts, webhooks, reports and
+// retention override.
+func (r *StatsRepository) DeleteUserData(userID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		owned := tx.Model(&models.LinkOwner{}).Select("short_code").Where("user_id = ?", userID)
+		for _, model := range []interface{}{
+			&models.HourlyClickRollup{}, &models.DailyClickRollup{},
+			&models.AggregateHourlyClickRollup{}, &models.AggregateDailyClickRollup{},
+			&models.LinkTag{},
+		} {
+			if err := tx.Where("short_code IN (?)", owned).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, model := range []interface{}{
+			&models.LinkOwner{},
+			&models.Conversion{},
+			&models.Alert{},
+			&models.AlertRule{},
+			&models.WebhookDelivery{},
+			&models.WebhookEndpoint{},
+			&models.ReportDelivery{},
+			&models.ReportSubscription{},
+			&models.RetentionOverride{},
+		} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+)
+
+func (r *StatsRepository) CreateAlertRule(rule *models.AlertRule) error {
+	return r.db.Create(rule).Error
+}
+
+func (r *StatsRepository) SaveAlertRule(rule *models.AlertRule) error {
+	return r.db.Save(rule).Error
+}
+
+func (r *StatsRepository) FindAlertRule(userID, id uuid.UUID) (*models.AlertRule, error) {
+	var rule models.AlertRule
+	err := r.db.First(&rule, "id = ? AND user_id = ?", id, userID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (r *StatsRepository) ListAlertRules(userID uuid.UUID) ([]models.AlertRule, error) {
+	var rules []models.AlertRule
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&rules).Error
+	return rules, err
+}
+
+func (r *StatsRepository) ListEnabledAlertRules() ([]models.AlertRule, error) {
+	var rules []models.AlertRule
+	err := r.db.Where("enabled = ?", true).Find(&rules).Error
+	return rules, err
+}
+
+func (r *StatsRepository) DeleteAlertRule(userID, id uuid.UUID) error {
+	return r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.AlertRule{}).Error
+}
+
+func (r *StatsRepository) MarkAlertRuleTriggered(id uuid.UUID, at time.Time) error {
+	return r.db.Model(&models.AlertRule{}).Where("id = ?", id).Update("last_triggered_at", at).Error
+}
+
+func (r *StatsRepository) CreateAlert(alert *models.Alert) error {
+	return r.db.Create(alert).Error
+}
+
+func (r *StatsRepository) SaveAlert(alert *models.Alert) error {
+	return r.db.Save(alert).Error
+}
+
+// ListAlerts returns the user's alerts, newest first, optionally for one rule.
+func (r *StatsRepository) ListAlerts(userID uuid.UUID, ruleID *uuid.UUID, limit int) ([]models.Alert, error) {
+	var alerts []models.Alert
+	query := r.db.Where("user_id = ?", userID)
+	if ruleID != nil {
+		query = query.Where("rule_id = ?", *ruleID)
+	}
+	err := query.Order("triggered_at DESC").Limit(limit).Find(&alerts).Error
+	return alerts, err
+}
+
+// DueAlerts returns pending alerts whose next delivery attempt is due.
+func (r *StatsRepository) DueAlerts(now time.Time, limit int) ([]models.Alert, error) {
+	var alerts []models.Alert
+	err := r.db.Where("status = ? AND next_attempt_at <= ?", models.AlertPending, now).
+		Order("next_attempt_at").
+		Limit(limit).
+		Find(&alerts).Error
+	return alerts, err
+}
+
+// GetCountries returns the countries a link had clicks from before the given
+// time. It need not be hour aligned: rollup rows are all older than the
+// watermark, so only the raw side is cut at it.
+func (r *StatsRepository) GetCountries(shortCode string, before time.Time) ([]string, error) {
+	var countries []string
+	q := rollupQuery{shortCode: shortCode, until: before, where: "COALESCE(country, '') <> ''"}
+	err := r.rolledUp(q, column("country")).
+		Distinct("country").
+		Pluck("country", &countries).Error
+	return countries, err
+}
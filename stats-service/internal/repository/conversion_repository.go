@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func (r *StatsRepository) FindClick(id uuid.UUID) (*models.Click, error) {
+	var click models.Click
+	err := r.db.First(&click, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &click, nil
+}
+
+// IsLinkOwner reports whether userID owns the link.
+func (r *StatsRepository) IsLinkOwner(shortCode string, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.LinkOwner{}).
+		Where("short_code = ? AND user_id = ?", shortCode, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// CreateConversion stores a conversion unless the click already converted
+// under the same name and external ID, in which case the existing one is
+// loaded into conversion. It reports whether a new conversion was stored.
+func (r *StatsRepository) CreateConversion(conversion *models.Conversion) (bool, error) {
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(conversion)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.RowsAffected > 0, result.Error
+	}
+
+	err := r.db.First(conversion, "click_id = ? AND name = ? AND external_id = ?",
+		conversion.ClickID, conversion.Name, conversion.ExternalID).Error
+	return false, err
+}
+
+// GetConversionStats counts a link's conversions, the distinct clicks that
+// converted and the revenue per currency.
+func (r *StatsRepository) GetConversionStats(shortCode string, filter models.StatsFilter) (int64, int64, map[string]float64, error) {
+	query := func() *gorm.DB {
+		q := r.db.Model(&models.Conversion{}).Where("short_code = ?", shortCode)
+		if !filter.IncludeBots {
+			q = q.Where("is_bot = ?", false)
+		}
+		return q
+	}
+
+	var counts struct {
+		Conversions     int64
+		ConvertedClicks int64
+	}
+	err := query().Select("COUNT(*) AS conversions, COUNT(DISTINCT click_id) AS converted_clicks").Scan(&counts).Error
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	var rows []struct {
+		Currency string
+		Revenue  float64
+	}
+	err = query().Select("COALESCE(currency, '') AS currency, SUM(revenue) AS revenue").
+		Where("revenue > 0").
+		Group("COALESCE(currency, '')").
+		Scan(&rows).Error
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	revenue := make(map[string]float64, len(rows))
+	for _, row := range rows {
+		revenue[row.Currency] = row.Revenue
+	}
+	return counts.Conversions, counts.ConvertedClicks, revenue, nil
+}
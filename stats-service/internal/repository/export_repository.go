@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"github.com/urlshortener/stats-service/internal/models"
+)
+
+// EachClick streams the raw clicks matched by q to fn in creation order,
+// without loading them all into memory. Iteration stops at the first error.
+func (r *StatsRepository) EachClick(q models.ExportQuery, fn func(*models.Click) error) error {
+	query := r.clicks(q.Filter).
+		Where("user_id = ?", q.UserID).
+		Where("created_at >= ? AND created_at < ?", q.From, q.To)
+	if q.ShortCode != "" {
+		query = query.Where("short_code = ?", q.ShortCode)
+	}
+	if q.Tag != "" {
+		query = query.Where("short_code IN (?)", r.taggedLinks([]string{q.Tag}))
+	}
+
+	rows, err := query.Order("created_at ASC, id ASC").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var click models.Click
+		if err := r.db.ScanRows(rows, &click); err != nil {
+			return err
+		}
+		if err := fn(&click); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// exportDimensions are the URLStats breakdowns included in a stats export.
+var exportDimensions = []struct {
+	name string
+	dim  dimension
+}{
+	{"day", dimDay},
+	{"device", dimDevice},
+	{"browser", dimBrowser},
+	{"os", dimOS},
+	{"referer", dimReferer},
+	{"channel", dimChannel},
+}
+
+// EachStat streams URLStats figures for every link matched by q to fn, one
+// dimension at a time and ordered by short code within each. The range is
+// widened to whole hours (whole days where possible) to match the rollups.
+func (r *StatsRepository) EachStat(q models.ExportQuery, fn func(models.StatsExportRow) error) error {
+	from, to, hourly := hourAligned(q.From, q.To)
+	base := rollupQuery{
+		shortCode: q.ShortCode,
+		userID:    q.UserID,
+		filter:    q.Filter,
+		since:     from,
+		until:     to,
+		hourly:    hourly,
+	}
+	if q.Tag != "" {
+		base.in = []dimensionFilter{{dim: dimTag, values: []string{q.Tag}}}
+	}
+
+	if err := r.eachStat(base, "total", dimension{}, fn); err != nil {
+		return err
+	}
+	for _, d := range exportDimensions {
+		q := base
+		if d.dim == dimReferer {
+			q.where = "COALESCE(referer_domain, '') <> ''"
+		}
+		if err := r.eachStat(q, d.name, d.dim, fn); err != nil {
+			return err
+		}
+	}
+
+	if q.Filter.IncludeBots {
+		bots := base
+		bots.bots = true
+		if err := r.eachStat(bots, "bot_total", dimension{}, fn); err != nil {
+			return err
+		}
+		if err := r.eachStat(bots, "bot", dimBot, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachStat streams the clicks matched by q per short code and, unless dim is
+// the zero dimension, per value of dim.
+func (r *StatsRepository) eachStat(q rollupQuery, name string, dim dimension, fn func(models.StatsExportRow) error) error {
+	query := r.rolledUp(q, dimShortCode).
+		Select("short_code, '' AS value, SUM(count) AS clicks").
+		Group("short_code").
+		Order("short_code ASC")
+	if dim != (dimension{}) {
+		order := "short_code ASC, clicks DESC"
+		if dim == dimDay {
+			order = "short_code ASC, value ASC"
+		}
+		query = r.rolledUp(q, dimShortCode, dim).
+			Select("short_code, " + dim.alias + " AS value, SUM(count) AS clicks").
+			Group("short_code, " + dim.alias).
+			Order(order)
+	}
+
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row := models.StatsExportRow{Dimension: name}
+		if err := rows.Scan(&row.ShortCode, &row.Value, &row.Clicks); err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,203 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/urlshortener/stats-service/internal/models"
+	"gorm.io/gorm"
+)
+
+// queryDimensions are the only dimensions the analytics query API can group
+// or filter by.
+var queryDimensions = map[string]dimension{
+	models.DimensionDay:     dimDay,
+	models.DimensionHour:    dimHour,
+	models.DimensionLink:    dimShortCode,
+	models.DimensionDevice:  dimDevice,
+	models.DimensionBrowser: dimBrowser,
+	models.DimensionOS:      dimOS,
+	models.DimensionCountry: dimCountry,
+	models.DimensionReferer: dimReferer,
+	models.DimensionChannel: dimChannel,
+	models.DimensionBot:     dimBot,
+	models.DimensionTag:     dimTag,
+}
+
+// visitorKey identifies a visitor by anonymized IP and user agent. It is
+// NULL for clicks stored without an IP, which are left out of uniques.
+const visitorKey = "NULLIF(ip, '') || '|' || COALESCE(user_agent, '')"
+
+// IsQueryDimension reports whether name can be used in an analytics query.
+func IsQueryDimension(name string) bool {
+	_, ok := queryDimensions[name]
+	return ok
+}
+
+// IsTimeDimension reports whether name buckets clicks by time.
+func IsTimeDimension(name string) bool {
+	return name == models.DimensionDay || name == models.DimensionHour
+}
+
+// Query answers an analytics query, ordered by time dimensions and then by
+// the first metric, largest first. Clicks come from the rollups whatever the
+// metrics. Uniques need each visitor's IP and user agent, so they are
+// counted from raw clicks and only cover the retention period and links
+// that store clicks.
+func (r *StatsRepository) Query(q models.AnalyticsQuery) ([]models.AnalyticsRow, error) {
+	dims := make([]dimension, len(q.GroupBy))
+	for i, name := range q.GroupBy {
+		dim, ok := queryDimensions[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown dimension %q", name)
+		}
+		dims[i] = dim
+	}
+	filters := make([]dimensionFilter, 0, len(q.Filters))
+	for _, f := range q.Filters {
+		dim, ok := queryDimensions[f.Dimension]
+		if !ok {
+			return nil, fmt.Errorf("unknown dimension %q", f.Dimension)
+		}
+		filters = append(filters, dimensionFilter{dim: dim, values: f.Values})
+	}
+
+	uniques := false
+	for _, metric := range q.Metrics {
+		uniques = uniques || metric == models.MetricUniques
+	}
+
+	query := r.queryRollups(q, dims, filters)
+	if uniques {
+		query = r.withUniques(query, q, dims, filters)
+	}
+
+	order := make([]string, 0, len(dims)+1)
+	for i, name := range q.GroupBy {
+		if IsTimeDimension(name) {
+			order = append(order, dims[i].alias+" ASC")
+		}
+	}
+	order = append(order, q.Metrics[0]+" DESC")
+	query = query.Order(strings.Join(order, ", "))
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+
+	rows, err := query.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []models.AnalyticsRow{}
+	for rows.Next() {
+		values := make([]sql.NullString, len(dims))
+		var clicks, uniqueVisitors int64
+		dest := make([]interface{}, 0, len(dims)+2)
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		dest = append(dest, &clicks)
+		if uniques {
+			dest = append(dest, &uniqueVisitors)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row := models.AnalyticsRow{
+			Dimensions: make(map[string]string, len(dims)),
+			Metrics:    make(map[string]int64, len(q.Metrics)),
+		}
+		for i, name := range q.GroupBy {
+			row.Dimensions[name] = values[i].String
+		}
+		for _, metric := range q.Metrics {
+			switch metric {
+			case models.MetricClicks:
+				row.Metrics[metric] = clicks
+			case models.MetricUniques:
+				row.Metrics[metric] = uniqueVisitors
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// queryRollups selects the dims' aliases and clicks from the rollups.
+func (r *StatsRepository) queryRollups(q models.AnalyticsQuery, dims []dimension, filters []dimensionFilter) *gorm.DB {
+	since, until, hourly := hourAligned(q.From, q.To)
+	aliases := make([]string, len(dims))
+	for i, dim := range dims {
+		aliases[i] = dim.alias
+		hourly = hourly || dim == dimHour
+	}
+
+	query := r.rolledUp(rollupQuery{
+		shortCode: q.ShortCode,
+		userID:    q.UserID,
+		filter:    q.Filter,
+		since:     since,
+		until:     until,
+		hourly:    hourly,
+		in:        filters,
+	}, dims...).Select(strings.Join(append(aliases, "SUM(count) AS clicks"), ", "))
+	if len(aliases) > 0 {
+		query = query.Group(strings.Join(aliases, ", "))
+	}
+	return query
+}
+
+// withUniques joins the uniques counted from raw clicks to the rows of
+// clicks, a query from queryRollups, matching them by the dims' values.
+func (r *StatsRepository) withUniques(clicks *gorm.DB, q models.AnalyticsQuery, dims []dimension, filters []dimensionFilter) *gorm.DB {
+	selects := make([]string, 0, len(dims)+2)
+	on := []string{"TRUE"}
+	for _, dim := range dims {
+		selects = append(selects, "c."+dim.alias)
+		on = append(on, "u."+dim.alias+" = c."+dim.alias)
+	}
+	selects = append(selects, "c.clicks", "COALESCE(u.uniques, 0) AS uniques")
+
+	return r.db.Table("(?) AS c LEFT JOIN (?) AS u ON "+strings.Join(on, " AND "), clicks, r.queryUniques(q, dims, filters)).
+		Select(strings.Join(selects, ", "))
+}
+
+// queryUniques selects the dims' aliases and uniques from raw clicks.
+func (r *StatsRepository) queryUniques(q models.AnalyticsQuery, dims []dimension, filters []dimensionFilter) *gorm.DB {
+	since, until, _ := hourAligned(q.From, q.To)
+	selects := make([]string, 0, len(dims)+1)
+	groups := make([]string, 0, len(dims))
+	tagged := false
+	for _, dim := range dims {
+		selects = append(selects, dim.raw+" AS "+dim.alias)
+		groups = append(groups, dim.raw)
+		tagged = tagged || dim.tagged
+	}
+	selects = append(selects, "COUNT(DISTINCT "+visitorKey+") AS uniques")
+
+	query := r.clicks(q.Filter).
+		Select(strings.Join(selects, ", ")).
+		Where("user_id = ?", q.UserID).
+		Where("created_at >= ? AND created_at < ?", since, until)
+	if tagged {
+		query = query.Joins(tagJoin)
+	}
+	if q.ShortCode != "" {
+		query = query.Where("short_code = ?", q.ShortCode)
+	}
+	for _, f := range filters {
+		if f.dim.tagged {
+			query = query.Where("short_code IN (?)", r.taggedLinks(f.values))
+			continue
+		}
+		query = query.Where(f.dim.raw+" IN ?", f.values)
+	}
+	if len(groups) > 0 {
+		query = query.Group(strings.Join(groups, ", "))
+	}
+	return query
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+)
+
+func (r *StatsRepository) SaveReportSubscription(sub *models.ReportSubscription) error {
+	return r.db.Save(sub).Error
+}
+
+// UpdateReportSchedule stores when a subscription was last sent and is due
+// next. Its other columns may have changed meanwhile (an unsubscribe, a new
+// address) and are left alone; a deleted subscription stays deleted.
+func (r *StatsRepository) UpdateReportSchedule(sub *models.ReportSubscription) error {
+	return r.db.Model(sub).Select("next_run_at", "last_sent_at").Updates(sub).Error
+}
+
+// DisableReportSubscription turns a subscription off.
+func (r *StatsRepository) DisableReportSubscription(id uuid.UUID) error {
+	return r.db.Model(&models.ReportSubscription{}).Where("id = ?", id).Update("enabled", false).Error
+}
+
+// SetReportEmail points the user's subscriptions at a new address; an empty
+// one holds them until the user verifies an address.
+func (r *StatsRepository) SetReportEmail(userID uuid.UUID, email string) error {
+	return r.db.Model(&models.ReportSubscription{}).Where("user_id = ?", userID).Update("email", email).Error
+}
+
+func (r *StatsRepository) FindReportSubscription(userID, id uuid.UUID) (*models.ReportSubscription, error) {
+	var sub models.ReportSubscription
+	err := r.db.First(&sub, "id = ? AND user_id = ?", id, userID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// FindReportSubscriptionByFrequency returns the user's subscription for
+// frequency, enabled or not.
+func (r *StatsRepository) FindReportSubscriptionByFrequency(userID uuid.UUID, frequency string) (*models.ReportSubscription, error) {
+	var sub models.ReportSubscription
+	err := r.db.First(&sub, "user_id = ? AND frequency = ?", userID, frequency).Error
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (r *StatsRepository) FindReportSubscriptionByToken(token string) (*models.ReportSubscription, error) {
+	var sub models.ReportSubscription
+	err := r.db.First(&sub, "unsubscribe_token = ?", token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (r *StatsRepository) ListReportSubscriptions(userID uuid.UUID) ([]models.ReportSubscription, error) {
+	var subs []models.ReportSubscription
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&subs).Error
+	return subs, err
+}
+
+// DeleteReportSubscription removes a subscription together with its send log.
+func (r *StatsRepository) DeleteReportSubscription(userID, id uuid.UUID) error {
+	if err := r.db.Where("subscription_id = ? AND user_id = ?", id, userID).Delete(&models.ReportDelivery{}).Error; err != nil {
+		return err
+	}
+	return r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.ReportSubscription{}).Error
+}
+
+// DueReportSubscriptions returns enabled subscriptions with a verified
+// address whose next report is due.
+func (r *StatsRepository) DueReportSubscriptions(now time.Time, limit int) ([]models.ReportSubscription, error) {
+	var subs []models.ReportSubscription
+	err := r.db.Where("enabled = ? AND email <> '' AND next_run_at <= ?", true, now).
+		Order("next_run_at").
+		Limit(limit).
+		Find(&subs).Error
+	return subs, err
+}
+
+func (r *StatsRepository) CreateReportDelivery(delivery *models.ReportDelivery) error {
+	return r.db.Create(delivery).Error
+}
+
+// ListReportDeliveries returns the user's send log, newest first.
+func (r *StatsRepository) ListReportDeliveries(userID uuid.UUID, limit int) ([]models.ReportDelivery, error) {
+	var deliveries []models.ReportDelivery
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"gorm.io/gorm"
+)
+
+func (r *StatsRepository) FindRetentionOverride(userID uuid.UUID) (*models.RetentionOverride, error) {
+	var override models.RetentionOverride
+	err := r.db.First(&override, "user_id = ?", userID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &override, nil
+}
+
+func (r *StatsRepository) ListRetentionOverrides() ([]models.RetentionOverride, error) {
+	var overrides []models.RetentionOverride
+	err := r.db.Order("user_id").Find(&overrides).Error
+	return overrides, err
+}
+
+func (r *StatsRepository) SaveRetentionOverride(override *models.RetentionOverride) error {
+	return r.db.Save(override).Error
+}
+
+func (r *StatsRepository) DeleteRetentionOverride(userID uuid.UUID) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.RetentionOverride{}).Error
+}
+
+// RollupWatermark reports up to when raw clicks have been rolled up.
+func (r *StatsRepository) RollupWatermark() time.Time {
+	return r.rollupWatermark()
+}
+
+// purgeable selects raw clicks before cutoff, either for a single user or,
+// when userID is nil, for everyone not listed in excluded (including clicks
+// on anonymous links).
+func (r *StatsRepository) purgeable(userID *uuid.UUID, excluded []uuid.UUID, cutoff time.Time) *gorm.DB {
+	query := r.db.Model(&models.Click{}).Where("created_at < ?", cutoff)
+	if userID != nil {
+		return query.Where("user_id = ?", *userID)
+	}
+	if len(excluded) > 0 {
+		query = query.Where("(user_id IS NULL OR user_id NOT IN ?)", excluded)
+	}
+	return query
+}
+
+func (r *StatsRepository) CountPurgeableClicks(userID *uuid.UUID, excluded []uuid.UUID, cutoff time.Time) (int64, error) {
+	var count int64
+	err := r.purgeable(userID, excluded, cutoff).Count(&count).Error
+	return count, err
+}
+
+// PurgeClicks deletes at most batchSize matching raw clicks and returns how
+// many were deleted. Callers loop until it returns fewer than batchSize.
+func (r *StatsRepository) PurgeClicks(userID *uuid.UUID, excluded []uuid.UUID, cutoff time.Time, batchSize int) (int64, error) {
+	batch := r.purgeable(userID, excluded, cutoff).Select("id").Limit(batchSize)
+	result := r.db.Where("id IN (?)", batch).Delete(&models.Click{})
+	return result.RowsAffected, result.Error
+}
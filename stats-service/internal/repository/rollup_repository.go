@@ -0,0 +1,391 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const rollupStateID = 1
+
+// rollupDimensions are the columns shared by raw clicks and rollup rows.
+var rollupDimensions = []string{
+	"short_code", "device", "browser", "os", "country",
+	"referer_domain", "channel", "is_bot", "bot_name",
+}
+
+// rollupTable is a rollup table and the unit its buckets are truncated to.
+type rollupTable struct {
+	table string
+	unit  string
+}
+
+var (
+	// Compacted from raw clicks
+	compactedRollups = []rollupTable{
+		{models.HourlyClickRollup{}.TableName(), "hour"},
+		{models.DailyClickRollup{}.TableName(), "day"},
+	}
+	// Counted directly, for links that skip raw click storage
+	aggregateRollups = []rollupTable{
+		{models.AggregateHourlyClickRollup{}.TableName(), "hour"},
+		{models.AggregateDailyClickRollup{}.TableName(), "day"},
+	}
+)
+
+// dimension is a grouping expression over the rollup tables and the raw
+// clicks table respectively. Only values from this file are ever
+// interpolated into SQL.
+type dimension struct {
+	alias  string
+	rollup string
+	raw    string
+	// Tagged dimensions read the link's tags, joined in with tagJoin
+	tagged bool
+}
+
+// tagJoin pairs rows with each tag of their link. The tags' short_code is
+// renamed so conditions on short_code stay unambiguous.
+const tagJoin = "JOIN (SELECT short_code AS tagged_code, tag FROM link_tags) AS link_tags ON link_tags.tagged_code = short_code"
+
+func column(name string) dimension {
+	return dimension{alias: name, rollup: name, raw: "COALESCE(" + name + ", '')"}
+}
+
+var (
+	dimDay       = dimension{alias: "date", rollup: "to_char(bucket, 'YYYY-MM-DD')", raw: "to_char(created_at, 'YYYY-MM-DD')"}
+	dimShortCode = dimension{alias: "short_code", rollup: "short_code", raw: "short_code"}
+	dimDevice    = column("device")
+	dimBrowser   = column("browser")
+	dimOS        = column("os")
+	dimBot       = dimension{alias: "bot", rollup: "bot_name", raw: "COALESCE(bot_name, '')"}
+	dimReferer   = dimension{alias: "referer", rollup: "referer_domain", raw: "COALESCE(referer_domain, '')"}
+	dimChannel   = column("channel")
+	dimCountry   = column("country")
+	dimHour      = dimension{alias: "hour", rollup: "to_char(bucket, 'YYYY-MM-DD HH24:00')", raw: "to_char(created_at, 'YYYY-MM-DD HH24:00')"}
+	dimTag       = dimension{alias: "tag", rollup: "link_tags.tag", raw: "link_tags.tag", tagged: true}
+)
+
+// dimensionFilter matches rows whose dim is any of values.
+type dimensionFilter struct {
+	dim    dimension
+	values []string
+}
+
+// rollupQuery describes which clicks a rollup-backed query covers.
+type rollupQuery struct {
+	shortCode string
+	userID    uuid.UUID // only links owned by this user
+	filter    models.StatsFilter
+	bots      bool      // only bot traffic (overrides filter)
+	since     time.Time // since and until must be hour aligned; day aligned unless hourly
+	until     time.Time
+	hourly    bool
+	where     string // extra condition on columns common to both tables
+	in        []dimensionFilter
+}
+
+// rolledUp returns a subquery over the rollup rows before the watermark
+// combined with raw clicks from the watermark on and with the aggregate-only
+// rollups, grouped by dims and exposing a "count" column. Callers aggregate
+// it again by the dims' aliases.
+func (r *StatsRepository) rolledUp(q rollupQuery, dims ...dimension) *gorm.DB {
+	watermark := r.rollupWatermark()
+
+	table, aggregateTable := models.DailyClickRollup{}.TableName(), models.AggregateDailyClickRollup{}.TableName()
+	if q.hourly {
+		table, aggregateTable = models.HourlyClickRollup{}.TableName(), models.AggregateHourlyClickRollup{}.TableName()
+	}
+
+	rollupSelect := make([]string, 0, len(dims)+1)
+	rawSelect := make([]string, 0, len(dims)+1)
+	rollupGroup := make([]string, 0, len(dims))
+	rawGroup := make([]string, 0, len(dims))
+	tagged := false
+	for _, dim := range dims {
+		rollupSelect = append(rollupSelect, dim.rollup+" AS "+dim.alias)
+		rawSelect = append(rawSelect, dim.raw+" AS "+dim.alias)
+		rollupGroup = append(rollupGroup, dim.rollup)
+		rawGroup = append(rawGroup, dim.raw)
+		tagged = tagged || dim.tagged
+	}
+
+	rollup := r.db.Table(table).
+		Select(strings.Join(append(rollupSelect, "SUM(clicks) AS count"), ", ")).
+		Where("bucket < ?", watermark)
+	aggregate := r.db.Table(aggregateTable).
+		Select(strings.Join(append(rollupSelect, "SUM(clicks) AS count"), ", "))
+	raw := r.db.Table("clicks").
+		Select(strings.Join(append(rawSelect, "COUNT(*) AS count"), ", ")).
+		Where("created_at >= ?", watermark)
+	if tagged {
+		rollup = rollup.Joins(tagJoin)
+		aggregate = aggregate.Joins(tagJoin)
+		raw = raw.Joins(tagJoin)
+	}
+
+	// where adds rollupCond to both rollup queries and rawCond to the raw
+	// clicks one
+	where := func(rollupCond, rawCond string, args ...interface{}) {
+		rollup = rollup.Where(rollupCond, args...)
+		aggregate = aggregate.Where(rollupCond, args...)
+		raw = raw.Where(rawCond, args...)
+	}
+
+	if !q.since.IsZero() {
+		where("bucket >= ?", "created_at >= ?", q.since)
+	}
+	if !q.until.IsZero() {
+		where("bucket < ?", "created_at < ?", q.until)
+	}
+	if q.shortCode != "" {
+		where("short_code = ?", "short_code = ?", q.shortCode)
+	}
+	if q.userID != uuid.Nil {
+		owned := r.db.Model(&models.LinkOwner{}).Select("short_code").Where("user_id = ?", q.userID)
+		where("short_code IN (?)", "short_code IN (?)", owned)
+	}
+	if q.bots {
+		where("is_bot = ?", "is_bot = ?", true)
+	} else if !q.filter.IncludeBots {
+		where("is_bot = ?", "is_bot = ?", false)
+	}
+	if q.where != "" {
+		where(q.where, q.where)
+	}
+	for _, f := range q.in {
+		if f.dim.tagged {
+			where("short_code IN (?)", "short_code IN (?)", r.taggedLinks(f.values))
+			continue
+		}
+		where(f.dim.rollup+" IN ?", f.dim.raw+" IN ?", f.values)
+	}
+	if len(dims) > 0 {
+		rollup = rollup.Group(strings.Join(rollupGroup, ", "))
+		aggregate = aggregate.Group(strings.Join(rollupGroup, ", "))
+		raw = raw.Group(strings.Join(rawGroup, ", "))
+	}
+
+	return r.db.Table("((?) UNION ALL (?) UNION ALL (?)) AS combined", rollup, aggregate, raw)
+}
+
+// taggedLinks returns a subquery over the short codes with any of tags.
+func (r *StatsRepository) taggedLinks(tags []string) *gorm.DB {
+	return r.db.Model(&models.LinkTag{}).Select("short_code").Where("tag IN ?", tags)
+}
+
+// countRolledUp sums the clicks matched by q.
+func (r *StatsRepository) countRolledUp(q rollupQuery) (int64, error) {
+	var count int64
+	err := r.rolledUp(q).Select("COALESCE(SUM(count), 0)").Scan(&count).Error
+	return count, err
+}
+
+// breakdown groups the clicks matched by q by a single dimension, largest first.
+func (r *StatsRepository) breakdown(q rollupQuery, dim dimension, limit int, dest interface{}) error {
+	query := r.rolledUp(q, dim).
+		Select(dim.alias + ", SUM(count) AS count").
+		Group(dim.alias).
+		Order("count DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	return query.Scan(dest).Error
+}
+
+// hourAligned widens [from, to) to whole hours and reports whether the
+// hourly rollups are needed because it does not fall on whole days.
+func hourAligned(from, to time.Time) (time.Time, time.Time, bool) {
+	since := from.Truncate(time.Hour)
+	until := to.Truncate(time.Hour)
+	if until.Before(to) {
+		until = until.Add(time.Hour)
+	}
+	hourly := !since.Equal(since.Truncate(24*time.Hour)) || !until.Equal(until.Truncate(24*time.Hour))
+	return since, until, hourly
+}
+
+func (r *StatsRepository) rollupWatermark() time.Time {
+	var state models.RollupState
+	if err := r.db.First(&state, rollupStateID).Error; err != nil {
+		return time.Time{}
+	}
+	return state.Watermark
+}
+
+// CompactRollups aggregates raw clicks between the watermark and until (at
+// most maxWindow at a time) into the hourly and daily rollups and advances
+// the watermark. It returns the new watermark.
+func (r *StatsRepository) CompactRollups(until time.Time, maxWindow time.Duration) (time.Time, error) {
+	until = until.Truncate(time.Hour)
+	var watermark time.Time
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		state, err := lockRollupState(tx, until)
+		if err != nil {
+			return err
+		}
+
+		from, to := state.Watermark, until
+		if to.Sub(from) > maxWindow {
+			to = from.Add(maxWindow).Truncate(time.Hour)
+		}
+		watermark = from
+		if !to.After(from) {
+			return nil
+		}
+
+		for _, rollup := range compactedRollups {
+			if err := compactInto(tx, rollup.table, rollup.unit, from, to); err != nil {
+				return err
+			}
+		}
+
+		state.Watermark = to
+		if err := tx.Save(state).Error; err != nil {
+			return err
+		}
+		watermark = to
+		return nil
+	})
+
+	return watermark, err
+}
+
+// ResetRollups discards rollup rows from the purge boundary (or the day of
+// the oldest raw click, if later) on and moves the watermark back there, so
+// the next compactions recompute them from raw clicks. Rollups before the
+// boundary are kept: retention overrides purge some users' raw clicks
+// earlier than others', so older days cannot be recomputed for everyone.
+// Queries stay correct throughout since they read raw clicks from the
+// watermark on. The aggregate-only rollups have no raw clicks to be
+// recomputed from and are left alone.
+func (r *StatsRepository) ResetRollups() error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now().Truncate(time.Hour)
+		state, err := lockRollupState(tx, now)
+		if err != nil {
+			return err
+		}
+
+		from, err := oldestClick(tx, "day", now)
+		if err != nil {
+			return err
+		}
+		if state.PurgedBefore.After(from) {
+			from = state.PurgedBefore
+		}
+
+		for _, rollup := range compactedRollups {
+			if err := tx.Exec("DELETE FROM "+rollup.table+" WHERE bucket >= ?", from).Error; err != nil {
+				return err
+			}
+		}
+
+		state.Watermark = from
+		return tx.Save(state).Error
+	})
+}
+
+// AdvancePurgeBoundary records that raw clicks before cutoff may be purged.
+// It must be called before purging them.
+func (r *StatsRepository) AdvancePurgeBoundary(cutoff time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		state, err := lockRollupState(tx, time.Now().Truncate(time.Hour))
+		if err != nil {
+			return err
+		}
+		if !cutoff.After(state.PurgedBefore) {
+			return nil
+		}
+		state.PurgedBefore = cutoff
+		return tx.Save(state).Error
+	})
+}
+
+// lockRollupState loads the rollup state row for update, creating it at the
+// oldest raw click (or fallback when there are none) on first use.
+func lockRollupState(tx *gorm.DB, fallback time.Time) (*models.RollupState, error) {
+	var state models.RollupState
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&state, rollupStateID).Error
+	if err == nil {
+		return &state, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	state.ID = rollupStateID
+	if state.Watermark, err = oldestClick(tx, "hour", fallback); err != nil {
+		return nil, err
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&state).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&state, rollupStateID).Error; err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// oldestClick returns the start of the hour or day (unit) holding the oldest
+// raw click, or fallback if there are no earlier clicks.
+func oldestClick(tx *gorm.DB, unit string, fallback time.Time) (time.Time, error) {
+	var oldest *time.Time
+	err := tx.Model(&models.Click{}).Select("date_trunc(?, MIN(created_at))", unit).Scan(&oldest).Error
+	if err != nil {
+		return time.Time{}, err
+	}
+	if oldest == nil || oldest.After(fallback) {
+		return fallback, nil
+	}
+	return *oldest, nil
+}
+
+func compactInto(tx *gorm.DB, table, unit string, from, to time.Time) error {
+	dims := strings.Join(rollupDimensions, ", ")
+	selects := make([]string, 0, len(rollupDimensions))
+	for _, dim := range rollupDimensions {
+		if dim == "is_bot" || dim == "short_code" {
+			selects = append(selects, dim)
+			continue
+		}
+		selects = append(selects, "COALESCE("+dim+", '')")
+	}
+
+	sql := fmt.Sprintf(`INSERT INTO %[1]s (bucket, %[2]s, clicks)
+		SELECT date_trunc('%[3]s', created_at), %[4]s, COUNT(*)
+		FROM clicks
+		WHERE created_at >= ? AND created_at < ?
+		GROUP BY 1, %[4]s
+		ON CONFLICT (bucket, %[2]s) DO UPDATE SET clicks = %[1]s.clicks + EXCLUDED.clicks`,
+		table, dims, unit, strings.Join(selects, ", "))
+
+	return tx.Exec(sql, from, to).Error
+}
+
+// addToRollups counts a single click directly into the given rollups. It is
+// used for clicks that arrive after their hour has already been compacted,
+// and for clicks that are not stored individually.
+func addToRollups(tx *gorm.DB, click *models.Click, rollups []rollupTable) error {
+	for _, rollup := range rollups {
+		sql := fmt.Sprintf(`INSERT INTO %[1]s (bucket, %[2]s, clicks)
+			VALUES (date_trunc(?, ?::timestamptz), ?, ?, ?, ?, ?, ?, ?, ?, ?, 1)
+			ON CONFLICT (bucket, %[2]s) DO UPDATE SET clicks = %[1]s.clicks + 1`,
+			rollup.table, strings.Join(rollupDimensions, ", "))
+
+		err := tx.Exec(sql, rollup.unit, click.CreatedAt,
+			click.ShortCode, click.Device, click.Browser, click.OS, click.Country,
+			click.RefererDomain, click.Channel, click.IsBot, click.BotName).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package repository
 
 import (
+	"errors"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/urlshortener/stats-service/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type StatsRepository struct {
@@ -15,92 +18,183 @@ func NewStatsRepository(db *gorm.DB) *StatsRepository {
 	return &StatsRepository{db: db}
 }
 
+// RecordClick stores a raw click. Clicks older than the rollup watermark
+// (late arrivals) are also counted straight into the rollups, since the
+// compactor has already moved past their hour.
 func (r *StatsRepository) RecordClick(click *models.Click) error {
-	return r.db.Create(click).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// The share lock makes a concurrent compaction wait for this click
+		var state models.RollupState
+		err := tx.Clauses(clause.Locking{Strength: "SHARE"}).First(&state, rollupStateID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if err := tx.Create(click).Error; err != nil {
+			return err
+		}
+		if err := recordOwner(tx, click); err != nil {
+			return err
+		}
+
+		if click.CreatedAt.Before(state.Watermark) {
+			return addToRollups(tx, click, compactedRollups)
+		}
+		return nil
+	})
+}
+
+// RecordAggregateClick counts a click in the aggregate-only rollups without
+// storing it individually.
+func (r *StatsRepository) RecordAggregateClick(click *models.Click) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := recordOwner(tx, click); err != nil {
+			return err
+		}
+		return addToRollups(tx, click, aggregateRollups)
+	})
+}
+
+// recordOwner notes the owner of the clicked link the first time it is seen.
+func recordOwner(tx *gorm.DB, click *models.Click) error {
+	if click.UserID == nil {
+		return nil
+	}
+	owner := &models.LinkOwner{ShortCode: click.ShortCode, UserID: *click.UserID}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(owner).Error
+}
+
+// SetLinkTags replaces the tags of a link.
+func (r *StatsRepository) SetLinkTags(shortCode string, tags []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("short_code = ?", shortCode).Delete(&models.LinkTag{}).Error; err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			linkTag := &models.LinkTag{ShortCode: shortCode, Tag: tag}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(linkTag).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// BackfillLinkOwners fills an empty link_owners table from the raw clicks,
+// for databases that predate it.
+func (r *StatsRepository) BackfillLinkOwners() error {
+	var owner models.LinkOwner
+	result := r.db.Limit(1).Find(&owner)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	return r.db.Exec(`INSERT INTO link_owners (short_code, user_id, created_at)
+		SELECT short_code, (array_agg(user_id ORDER BY created_at))[1], MIN(created_at)
+		FROM clicks
+		WHERE user_id IS NOT NULL
+		GROUP BY short_code
+		ON CONFLICT DO NOTHING`).Error
 }
 
-func (r *StatsRepository) GetTotalClicks(shortCode string) (int64, error) {
-	var count int64
-	err := r.db.Model(&models.Click{}).Where("short_code = ?", shortCode).Count(&count).Error
-	return count, err
+// clicks returns a query over the raw clicks table with the filter applied.
+func (r *StatsRepository) clicks(filter models.StatsFilter) *gorm.DB {
+	query := r.db.Model(&models.Click{})
+	if !filter.IncludeBots {
+		query = query.Where("is_bot = ?", false)
+	}
+	return query
 }
 
-func (r *StatsRepository) GetClicksByDay(shortCode string, days int) ([]models.DayStats, error) {
+func (r *StatsRepository) GetTotalClicks(shortCode string, filter models.StatsFilter) (int64, error) {
+	return r.countRolledUp(rollupQuery{shortCode: shortCode, filter: filter})
+}
+
+func (r *StatsRepository) GetBotClicks(shortCode string) (int64, error) {
+	return r.countRolledUp(rollupQuery{shortCode: shortCode, bots: true})
+}
+
+func (r *StatsRepository) GetClicksByDay(shortCode string, days int, filter models.StatsFilter) ([]models.DayStats, error) {
 	var stats []models.DayStats
-	
-	startDate := time.Now().AddDate(0, 0, -days)
-	
-	err := r.db.Model(&models.Click{}).
-		Select("DATE(created_at) as date, COUNT(*) as clicks").
-		Where("short_code = ? AND created_at >= ?", shortCode, startDate).
-		Group("DATE(created_at)").
+
+	startDate := time.Now().AddDate(0, 0, -days).Truncate(24 * time.Hour)
+
+	err := r.rolledUp(rollupQuery{shortCode: shortCode, filter: filter, since: startDate}, dimDay).
+		Select("date, SUM(count) as clicks").
+		Group("date").
 		Order("date ASC").
 		Scan(&stats).Error
-	
+
 	return stats, err
 }
 
-func (r *StatsRepository) GetClicksByDevice(shortCode string) ([]models.DeviceStats, error) {
+func (r *StatsRepository) GetClicksByDevice(shortCode string, filter models.StatsFilter) ([]models.DeviceStats, error) {
 	var stats []models.DeviceStats
-	
-	err := r.db.Model(&models.Click{}).
-		Select("device, COUNT(*) as count").
-		Where("short_code = ?", shortCode).
-		Group("device").
-		Order("count DESC").
-		Scan(&stats).Error
-	
+	err := r.breakdown(rollupQuery{shortCode: shortCode, filter: filter}, dimDevice, 0, &stats)
 	return stats, err
 }
 
-func (r *StatsRepository) GetClicksByBrowser(shortCode string) ([]models.BrowserStats, error) {
+func (r *StatsRepository) GetClicksByBrowser(shortCode string, filter models.StatsFilter) ([]models.BrowserStats, error) {
 	var stats []models.BrowserStats
-	
-	err := r.db.Model(&models.Click{}).
-		Select("browser, COUNT(*) as count").
-		Where("short_code = ?", shortCode).
-		Group("browser").
-		Order("count DESC").
-		Scan(&stats).Error
-	
+	err := r.breakdown(rollupQuery{shortCode: shortCode, filter: filter}, dimBrowser, 0, &stats)
 	return stats, err
 }
 
-func (r *StatsRepository) GetClicksByReferer(shortCode string) ([]models.RefererStats, error) {
+func (r *StatsRepository) GetClicksByOS(shortCode string, filter models.StatsFilter) ([]models.OSStats, error) {
+	var stats []models.OSStats
+	err := r.breakdown(rollupQuery{shortCode: shortCode, filter: filter}, dimOS, 0, &stats)
+	return stats, err
+}
+
+func (r *StatsRepository) GetClicksByBot(shortCode string) ([]models.BotStats, error) {
+	var stats []models.BotStats
+	err := r.breakdown(rollupQuery{shortCode: shortCode, bots: true}, dimBot, 0, &stats)
+	return stats, err
+}
+
+// GetClicksByReferer groups by normalized referrer domain. Direct traffic
+// (no referrer) is reported through GetClicksByChannel instead.
+func (r *StatsRepository) GetClicksByReferer(shortCode string, filter models.StatsFilter) ([]models.RefererStats, error) {
 	var stats []models.RefererStats
-	
-	err := r.db.Model(&models.Click{}).
-		Select("referer, COUNT(*) as count").
-		Where("short_code = ?", shortCode).
-		Group("referer").
-		Order("count DESC").
-		Limit(10).
-		Scan(&stats).Error
-	
+	q := rollupQuery{shortCode: shortCode, filter: filter, where: "COALESCE(referer_domain, '') <> ''"}
+	err := r.breakdown(q, dimReferer, 10, &stats)
+	return stats, err
+}
+
+func (r *StatsRepository) GetClicksByChannel(shortCode string, filter models.StatsFilter) ([]models.ChannelStats, error) {
+	var stats []models.ChannelStats
+	err := r.breakdown(rollupQuery{shortCode: shortCode, filter: filter}, dimChannel, 0, &stats)
 	return stats, err
 }
 
-func (r *StatsRepository) GetOverallStats() (*models.OverallStats, error) {
+func (r *StatsRepository) GetOverallStats(filter models.StatsFilter) (*models.OverallStats, error) {
 	var stats models.OverallStats
-	
+
+	today := time.Now().Truncate(24 * time.Hour)
+	all := rollupQuery{filter: filter}
+	sinceToday := rollupQuery{filter: filter, since: today, hourly: true}
+
 	// Total clicks
-	r.db.Model(&models.Click{}).Count(&stats.TotalClicks)
-	
+	stats.TotalClicks, _ = r.countRolledUp(all)
+
 	// Today's clicks
-	today := time.Now().Truncate(24 * time.Hour)
-	r.db.Model(&models.Click{}).Where("created_at >= ?", today).Count(&stats.TodayClicks)
-	
+	stats.TodayClicks, _ = r.countRolledUp(sinceToday)
+
 	// Total unique URLs
-	r.db.Model(&models.Click{}).Distinct("short_code").Count(&stats.TotalURLs)
-	
+	r.rolledUp(all, dimShortCode).Select("COUNT(DISTINCT short_code)").Scan(&stats.TotalURLs)
+
 	// Active URLs (clicked today)
-	r.db.Model(&models.Click{}).Where("created_at >= ?", today).Distinct("short_code").Count(&stats.ActiveURLs)
-	
+	r.rolledUp(sinceToday, dimShortCode).Select("COUNT(DISTINCT short_code)").Scan(&stats.ActiveURLs)
+
 	return &stats, nil
 }
 
-func (r *StatsRepository) GetRecentClicks(limit int) ([]models.Click, error) {
+// GetRecentClicks returns the latest clicks on the user's links.
+func (r *StatsRepository) GetRecentClicks(userID uuid.UUID, limit int, filter models.StatsFilter) ([]models.Click, error) {
 	var clicks []models.Click
-	err := r.db.Order("created_at DESC").Limit(limit).Find(&clicks).Error
+	err := r.clicks(filter).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&clicks).Error
 	return clicks, err
 }
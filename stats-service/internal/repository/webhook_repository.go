@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+)
+
+func (r *StatsRepository) CreateWebhookEndpoint(endpoint *models.WebhookEndpoint) error {
+	return r.db.Create(endpoint).Error
+}
+
+// UpdateWebhookEndpoint stores the owner's changes to an endpoint. Unlike
+// Save it never re-creates an endpoint deleted meanwhile.
+func (r *StatsRepository) UpdateWebhookEndpoint(endpoint *models.WebhookEndpoint) error {
+	return r.db.Model(endpoint).
+		Select("url", "events", "enabled", "failure_count", "failing_since", "disabled_reason").
+		Updates(endpoint).Error
+}
+
+// UpdateWebhookEndpointHealth stores the dispatcher's view of an endpoint:
+// its failure count and whether it was disabled for failing. Settings the
+// owner changed meanwhile are left alone, and endpoints deleted or disabled
+// meanwhile are not touched.
+func (r *StatsRepository) UpdateWebhookEndpointHealth(endpoint *models.WebhookEndpoint) error {
+	return r.db.Model(&models.WebhookEndpoint{}).
+		Where("id = ? AND enabled = ?", endpoint.ID, true).
+		Updates(map[string]interface{}{
+			"failure_count":   endpoint.FailureCount,
+			"failing_since":   endpoint.FailingSince,
+			"enabled":         endpoint.Enabled,
+			"disabled_reason": endpoint.DisabledReason,
+		}).Error
+}
+
+func (r *StatsRepository) FindWebhookEndpoint(userID, id uuid.UUID) (*models.WebhookEndpoint, error) {
+	var endpoint models.WebhookEndpoint
+	err := r.db.First(&endpoint, "id = ? AND user_id = ?", id, userID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+func (r *StatsRepository) ListWebhookEndpoints(userID uuid.UUID) ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&endpoints).Error
+	return endpoints, err
+}
+
+func (r *StatsRepository) ListEnabledWebhookEndpoints() ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	err := r.db.Where("enabled = ?", true).Find(&endpoints).Error
+	return endpoints, err
+}
+
+// DeleteWebhookEndpoint removes an endpoint together with its delivery log.
+func (r *StatsRepository) DeleteWebhookEndpoint(userID, id uuid.UUID) error {
+	if err := r.db.Where("endpoint_id = ? AND user_id = ?", id, userID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+		return err
+	}
+	return r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.WebhookEndpoint{}).Error
+}
+
+func (r *StatsRepository) CreateWebhookDeliveries(deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return r.db.Create(&deliveries).Error
+}
+
+// UpdateWebhookDelivery stores the outcome of a delivery attempt. Unlike
+// Save it never re-creates a delivery deleted with its endpoint meanwhile.
+func (r *StatsRepository) UpdateWebhookDelivery(delivery *models.WebhookDelivery) error {
+	return r.db.Model(delivery).
+		Select("status", "attempts", "response_status", "last_error", "next_attempt_at", "delivered_at").
+		Updates(delivery).Error
+}
+
+func (r *StatsRepository) FindWebhookDelivery(userID, id uuid.UUID) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := r.db.First(&delivery, "id = ? AND user_id = ?", id, userID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ListWebhookDeliveries returns the user's deliveries, newest first,
+// optionally for one endpoint.
+func (r *StatsRepository) ListWebhookDeliveries(userID uuid.UUID, endpointID *uuid.UUID, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	query := r.db.Where("user_id = ?", userID)
+	if endpointID != nil {
+		query = query.Where("endpoint_id = ?", *endpointID)
+	}
+	err := query.Order("created_at DESC").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// DueWebhookDeliveries returns pending deliveries whose next attempt is due.
+func (r *StatsRepository) DueWebhookDeliveries(now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := r.db.Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+		Order("next_attempt_at").
+		Limit(limit).
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+// FailPendingWebhookDeliveries gives up on an endpoint's pending deliveries.
+func (r *StatsRepository) FailPendingWebhookDeliveries(endpointID uuid.UUID, reason string) error {
+	return r.db.Model(&models.WebhookDelivery{}).
+		Where("endpoint_id = ? AND status = ?", endpointID, models.DeliveryPending).
+		Updates(map[string]interface{}{
+			"status":          models.DeliveryFailed,
+			"last_error":      reason,
+			"next_attempt_at": nil,
+		}).Error
+}
+
+// PurgeWebhookDeliveries deletes finished deliveries created before cutoff.
+func (r *StatsRepository) PurgeWebhookDeliveries(cutoff time.Time) error {
+	return r.db.Where("created_at < ? AND status <> ?", cutoff, models.DeliveryPending).
+		Delete(&models.WebhookDelivery{}).Error
+}
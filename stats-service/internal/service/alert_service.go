@@ -0,0 +1,441 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/repository"
+	"github.com/urlshortener/stats-service/pkg/webhook"
+	"gorm.io/gorm"
+)
+
+const (
+	// Per-minute click counts are kept for this long; a drop rule's window
+	// plus its one-hour baseline must fit.
+	alertHistoryMinutes = 120
+	maxDropWindow       = 60
+	maxAlertAttempts    = 8
+	alertDispatchBatch  = 50
+)
+
+// minuteCounts is a ring of per-minute click counts ending at last (a Unix
+// minute).
+type minuteCounts struct {
+	counts [alertHistoryMinutes]int64
+	last   int64
+}
+
+func (m *minuteCounts) advance(minute int64) {
+	if minute <= m.last {
+		return
+	}
+	if minute-m.last >= alertHistoryMinutes {
+		m.counts = [alertHistoryMinutes]int64{}
+	} else {
+		for i := m.last + 1; i <= minute; i++ {
+			m.counts[i%alertHistoryMinutes] = 0
+		}
+	}
+	m.last = minute
+}
+
+func (m *minuteCounts) add(minute int64) {
+	m.advance(minute)
+	if minute > m.last-alertHistoryMinutes {
+		m.counts[minute%alertHistoryMinutes]++
+	}
+}
+
+// sum counts the clicks in minutes [from, to).
+func (m *minuteCounts) sum(from, to int64) int64 {
+	if oldest := m.last - alertHistoryMinutes + 1; from < oldest {
+		from = oldest
+	}
+	var total int64
+	for i := from; i < to && i <= m.last; i++ {
+		total += m.counts[i%alertHistoryMinutes]
+	}
+	return total
+}
+
+type alertKey struct {
+	rule      uuid.UUID
+	shortCode string
+}
+
+// AlertService evaluates owners' alert rules against the live click stream
+// and delivers triggered alerts to their webhooks. Evaluation state lives in
+// memory; alerts and their delivery state are stored so they survive
+// restarts.
+type AlertService struct {
+	repo   *repository.StatsRepository
+	sender *webhook.Sender
+
+	mu        sync.Mutex
+	rules     map[uuid.UUID][]*models.AlertRule // enabled rules by owner
+	ruleByID  map[uuid.UUID]*models.AlertRule
+	counters  map[alertKey]*minuteCounts
+	fired     map[alertKey]time.Time
+	countries map[string]map[string]bool // known countries by short code
+
+	countriesSince time.Time
+}
+
+func NewAlertService(repo *repository.StatsRepository, sender *webhook.Sender) *AlertService {
+	return &AlertService{
+		repo:      repo,
+		sender:    sender,
+		rules:     make(map[uuid.UUID][]*models.AlertRule),
+		ruleByID:  make(map[uuid.UUID]*models.AlertRule),
+		counters:  make(map[alertKey]*minuteCounts),
+		fired:     make(map[alertKey]time.Time),
+		countries: make(map[string]map[string]bool),
+	}
+}
+
+func (s *AlertService) ListRules(userID uuid.UUID) ([]models.AlertRule, error) {
+	return s.repo.ListAlertRules(userID)
+}
+
+func (s *AlertService) CreateRule(userID uuid.UUID, req *models.AlertRuleRequest) (*models.AlertRule, error) {
+	rule := &models.AlertRule{UserID: userID, Secret: webhook.NewSecret(), Enabled: true, CooldownMinutes: 60}
+	if err := applyRuleRequest(rule, req); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateAlertRule(rule); err != nil {
+		return nil, err
+	}
+	return rule, s.ReloadRules()
+}
+
+func (s *AlertService) UpdateRule(userID, id uuid.UUID, req *models.AlertRuleRequest) (*models.AlertRule, error) {
+	rule, err := s.repo.FindAlertRule(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyRuleRequest(rule, req); err != nil {
+		return nil, err
+	}
+	if err := s.repo.SaveAlertRule(rule); err != nil {
+		return nil, err
+	}
+	return rule, s.ReloadRules()
+}
+
+func (s *AlertService) DeleteRule(userID, id uuid.UUID) error {
+	if _, err := s.repo.FindAlertRule(userID, id); err != nil {
+		return err
+	}
+	if err := s.repo.DeleteAlertRule(userID, id); err != nil {
+		return err
+	}
+	return s.ReloadRules()
+}
+
+func (s *AlertService) ListAlerts(userID uuid.UUID, ruleID *uuid.UUID, limit int) ([]models.Alert, error) {
+	return s.repo.ListAlerts(userID, ruleID, limit)
+}
+
+func applyRuleRequest(rule *models.AlertRule, req *models.AlertRuleRequest) error {
+	if err := webhook.ValidateURL(req.WebhookURL); err != nil {
+		return err
+	}
+	switch req.Type {
+	case models.AlertSpike:
+		if req.Threshold < 1 {
+			return errors.New("spike rules need a threshold of at least 1 click per minute")
+		}
+	case models.AlertDrop:
+		if req.Threshold < 1 {
+			return errors.New("drop rules need a threshold of at least 1 click per hour")
+		}
+		if req.WindowMinutes < 1 || req.WindowMinutes > maxDropWindow {
+			return fmt.Errorf("drop rules need window_minutes between 1 and %d", maxDropWindow)
+		}
+	case models.AlertNewCountry:
+	default:
+		return errors.New("type must be spike, drop or new_country")
+	}
+
+	rule.ShortCode = req.ShortCode
+	rule.Type = req.Type
+	rule.Threshold = req.Threshold
+	rule.WindowMinutes = req.WindowMinutes
+	rule.WebhookURL = req.WebhookURL
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if req.CooldownMinutes != nil {
+		rule.CooldownMinutes = *req.CooldownMinutes
+	}
+	return nil
+}
+
+// ReloadRules refreshes the enabled rules and drops state of removed ones.
+func (s *AlertService) ReloadRules() error {
+	rules, err := s.repo.ListEnabledAlertRules()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rules = make(map[uuid.UUID][]*models.AlertRule)
+	s.ruleByID = make(map[uuid.UUID]*models.AlertRule, len(rules))
+	for i := range rules {
+		rule := &rules[i]
+		s.rules[rule.UserID] = append(s.rules[rule.UserID], rule)
+		s.ruleByID[rule.ID] = rule
+	}
+	for key := range s.counters {
+		if s.ruleByID[key.rule] == nil {
+			delete(s.counters, key)
+		}
+	}
+	for key := range s.fired {
+		if s.ruleByID[key.rule] == nil {
+			delete(s.fired, key)
+		}
+	}
+	return nil
+}
+
+// Observe evaluates a recorded click against its owner's rules. Bot clicks
+// are ignored. The database is only queried outside the lock, so a slow
+// query does not hold up clicks on other links.
+func (s *AlertService) Observe(click *models.Click) {
+	if click.IsBot || click.UserID == nil {
+		return
+	}
+
+	if click.Country != "" && s.needsCountries(click) {
+		s.loadCountries(click)
+	}
+
+	s.mu.Lock()
+	var alerts []*models.Alert
+	minute := click.CreatedAt.Unix() / 60
+	for _, rule := range s.rules[*click.UserID] {
+		if rule.ShortCode != "" && rule.ShortCode != click.ShortCode {
+			continue
+		}
+		key := alertKey{rule: rule.ID, shortCode: click.ShortCode}
+
+		switch rule.Type {
+		case models.AlertSpike, models.AlertDrop:
+			counts := s.counters[key]
+			if counts == nil {
+				counts = &minuteCounts{last: minute}
+				s.counters[key] = counts
+			}
+			counts.add(minute)
+
+			if rule.Type == models.AlertSpike {
+				if n := counts.sum(minute, minute+1); n > rule.Threshold {
+					alerts = s.trigger(alerts, rule, key, float64(n), click.CreatedAt,
+						fmt.Sprintf("%d clicks on %s within a minute (threshold %d)", n, click.ShortCode, rule.Threshold))
+				}
+			}
+		case models.AlertNewCountry:
+			if click.Country != "" && s.isNewCountry(click) {
+				alerts = s.trigger(alerts, rule, key, 0, click.CreatedAt,
+					fmt.Sprintf("First click on %s from %s", click.ShortCode, click.Country))
+			}
+		}
+	}
+
+	if known := s.countries[click.ShortCode]; known != nil && click.Country != "" {
+		known[click.Country] = true
+	}
+	s.mu.Unlock()
+
+	s.store(alerts)
+}
+
+// needsCountries reports whether a new_country rule applies to the click
+// and its link's known countries have not been loaded yet.
+func (s *AlertService) needsCountries(click *models.Click) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.countries[click.ShortCode] != nil {
+		return false
+	}
+	for _, rule := range s.rules[*click.UserID] {
+		if rule.Type == models.AlertNewCountry && (rule.ShortCode == "" || rule.ShortCode == click.ShortCode) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCountries loads the countries the click's link had clicks from before
+// it, unless another click loaded them meanwhile.
+func (s *AlertService) loadCountries(click *models.Click) {
+	countries, err := s.repo.GetCountries(click.ShortCode, click.CreatedAt)
+	if err != nil {
+		log.Printf("Alerts: Error loading countries for %s: %v", click.ShortCode, err)
+		return
+	}
+	known := make(map[string]bool, len(countries))
+	for _, country := range countries {
+		known[country] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.countries[click.ShortCode] == nil {
+		s.countries[click.ShortCode] = known
+	}
+}
+
+// isNewCountry reports whether the click is the first on its link from its
+// country. A link's very first click is not reported, nor are clicks whose
+// link's known countries could not be loaded.
+func (s *AlertService) isNewCountry(click *models.Click) bool {
+	known := s.countries[click.ShortCode]
+	return len(known) > 0 && !known[click.Country]
+}
+
+// Tick evaluates drop rules and expires idle state. It runs once a minute.
+func (s *AlertService) Tick(now time.Time) {
+	if err := s.ReloadRules(); err != nil {
+		log.Printf("Alerts: Error reloading rules: %v", err)
+	}
+
+	s.mu.Lock()
+	var alerts []*models.Alert
+	minute := now.Unix() / 60
+	for key, counts := range s.counters {
+		counts.advance(minute)
+		rule := s.ruleByID[key.rule]
+		if rule == nil || counts.sum(minute-alertHistoryMinutes+1, minute+1) == 0 {
+			delete(s.counters, key)
+			continue
+		}
+		if rule.Type != models.AlertDrop {
+			continue
+		}
+
+		quietFrom := minute - int64(rule.WindowMinutes) + 1
+		baseline := counts.sum(quietFrom-60, quietFrom)
+		if counts.sum(quietFrom, minute+1) == 0 && baseline >= rule.Threshold {
+			alerts = s.trigger(alerts, rule, key, float64(baseline), now,
+				fmt.Sprintf("No clicks on %s for %d minutes after %d in the hour before",
+					key.shortCode, rule.WindowMinutes, baseline))
+		}
+	}
+	// Forget known countries now and then so the cache stays bounded
+	if now.Sub(s.countriesSince) >= time.Hour {
+		s.countries = make(map[string]map[string]bool)
+		s.countriesSince = now
+	}
+	s.mu.Unlock()
+
+	s.store(alerts)
+}
+
+// trigger appends an alert to alerts unless the rule fired for the same
+// link within its cooldown. It is called with the lock held; the alerts are
+// stored by store once it is released.
+func (s *AlertService) trigger(alerts []*models.Alert, rule *models.AlertRule, key alertKey, value float64, at time.Time, message string) []*models.Alert {
+	cooldown := time.Duration(rule.CooldownMinutes) * time.Minute
+	if last, ok := s.fired[key]; ok && at.Sub(last) < cooldown {
+		return alerts
+	}
+	s.fired[key] = at
+	rule.LastTriggeredAt = &at
+
+	now := time.Now()
+	return append(alerts, &models.Alert{
+		RuleID:        rule.ID,
+		UserID:        rule.UserID,
+		ShortCode:     key.shortCode,
+		Type:          rule.Type,
+		Message:       message,
+		Value:         value,
+		TriggeredAt:   at,
+		Status:        models.AlertPending,
+		NextAttemptAt: &now,
+	})
+}
+
+// store saves triggered alerts for delivery.
+func (s *AlertService) store(alerts []*models.Alert) {
+	for _, alert := range alerts {
+		if err := s.repo.CreateAlert(alert); err != nil {
+			log.Printf("Alerts: Error storing alert for rule %s: %v", alert.RuleID, err)
+			continue
+		}
+		if err := s.repo.MarkAlertRuleTriggered(alert.RuleID, alert.TriggeredAt); err != nil {
+			log.Printf("Alerts: Error updating rule %s: %v", alert.RuleID, err)
+		}
+	}
+}
+
+// Dispatch delivers due alerts to their webhooks, rescheduling failures with
+// exponential backoff until maxAlertAttempts is reached.
+func (s *AlertService) Dispatch(ctx context.Context) error {
+	alerts, err := s.repo.DueAlerts(time.Now(), alertDispatchBatch)
+	if err != nil {
+		return err
+	}
+
+	for i := range alerts {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.deliver(ctx, &alerts[i])
+	}
+	return nil
+}
+
+func (s *AlertService) deliver(ctx context.Context, alert *models.Alert) {
+	alert.Attempts++
+
+	rule, err := s.repo.FindAlertRule(alert.UserID, alert.RuleID)
+	if err == nil {
+		body, _ := json.Marshal(models.AlertPayload{
+			ID:          alert.ID,
+			RuleID:      alert.RuleID,
+			Type:        alert.Type,
+			ShortCode:   alert.ShortCode,
+			Message:     alert.Message,
+			Value:       alert.Value,
+			TriggeredAt: alert.TriggeredAt,
+		})
+		_, err = s.sender.Send(ctx, rule.WebhookURL, rule.Secret, "alert."+string(alert.Type), alert.ID.String(), body)
+	} else if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = errors.New("alert rule was deleted")
+		alert.Attempts = maxAlertAttempts
+	}
+
+	now := time.Now()
+	switch {
+	case err == nil:
+		alert.Status = models.AlertDelivered
+		alert.DeliveredAt = &now
+		alert.NextAttemptAt = nil
+		alert.LastError = ""
+	case alert.Attempts >= maxAlertAttempts:
+		alert.Status = models.AlertFailed
+		alert.NextAttemptAt = nil
+		alert.LastError = err.Error()
+	default:
+		next := now.Add(webhook.Backoff(alert.Attempts))
+		alert.NextAttemptAt = &next
+		alert.LastError = err.Error()
+	}
+
+	if err := s.repo.SaveAlert(alert); err != nil {
+		log.Printf("Alerts: Error saving alert %s: %v", alert.ID, err)
+	}
+}
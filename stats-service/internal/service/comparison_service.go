@@ -0,0 +1,308 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/repository"
+)
+
+const (
+	maxCompareLinks           = 10
+	maxCompareBreakdowns      = 6
+	maxCompareBreakdownValues = 10
+	// Ranges up to this long default to hourly points
+	hourlyCompareRange = 48 * time.Hour
+)
+
+var defaultCompareBreakdowns = []string{
+	models.DimensionDevice, models.DimensionCountry, models.DimensionReferer, models.DimensionChannel,
+}
+
+// ComparisonService lays the performance of several links, or of one link
+// over two periods, side by side.
+type ComparisonService struct {
+	repo *repository.StatsRepository
+}
+
+func NewComparisonService(repo *repository.StatsRepository) *ComparisonService {
+	return &ComparisonService{repo: repo}
+}
+
+// Normalize fills in defaults and validates q.
+func (s *ComparisonService) Normalize(q *models.ComparisonQuery) error {
+	if q.Mode == "" {
+		q.Mode = models.ComparePeriods
+		if len(q.ShortCodes) > 1 {
+			q.Mode = models.CompareLinks
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, code := range q.ShortCodes {
+		if seen[code] {
+			return fmt.Errorf("short code %q given twice", code)
+		}
+		seen[code] = true
+	}
+
+	if !q.From.Before(q.To) {
+		return errors.New("from must be before to")
+	}
+	length := q.To.Sub(q.From)
+	if length > maxQueryRange {
+		return errors.New("time range must not exceed 366 days")
+	}
+
+	switch q.Mode {
+	case models.CompareLinks:
+		if len(q.ShortCodes) < 2 || len(q.ShortCodes) > maxCompareLinks {
+			return fmt.Errorf("compare 2 to %d links", maxCompareLinks)
+		}
+	case models.ComparePeriods:
+		if len(q.ShortCodes) > 1 {
+			return errors.New("compare periods of a single link, or of all links")
+		}
+		if q.BaselineFrom.IsZero() {
+			q.BaselineFrom = q.From.Add(-length)
+		}
+	default:
+		return errors.New("mode must be links or periods")
+	}
+
+	switch q.Granularity {
+	case "":
+		q.Granularity = models.DimensionDay
+		if length <= hourlyCompareRange {
+			q.Granularity = models.DimensionHour
+		}
+	case models.DimensionDay:
+	case models.DimensionHour:
+		if length > maxHourlyQueryRange {
+			return errors.New("time range must not exceed 31 days for hourly points")
+		}
+	default:
+		return errors.New("granularity must be day or hour")
+	}
+
+	if len(q.Breakdowns) == 0 {
+		q.Breakdowns = defaultCompareBreakdowns
+	}
+	if len(q.Breakdowns) > maxCompareBreakdowns {
+		return fmt.Errorf("at most %d breakdowns are allowed", maxCompareBreakdowns)
+	}
+	for _, dim := range q.Breakdowns {
+		if !repository.IsQueryDimension(dim) || repository.IsTimeDimension(dim) || dim == models.DimensionLink {
+			return fmt.Errorf("cannot break down by %q: use device, browser, os, country, referer, channel or bot", dim)
+		}
+	}
+	return nil
+}
+
+// comparedSeries is one side of a comparison before deltas are applied.
+type comparedSeries struct {
+	label, shortCode string
+	from, to         time.Time
+	points           map[string]int64
+	breakdowns       map[string]map[string]int64
+}
+
+// Compare answers a query that has been through Normalize.
+func (s *ComparisonService) Compare(q models.ComparisonQuery) (*models.Comparison, error) {
+	var series []*comparedSeries
+	reference := 0
+
+	if q.Mode == models.CompareLinks {
+		byLink := make(map[string]*comparedSeries, len(q.ShortCodes))
+		for _, code := range q.ShortCodes {
+			cs := &comparedSeries{label: code, shortCode: code, from: q.From, to: q.To}
+			byLink[code] = cs
+			series = append(series, cs)
+		}
+		if err := s.fill(q, q.From, q.To, byLink); err != nil {
+			return nil, err
+		}
+	} else {
+		code := ""
+		if len(q.ShortCodes) == 1 {
+			code = q.ShortCodes[0]
+		}
+		length := q.To.Sub(q.From)
+		for _, p := range []struct {
+			label    string
+			from, to time.Time
+		}{
+			{"current", q.From, q.To},
+			{"baseline", q.BaselineFrom, q.BaselineFrom.Add(length)},
+		} {
+			cs := &comparedSeries{label: p.label, shortCode: code, from: p.from, to: p.to}
+			if err := s.fill(q, p.from, p.to, map[string]*comparedSeries{"": cs}); err != nil {
+				return nil, err
+			}
+			series = append(series, cs)
+		}
+		reference = 1
+	}
+
+	return compare(q, series, reference), nil
+}
+
+// fill loads points and breakdowns for [from, to) into the series, keyed
+// by short code in links mode and by "" otherwise.
+func (s *ComparisonService) fill(q models.ComparisonQuery, from, to time.Time, series map[string]*comparedSeries) error {
+	for _, cs := range series {
+		cs.breakdowns = make(map[string]map[string]int64, len(q.Breakdowns))
+	}
+
+	dims := append([]string{q.Granularity}, q.Breakdowns...)
+	for _, dim := range dims {
+		aq := models.AnalyticsQuery{
+			UserID:  q.UserID,
+			Metrics: []string{models.MetricClicks},
+			GroupBy: []string{dim},
+			From:    from,
+			To:      to,
+			Filter:  q.Filter,
+		}
+		if q.Mode == models.CompareLinks {
+			aq.GroupBy = []string{models.DimensionLink, dim}
+			aq.Filters = []models.QueryFilter{{Dimension: models.DimensionLink, Values: q.ShortCodes}}
+		} else if len(q.ShortCodes) == 1 {
+			aq.ShortCode = q.ShortCodes[0]
+		}
+
+		rows, err := s.repo.Query(aq)
+		if err != nil {
+			return err
+		}
+
+		counts := make(map[string]map[string]int64, len(series))
+		for key := range series {
+			counts[key] = make(map[string]int64)
+		}
+		for _, row := range rows {
+			if byValue, ok := counts[row.Dimensions[models.DimensionLink]]; ok {
+				byValue[row.Dimensions[dim]] += row.Metrics[models.MetricClicks]
+			}
+		}
+		for key, cs := range series {
+			if dim == q.Granularity {
+				cs.points = counts[key]
+			} else {
+				cs.breakdowns[dim] = counts[key]
+			}
+		}
+	}
+	return nil
+}
+
+func compare(q models.ComparisonQuery, series []*comparedSeries, reference int) *models.Comparison {
+	ref := series[reference]
+	refBuckets := buckets(ref.from, ref.to, q.Granularity)
+	refTotal := sum(ref.points)
+
+	// Breakdown values are ordered by their clicks across all series so
+	// they line up side by side
+	values := make(map[string][]string, len(q.Breakdowns))
+	for _, dim := range q.Breakdowns {
+		totals := make(map[string]int64)
+		for _, cs := range series {
+			for value, clicks := range cs.breakdowns[dim] {
+				totals[value] += clicks
+			}
+		}
+		values[dim] = topValues(totals, maxCompareBreakdownValues)
+	}
+
+	result := &models.Comparison{
+		Mode:        q.Mode,
+		Granularity: q.Granularity,
+		Reference:   ref.label,
+		Series:      make([]models.ComparisonSeries, 0, len(series)),
+	}
+	for _, cs := range series {
+		out := models.ComparisonSeries{
+			Label:      cs.label,
+			ShortCode:  cs.shortCode,
+			From:       cs.from,
+			To:         cs.to,
+			Total:      comparedValue(sum(cs.points), refTotal),
+			Breakdowns: make(map[string][]models.ComparisonBreakdown, len(q.Breakdowns)),
+		}
+		for i, bucket := range buckets(cs.from, cs.to, q.Granularity) {
+			var refClicks int64
+			if i < len(refBuckets) {
+				refClicks = ref.points[refBuckets[i]]
+			}
+			out.Points = append(out.Points, models.ComparisonPoint{
+				Bucket:          bucket,
+				ComparisonValue: comparedValue(cs.points[bucket], refClicks),
+			})
+		}
+		for _, dim := range q.Breakdowns {
+			breakdown := make([]models.ComparisonBreakdown, 0, len(values[dim]))
+			for _, value := range values[dim] {
+				breakdown = append(breakdown, models.ComparisonBreakdown{
+					Value:           value,
+					ComparisonValue: comparedValue(cs.breakdowns[dim][value], ref.breakdowns[dim][value]),
+				})
+			}
+			out.Breakdowns[dim] = breakdown
+		}
+		result.Series = append(result.Series, out)
+	}
+	return result
+}
+
+func comparedValue(clicks, reference int64) models.ComparisonValue {
+	v := models.ComparisonValue{Clicks: clicks, Delta: clicks - reference}
+	if reference > 0 {
+		pct := math.Round(float64(v.Delta)/float64(reference)*1000) / 10
+		v.DeltaPct = &pct
+	}
+	return v
+}
+
+// buckets lists the day or hour labels covering [from, to), formatted as the
+// day and hour query dimensions are.
+func buckets(from, to time.Time, granularity string) []string {
+	step, layout := 24*time.Hour, "2006-01-02"
+	if granularity == models.DimensionHour {
+		step, layout = time.Hour, "2006-01-02 15:00"
+	}
+
+	var labels []string
+	for t := from.UTC().Truncate(step); t.Before(to); t = t.Add(step) {
+		labels = append(labels, t.Format(layout))
+	}
+	return labels
+}
+
+func topValues(totals map[string]int64, limit int) []string {
+	values := make([]string, 0, len(totals))
+	for value := range totals {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if totals[values[i]] != totals[values[j]] {
+			return totals[values[i]] > totals[values[j]]
+		}
+		return values[i] < values[j]
+	})
+	if len(values) > limit {
+		values = values[:limit]
+	}
+	return values
+}
+
+func sum(counts map[string]int64) int64 {
+	var total int64
+	for _, clicks := range counts {
+		total += clicks
+	}
+	return total
+}
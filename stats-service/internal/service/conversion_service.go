@@ -0,0 +1,144 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/shared/clickid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/repository"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownClick      = errors.New("unknown click ID")
+	ErrAttributionWindow = errors.New("click is outside the attribution window")
+	ErrUnknownLink       = errors.New("link not found")
+)
+
+// ConversionService attributes conversions to the clicks that led to them.
+type ConversionService struct {
+	repo            *repository.StatsRepository
+	clickIDs        *clickid.Signer
+	window          time.Duration
+	defaultCurrency string
+}
+
+// NewConversionService creates the service. Click IDs must be signed with
+// clickIDSecret, the CLICK_ID_SECRET url-service signs them with, and
+// conversions more than windowDays after their click are rejected.
+func NewConversionService(repo *repository.StatsRepository, clickIDSecret string, windowDays int, defaultCurrency string) *ConversionService {
+	clickIDs := clickid.NewSigner(clickIDSecret)
+	if clickIDs == nil {
+		log.Println("CLICK_ID_SECRET is not set, conversions cannot be attributed")
+	}
+	if windowDays <= 0 {
+		windowDays = 30
+	}
+	if defaultCurrency == "" {
+		defaultCurrency = "USD"
+	}
+	return &ConversionService{
+		repo:            repo,
+		clickIDs:        clickIDs,
+		window:          time.Duration(windowDays) * 24 * time.Hour,
+		defaultCurrency: strings.ToUpper(defaultCurrency),
+	}
+}
+
+// Record stores a conversion for the click named in req by its signed
+// click ID. The click must have been recorded; when ownerID is set
+// (server-side calls) it must also be on one of the owner's links. Record
+// reports whether the conversion is new rather than a repeat.
+//
+// Without ownerID (pixel and beacon) anyone holding the click ID can call
+// it, so revenue and external IDs are ignored: such a click converts at most
+// once per name and never carries revenue.
+func (s *ConversionService) Record(req *models.ConversionRequest, source string, ownerID *uuid.UUID) (*models.Conversion, bool, error) {
+	if ownerID == nil {
+		req.ExternalID, req.Revenue, req.Currency = "", 0, ""
+	}
+
+	if s.clickIDs == nil {
+		return nil, false, ErrUnknownClick
+	}
+	clickID, err := s.clickIDs.Verify(req.ClickID)
+	if err != nil {
+		return nil, false, ErrUnknownClick
+	}
+
+	click, err := s.repo.FindClick(clickID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, ErrUnknownClick
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if ownerID != nil && (click.UserID == nil || *click.UserID != *ownerID) {
+		return nil, false, ErrUnknownClick
+	}
+	if time.Since(click.CreatedAt) > s.window {
+		return nil, false, ErrAttributionWindow
+	}
+
+	name := strings.ToLower(strings.TrimSpace(req.Name))
+	if name == "" {
+		name = "conversion"
+	}
+	currency := strings.ToUpper(req.Currency)
+	if currency == "" {
+		currency = s.defaultCurrency
+	}
+
+	conversion := &models.Conversion{
+		ClickID:    click.ID,
+		Name:       name,
+		ExternalID: strings.TrimSpace(req.ExternalID),
+		ShortCode:  click.ShortCode,
+		UserID:     click.UserID,
+		IsBot:      click.IsBot,
+		Revenue:    req.Revenue,
+		Currency:   currency,
+		Source:     source,
+		ClickedAt:  click.CreatedAt,
+	}
+	created, err := s.repo.CreateConversion(conversion)
+	if err != nil {
+		return nil, false, err
+	}
+	return conversion, created, nil
+}
+
+// GetStats sums up the conversions of one of userID's links.
+func (s *ConversionService) GetStats(userID uuid.UUID, shortCode string, filter models.StatsFilter) (*models.ConversionStats, error) {
+	owned, err := s.repo.IsLinkOwner(shortCode, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !owned {
+		return nil, ErrUnknownLink
+	}
+
+	clicks, err := s.repo.GetTotalClicks(shortCode, filter)
+	if err != nil {
+		return nil, err
+	}
+	conversions, convertedClicks, revenue, err := s.repo.GetConversionStats(shortCode, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &models.ConversionStats{
+		ShortCode:   shortCode,
+		Clicks:      clicks,
+		Conversions: conversions,
+		Revenue:     revenue,
+	}
+	if clicks > 0 {
+		stats.ConversionRate = float64(convertedClicks) / float64(clicks)
+	}
+	return stats, nil
+}
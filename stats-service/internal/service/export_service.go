@@ -0,0 +1,72 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/urlshortener/stats-service/internal/export"
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/repository"
+)
+
+// maxExportRange bounds a single export; larger ranges should be split.
+const maxExportRange = 366 * 24 * time.Hour
+
+// ExportService streams raw clicks and aggregated stats in bulk formats for
+// loading into a data warehouse.
+type ExportService struct {
+	repo *repository.StatsRepository
+}
+
+func NewExportService(repo *repository.StatsRepository) *ExportService {
+	return &ExportService{repo: repo}
+}
+
+// ValidateQuery checks an export request before any output is written.
+func (s *ExportService) ValidateQuery(format models.ExportFormat, q models.ExportQuery) error {
+	switch format {
+	case models.ExportCSV, models.ExportNDJSON, models.ExportParquet:
+	default:
+		return errors.New("format must be csv, ndjson or parquet")
+	}
+	if !q.From.Before(q.To) {
+		return errors.New("from must be before to")
+	}
+	if q.To.Sub(q.From) > maxExportRange {
+		return errors.New("time range must not exceed 366 days")
+	}
+	return nil
+}
+
+// ExportClicks writes the raw clicks matched by q to w.
+func (s *ExportService) ExportClicks(w io.Writer, format models.ExportFormat, q models.ExportQuery) error {
+	enc, err := export.NewEncoder(format, w, new(models.ClickExportRow))
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.EachClick(q, func(click *models.Click) error {
+		return enc.Encode(models.NewClickExportRow(click))
+	})
+	if err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// ExportStats writes the URLStats breakdowns of every link matched by q to w.
+func (s *ExportService) ExportStats(w io.Writer, format models.ExportFormat, q models.ExportQuery) error {
+	enc, err := export.NewEncoder(format, w, new(models.StatsExportRow))
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.EachStat(q, func(row models.StatsExportRow) error {
+		return enc.Encode(row)
+	})
+	if err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+)
+
+const (
+	// Clicks are counted in Redis sorted sets per time bucket: 5-minute
+	// buckets for the last-hour window and trending, hourly ones for longer
+	// windows and the trending baseline.
+	fineBucket       = 5 * time.Minute
+	coarseBucket     = time.Hour
+	fineBucketTTL    = 3 * time.Hour
+	coarseBucketTTL  = 8 * 24 * time.Hour
+	unionCacheTTL    = 30 * time.Second
+	trendingBaseline = 24 // hours
+	trendingPool     = 100
+)
+
+// LeaderboardService ranks links by recent clicks, per user and globally.
+type LeaderboardService struct {
+	redis     *redis.Client
+	minClicks int64
+	minGrowth float64
+}
+
+// NewLeaderboardService creates the service. A link trends once it has at
+// least minClicks in the last hour and minGrowth times its baseline rate.
+func NewLeaderboardService(redisClient *redis.Client, minClicks int64, minGrowth float64) *LeaderboardService {
+	if minClicks <= 0 {
+		minClicks = 20
+	}
+	if minGrowth <= 0 {
+		minGrowth = 3
+	}
+	return &LeaderboardService{redis: redisClient, minClicks: minClicks, minGrowth: minGrowth}
+}
+
+// scopeKey names the sorted sets of a user, or the global ones for uuid.Nil.
+func scopeKey(userID uuid.UUID) string {
+	if userID == uuid.Nil {
+		return "leaderboard:global"
+	}
+	return "leaderboard:user:" + userID.String()
+}
+
+func bucketKey(scope string, size time.Duration, t time.Time) string {
+	return fmt.Sprintf("%s:%d:%d", scope, int(size.Minutes()), t.Unix()/int64(size.Seconds()))
+}
+
+// bucketKeys returns the keys of n consecutive buckets ending skip buckets
+// before the one holding now.
+func bucketKeys(scope string, size time.Duration, now time.Time, skip, n int) []string {
+	keys := make([]string, 0, n)
+	for i := skip; i < skip+n; i++ {
+		keys = append(keys, bucketKey(scope, size, now.Add(-time.Duration(i)*size)))
+	}
+	return keys
+}
+
+// Record counts a click. Bot clicks are ignored.
+func (s *LeaderboardService) Record(ctx context.Context, click *models.Click) error {
+	if click.IsBot {
+		return nil
+	}
+
+	scopes := []string{scopeKey(uuid.Nil)}
+	if click.UserID != nil {
+		scopes = append(scopes, scopeKey(*click.UserID))
+	}
+
+	pipe := s.redis.Pipeline()
+	for _, scope := range scopes {
+		for _, b := range []struct {
+			size time.Duration
+			ttl  time.Duration
+		}{{fineBucket, fineBucketTTL}, {coarseBucket, coarseBucketTTL}} {
+			key := bucketKey(scope, b.size, click.CreatedAt)
+			pipe.ZIncrBy(ctx, key, 1, click.ShortCode)
+			pipe.Expire(ctx, key, b.ttl)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// windowKeys returns the buckets covering window.
+func windowKeys(scope string, window models.LeaderboardWindow, now time.Time) ([]string, error) {
+	switch window {
+	case models.WindowHour:
+		return bucketKeys(scope, fineBucket, now, 0, int(time.Hour/fineBucket)), nil
+	case models.WindowDay:
+		return bucketKeys(scope, coarseBucket, now, 0, 24), nil
+	case models.WindowWeek:
+		return bucketKeys(scope, coarseBucket, now, 0, 7*24), nil
+	default:
+		return nil, errors.New("window must be hour, day or week")
+	}
+}
+
+// union sums the bucket sets into a short-lived cached key and returns it.
+func (s *LeaderboardService) union(ctx context.Context, scope, name string, keys []string, now time.Time) (string, error) {
+	dest := fmt.Sprintf("%s:union:%s:%d", scope, name, now.Unix()/int64(unionCacheTTL.Seconds()))
+	exists, err := s.redis.Exists(ctx, dest).Result()
+	if err != nil || exists > 0 {
+		return dest, err
+	}
+
+	pipe := s.redis.TxPipeline()
+	pipe.ZUnionStore(ctx, dest, &redis.ZStore{Keys: keys})
+	pipe.Expire(ctx, dest, 2*unionCacheTTL)
+	_, err = pipe.Exec(ctx)
+	return dest, err
+}
+
+// Top returns the most clicked links in window for a user, or across all
+// users when userID is uuid.Nil.
+func (s *LeaderboardService) Top(ctx context.Context, userID uuid.UUID, window models.LeaderboardWindow, limit int) (*models.Leaderboard, error) {
+	now := time.Now()
+	scope := scopeKey(userID)
+
+	keys, err := windowKeys(scope, window, now)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := s.union(ctx, scope, string(window), keys, now)
+	if err != nil {
+		return nil, err
+	}
+
+	top, err := s.redis.ZRevRangeWithScores(ctx, dest, 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	board := &models.Leaderboard{Window: window, Entries: make([]models.LeaderboardEntry, 0, len(top))}
+	for _, z := range top {
+		board.Entries = append(board.Entries, models.LeaderboardEntry{
+			ShortCode: z.Member.(string),
+			Clicks:    int64(z.Score),
+		})
+	}
+	return board, nil
+}
+
+// Trending returns links whose clicks in the last hour are well above their
+// average hourly clicks over the day before, sharpest rise first.
+func (s *LeaderboardService) Trending(ctx context.Context, userID uuid.UUID, limit int) ([]models.TrendingEntry, error) {
+	now := time.Now()
+	scope := scopeKey(userID)
+
+	recentKey, err := s.union(ctx, scope, "recent", bucketKeys(scope, fineBucket, now, 0, int(time.Hour/fineBucket)), now)
+	if err != nil {
+		return nil, err
+	}
+	// The baseline skips the current and previous hour, which overlap the
+	// recent window
+	baselineKey, err := s.union(ctx, scope, "baseline", bucketKeys(scope, coarseBucket, now, 2, trendingBaseline), now)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := s.redis.ZRevRangeByScoreWithScores(ctx, recentKey, &redis.ZRangeBy{
+		Min:   fmt.Sprint(s.minClicks),
+		Max:   "+inf",
+		Count: trendingPool,
+	}).Result()
+	if err != nil || len(candidates) == 0 {
+		return []models.TrendingEntry{}, err
+	}
+
+	members := make([]string, len(candidates))
+	for i, z := range candidates {
+		members[i] = z.Member.(string)
+	}
+	baselines, err := s.redis.ZMScore(ctx, baselineKey, members...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	trending := make([]models.TrendingEntry, 0, len(candidates))
+	for i, z := range candidates {
+		rate := baselines[i] / trendingBaseline
+		// Links with (almost) no history are compared with one click an hour
+		growth := z.Score / max(rate, 1)
+		if growth < s.minGrowth {
+			continue
+		}
+		trending = append(trending, models.TrendingEntry{
+			ShortCode:    members[i],
+			RecentClicks: int64(z.Score),
+			BaselineRate: rate,
+			Growth:       growth,
+		})
+	}
+
+	sort.Slice(trending, func(i, j int) bool { return trending[i].Growth > trending[j].Growth })
+	if len(trending) > limit {
+		trending = trending[:limit]
+	}
+	return trending, nil
+}
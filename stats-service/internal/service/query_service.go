@@ -0,0 +1,113 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/repository"
+)
+
+const (
+	maxQueryRange       = 366 * 24 * time.Hour
+	maxHourlyQueryRange = 31 * 24 * time.Hour
+	maxQueryGroupBy     = 3
+	maxFilterValues     = 50
+	defaultQueryLimit   = 100
+	maxQueryLimit       = 1000
+)
+
+// QueryService answers ad-hoc analytics queries over the user's clicks.
+type QueryService struct {
+	repo *repository.StatsRepository
+}
+
+func NewQueryService(repo *repository.StatsRepository) *QueryService {
+	return &QueryService{repo: repo}
+}
+
+// Normalize fills in defaults and checks q against the whitelisted metrics
+// and dimensions.
+func (s *QueryService) Normalize(q *models.AnalyticsQuery) error {
+	if len(q.Metrics) == 0 {
+		q.Metrics = []string{models.MetricClicks}
+	}
+	seen := make(map[string]bool)
+	for _, metric := range q.Metrics {
+		if metric != models.MetricClicks && metric != models.MetricUniques {
+			return fmt.Errorf("unknown metric %q: use clicks or uniques", metric)
+		}
+		if seen[metric] {
+			return fmt.Errorf("metric %q given twice", metric)
+		}
+		seen[metric] = true
+	}
+
+	if len(q.GroupBy) > maxQueryGroupBy {
+		return fmt.Errorf("at most %d group_by dimensions are allowed", maxQueryGroupBy)
+	}
+	seen = make(map[string]bool)
+	for _, dim := range q.GroupBy {
+		if !repository.IsQueryDimension(dim) {
+			return unknownDimension(dim)
+		}
+		if seen[dim] {
+			return fmt.Errorf("dimension %q given twice", dim)
+		}
+		seen[dim] = true
+	}
+	if seen[models.DimensionDay] && seen[models.DimensionHour] {
+		return errors.New("group by day or hour, not both")
+	}
+
+	seen = make(map[string]bool)
+	for _, f := range q.Filters {
+		if !repository.IsQueryDimension(f.Dimension) {
+			return unknownDimension(f.Dimension)
+		}
+		if repository.IsTimeDimension(f.Dimension) {
+			return fmt.Errorf("cannot filter on %q: use from and to", f.Dimension)
+		}
+		if seen[f.Dimension] {
+			return fmt.Errorf("dimension %q filtered twice: list the values together", f.Dimension)
+		}
+		seen[f.Dimension] = true
+		if len(f.Values) == 0 || len(f.Values) > maxFilterValues {
+			return fmt.Errorf("filter on %q needs 1 to %d values", f.Dimension, maxFilterValues)
+		}
+	}
+
+	if !q.From.Before(q.To) {
+		return errors.New("from must be before to")
+	}
+	if q.To.Sub(q.From) > maxQueryRange {
+		return errors.New("time range must not exceed 366 days")
+	}
+	for _, dim := range q.GroupBy {
+		if dim == models.DimensionHour && q.To.Sub(q.From) > maxHourlyQueryRange {
+			return errors.New("time range must not exceed 31 days when grouping by hour")
+		}
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = defaultQueryLimit
+	}
+	if q.Limit > maxQueryLimit {
+		q.Limit = maxQueryLimit
+	}
+	return nil
+}
+
+// Run answers a query that has been through Normalize.
+func (s *QueryService) Run(q models.AnalyticsQuery) (*models.AnalyticsResult, error) {
+	rows, err := s.repo.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	return &models.AnalyticsResult{Query: q, Rows: rows}, nil
+}
+
+func unknownDimension(name string) error {
+	return fmt.Errorf("unknown dimension %q: use day, hour, link, device, browser, os, country, referer, channel, bot or tag", name)
+}
@@ -0,0 +1,349 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/shared/mailer"
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/report"
+	"github.com/urlshortener/stats-service/internal/repository"
+	"gorm.io/gorm"
+)
+
+const (
+	reportBatch    = 50
+	reportTopLinks = 10
+	reportLogLimit = 100
+	// A report that fails to send is retried for a while, then skipped
+	reportRetryDelay  = 15 * time.Minute
+	reportRetryWindow = 6 * time.Hour
+)
+
+var (
+	ErrMailDisabled    = errors.New("email delivery is not configured")
+	ErrEmailUnverified = errors.New("verify your email address to receive reports")
+)
+
+// ReportService emails users scheduled summaries of their links' clicks.
+type ReportService struct {
+	repo         *repository.StatsRepository
+	mailer       *mailer.Mailer // nil when SMTP is not configured
+	sendHour     time.Duration  // reports go out this long after a period ends (UTC)
+	publicURL    string         // base URL of this service, for unsubscribe links
+	dashboardURL string
+}
+
+func NewReportService(repo *repository.StatsRepository, mailer *mailer.Mailer, sendHour int, publicURL, dashboardURL string) *ReportService {
+	if sendHour < 0 || sendHour > 23 {
+		sendHour = 8
+	}
+	return &ReportService{
+		repo:         repo,
+		mailer:       mailer,
+		sendHour:     time.Duration(sendHour) * time.Hour,
+		publicURL:    publicURL,
+		dashboardURL: dashboardURL,
+	}
+}
+
+func (s *ReportService) ListSubscriptions(userID uuid.UUID) ([]models.ReportSubscription, error) {
+	return s.repo.ListReportSubscriptions(userID)
+}
+
+// Subscribe creates or updates the user's subscription for a frequency.
+// Reports go to accountEmail, which must be verified; subscribing again
+// picks up a changed address.
+func (s *ReportService) Subscribe(userID uuid.UUID, accountEmail string, verified bool, req *models.ReportSubscriptionRequest) (*models.ReportSubscription, error) {
+	if accountEmail == "" {
+		return nil, errors.New("the account has no email address")
+	}
+	if !verified {
+		return nil, ErrEmailUnverified
+	}
+
+	sub, err := s.repo.FindReportSubscriptionByFrequency(userID, req.Frequency)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		token, err := newUnsubscribeToken()
+		if err != nil {
+			return nil, err
+		}
+		sub = &models.ReportSubscription{UserID: userID, Frequency: req.Frequency, UnsubscribeToken: token}
+	} else if err != nil {
+		return nil, err
+	}
+
+	wasEnabled := sub.Enabled
+	sub.Enabled = req.Enabled == nil || *req.Enabled
+	sub.Email = accountEmail
+	if sub.Enabled && !wasEnabled {
+		sub.NextRunAt = s.nextRun(sub.Frequency, time.Now())
+	}
+
+	if err := s.repo.SaveReportSubscription(sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (s *ReportService) DeleteSubscription(userID, id uuid.UUID) error {
+	if _, err := s.repo.FindReportSubscription(userID, id); err != nil {
+		return err
+	}
+	return s.repo.DeleteReportSubscription(userID, id)
+}
+
+// FindByUnsubscribeToken returns the subscription an email's unsubscribe
+// link is for, without changing it.
+func (s *ReportService) FindByUnsubscribeToken(token string) (*models.ReportSubscription, error) {
+	return s.repo.FindReportSubscriptionByToken(token)
+}
+
+// Unsubscribe disables the subscription an email's unsubscribe link is for.
+func (s *ReportService) Unsubscribe(token string) (*models.ReportSubscription, error) {
+	sub, err := s.repo.FindReportSubscriptionByToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if sub.Enabled {
+		sub.Enabled = false
+		if err := s.repo.DisableReportSubscription(sub.ID); err != nil {
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// UpdateEmail follows a change of the user's address in user-service.
+// Reports only go to verified addresses, so they are held while a new one
+// is unverified.
+func (s *ReportService) UpdateEmail(userID uuid.UUID, email string, verified bool) error {
+	if !verified {
+		email = ""
+	}
+	return s.repo.SetReportEmail(userID, email)
+}
+
+func (s *ReportService) ListDeliveries(userID uuid.UUID) ([]models.ReportDelivery, error) {
+	return s.repo.ListReportDeliveries(userID, reportLogLimit)
+}
+
+// Preview builds the report the user would get for the last complete
+// period, without an unsubscribe link.
+func (s *ReportService) Preview(userID uuid.UUID, frequency string) (*models.Report, error) {
+	to := periodStart(frequency, time.Now())
+	return s.build(userID, frequency, shiftPeriod(frequency, to, -1), to)
+}
+
+// SendNow emails the report for the last complete period straight away,
+// outside the schedule. The attempt is logged either way.
+func (s *ReportService) SendNow(userID, id uuid.UUID) (*models.ReportDelivery, error) {
+	if s.mailer == nil {
+		return nil, ErrMailDisabled
+	}
+	sub, err := s.repo.FindReportSubscription(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	to := periodStart(sub.Frequency, time.Now())
+	return s.send(sub, shiftPeriod(sub.Frequency, to, -1), to)
+}
+
+// SendDue emails every report whose time has come. Periods missed while the
+// service was down are skipped rather than sent late in a burst.
+func (s *ReportService) SendDue(ctx context.Context, now time.Time) error {
+	if s.mailer == nil {
+		return nil
+	}
+
+	subs, err := s.repo.DueReportSubscriptions(now, reportBatch)
+	if err != nil {
+		return err
+	}
+
+	for i := range subs {
+		if ctx.Err() != nil {
+			return nil
+		}
+		sub := &subs[i]
+
+		if latest := periodStart(sub.Frequency, now.Add(-s.sendHour)).Add(s.sendHour); sub.NextRunAt.Before(latest) {
+			sub.NextRunAt = latest
+		}
+		from, to := s.reportPeriod(sub.Frequency, sub.NextRunAt)
+
+		_, err := s.send(sub, from, to)
+		switch {
+		case err == nil:
+			sub.LastSentAt = &now
+			sub.NextRunAt = s.nextRun(sub.Frequency, now)
+		case now.Before(to.Add(s.sendHour + reportRetryWindow)):
+			log.Printf("Failed to send %s report to %s, retrying: %v", sub.Frequency, sub.Email, err)
+			sub.NextRunAt = now.Add(reportRetryDelay)
+		default:
+			log.Printf("Failed to send %s report to %s, giving up: %v", sub.Frequency, sub.Email, err)
+			sub.NextRunAt = s.nextRun(sub.Frequency, now)
+		}
+
+		if err := s.repo.UpdateReportSchedule(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ReportService) send(sub *models.ReportSubscription, from, to time.Time) (*models.ReportDelivery, error) {
+	delivery := &models.ReportDelivery{
+		SubscriptionID: sub.ID,
+		UserID:         sub.UserID,
+		Email:          sub.Email,
+		Frequency:      sub.Frequency,
+		PeriodFrom:     from,
+		PeriodTo:       to,
+		Status:         models.ReportSent,
+	}
+
+	err := s.deliver(sub, from, to)
+	if err != nil {
+		delivery.Status = models.ReportFailed
+		delivery.Error = err.Error()
+	}
+	if logErr := s.repo.CreateReportDelivery(delivery); logErr != nil {
+		log.Printf("Failed to log report delivery: %v", logErr)
+	}
+	return delivery, err
+}
+
+func (s *ReportService) deliver(sub *models.ReportSubscription, from, to time.Time) error {
+	r, err := s.build(sub.UserID, sub.Frequency, from, to)
+	if err != nil {
+		return fmt.Errorf("build report: %w", err)
+	}
+	unsubscribeURL := s.publicURL + "/api/stats/reports/unsubscribe?token=" + sub.UnsubscribeToken
+	r.UnsubscribeURL = unsubscribeURL
+
+	html, text, err := report.Render(r)
+	if err != nil {
+		return fmt.Errorf("render report: %w", err)
+	}
+
+	return s.mailer.Send(mailer.Message{
+		To:      sub.Email,
+		Subject: report.Subject(r),
+		Text:    text,
+		HTML:    html,
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+}
+
+// build summarizes the user's clicks in [from, to) against the period before.
+func (s *ReportService) build(userID uuid.UUID, frequency string, from, to time.Time) (*models.Report, error) {
+	current, err := s.clicksByLink(userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := s.clicksByLink(userID, shiftPeriod(frequency, from, -1), from)
+	if err != nil {
+		return nil, err
+	}
+
+	previousByLink := make(map[string]int64, len(previous))
+	var currentTotal, previousTotal int64
+	for _, row := range previous {
+		previousByLink[row.Dimensions[models.DimensionLink]] = row.Metrics[models.MetricClicks]
+		previousTotal += row.Metrics[models.MetricClicks]
+	}
+
+	r := &models.Report{
+		Frequency:    frequency,
+		From:         from,
+		To:           to,
+		Links:        len(current),
+		DashboardURL: s.dashboardURL,
+	}
+	for i, row := range current {
+		clicks := row.Metrics[models.MetricClicks]
+		currentTotal += clicks
+		if i < reportTopLinks {
+			code := row.Dimensions[models.DimensionLink]
+			r.TopLinks = append(r.TopLinks, models.ReportLink{
+				ShortCode:       code,
+				ComparisonValue: comparedValue(clicks, previousByLink[code]),
+			})
+		}
+	}
+	r.Total = comparedValue(currentTotal, previousTotal)
+	return r, nil
+}
+
+// clicksByLink returns the user's clicks per link, most clicked first.
+func (s *ReportService) clicksByLink(userID uuid.UUID, from, to time.Time) ([]models.AnalyticsRow, error) {
+	return s.repo.Query(models.AnalyticsQuery{
+		UserID:  userID,
+		Metrics: []string{models.MetricClicks},
+		GroupBy: []string{models.DimensionLink},
+		From:    from,
+		To:      to,
+	})
+}
+
+// nextRun returns when the first report after t is due: sendHour after the
+// start of a period.
+func (s *ReportService) nextRun(frequency string, t time.Time) time.Time {
+	start := periodStart(frequency, t)
+	if run := start.Add(s.sendHour); run.After(t) {
+		return run
+	}
+	return shiftPeriod(frequency, start, 1).Add(s.sendHour)
+}
+
+// reportPeriod returns the period a report run at run covers: the one that
+// ended most recently before it was scheduled.
+func (s *ReportService) reportPeriod(frequency string, run time.Time) (time.Time, time.Time) {
+	to := periodStart(frequency, run.Add(-s.sendHour))
+	return shiftPeriod(frequency, to, -1), to
+}
+
+// periodStart returns the start of the day, week (from Monday) or month
+// holding t, in UTC.
+func periodStart(frequency string, t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch frequency {
+	case models.ReportWeekly:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case models.ReportMonthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+// shiftPeriod moves t by n days, weeks or months.
+func shiftPeriod(frequency string, t time.Time, n int) time.Time {
+	switch frequency {
+	case models.ReportWeekly:
+		return t.AddDate(0, 0, 7*n)
+	case models.ReportMonthly:
+		return t.AddDate(0, n, 0)
+	default:
+		return t.AddDate(0, 0, n)
+	}
+}
+
+func newUnsubscribeToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
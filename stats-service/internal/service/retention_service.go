@@ -0,0 +1,204 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/repository"
+	"gorm.io/gorm"
+)
+
+// RetentionService purges raw clicks older than the configured number of
+// days. Aggregated stats are unaffected: only clicks already folded into the
+// rollups are purged, whole days at a time, and each purge first advances
+// the boundary below which a rollup rebuild leaves the rollups alone.
+type RetentionService struct {
+	repo        *repository.StatsRepository
+	defaultDays int
+	batchSize   int
+}
+
+// NewRetentionService creates the service. defaultDays == 0 keeps raw clicks
+// forever unless a user override says otherwise.
+func NewRetentionService(repo *repository.StatsRepository, defaultDays, batchSize int) *RetentionService {
+	if batchSize <= 0 {
+		batchSize = 5000
+	}
+	return &RetentionService{repo: repo, defaultDays: defaultDays, batchSize: batchSize}
+}
+
+func (s *RetentionService) GetPolicy(userID uuid.UUID) (*models.RetentionPolicy, error) {
+	override, err := s.repo.FindRetentionOverride(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.RetentionPolicy{Days: s.defaultDays}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &models.RetentionPolicy{Days: s.capped(override.Days), Overridden: true}, nil
+}
+
+// SetOverride lets a user keep raw clicks for a shorter time than the
+// default. Only with no default (keep forever) may they pick any period.
+func (s *RetentionService) SetOverride(userID uuid.UUID, days int) (*models.RetentionPolicy, error) {
+	if days < 0 {
+		return nil, errors.New("days must not be negative")
+	}
+	if s.capped(days) != days {
+		return nil, fmt.Errorf("days must be between 1 and %d; overrides can only shorten retention", s.defaultDays)
+	}
+
+	override := &models.RetentionOverride{UserID: userID, Days: days}
+	if existing, err := s.repo.FindRetentionOverride(userID); err == nil {
+		override.CreatedAt = existing.CreatedAt
+	}
+	if err := s.repo.SaveRetentionOverride(override); err != nil {
+		return nil, err
+	}
+	return &models.RetentionPolicy{Days: days, Overridden: true}, nil
+}
+
+// capped limits an override to the default, so overrides saved before the
+// default was lowered cannot keep clicks longer than everyone else's.
+func (s *RetentionService) capped(days int) int {
+	if s.defaultDays > 0 && (days == 0 || days > s.defaultDays) {
+		return s.defaultDays
+	}
+	return days
+}
+
+func (s *RetentionService) ClearOverride(userID uuid.UUID) error {
+	return s.repo.DeleteRetentionOverride(userID)
+}
+
+// Purge applies every retention rule. With dryRun set nothing is deleted and
+// the report lists what would have been.
+func (s *RetentionService) Purge(dryRun bool) (*models.PurgeReport, error) {
+	overrides, err := s.repo.ListRetentionOverrides()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	watermark := s.repo.RollupWatermark()
+	report := &models.PurgeReport{DryRun: dryRun, GeneratedAt: now}
+
+	// Rebuilds keep the rollups below the most recent cutoff of any rule,
+	// since some users' raw clicks from before it are gone. A deleted
+	// user's rollups go with their clicks, so PurgeUser needs no boundary.
+	if !dryRun {
+		boundary := time.Time{}
+		for _, override := range overrides {
+			days := s.capped(override.Days)
+			if c := cutoff(days, now, watermark); days > 0 && c.After(boundary) {
+				boundary = c
+			}
+		}
+		if c := cutoff(s.defaultDays, now, watermark); s.defaultDays > 0 && c.After(boundary) {
+			boundary = c
+		}
+		if !boundary.IsZero() {
+			if err := s.repo.AdvancePurgeBoundary(boundary); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	excluded := make([]uuid.UUID, 0, len(overrides))
+	for _, override := range overrides {
+		excluded = append(excluded, override.UserID)
+		days := s.capped(override.Days)
+		if days == 0 {
+			continue
+		}
+
+		userID := override.UserID
+		scope := models.PurgeScope{UserID: &userID, Days: days, Cutoff: cutoff(days, now, watermark)}
+		if err := s.purgeScope(&scope, nil, dryRun); err != nil {
+			return report, err
+		}
+		report.Scopes = append(report.Scopes, scope)
+		report.TotalClicks += scope.Clicks
+	}
+
+	if s.defaultDays > 0 {
+		scope := models.PurgeScope{Days: s.defaultDays, Cutoff: cutoff(s.defaultDays, now, watermark)}
+		if err := s.purgeScope(&scope, excluded, dryRun); err != nil {
+			return report, err
+		}
+		report.Scopes = append(report.Scopes, scope)
+		report.TotalClicks += scope.Clicks
+	}
+
+	return report, nil
+}
+
+// Report is a dry run limited to the clicks on one user's links.
+func (s *RetentionService) Report(userID uuid.UUID) (*models.PurgeReport, error) {
+	policy, err := s.GetPolicy(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	report := &models.PurgeReport{DryRun: true, GeneratedAt: now}
+	if policy.Days == 0 {
+		return report, nil
+	}
+
+	scope := models.PurgeScope{UserID: &userID, Days: policy.Days, Cutoff: cutoff(policy.Days, now, s.repo.RollupWatermark())}
+	if err := s.purgeScope(&scope, nil, true); err != nil {
+		return nil, err
+	}
+	report.Scopes = append(report.Scopes, scope)
+	report.TotalClicks = scope.Clicks
+	return report, nil
+}
+
+// PurgeUser deletes everything stored about a deleted account and returns
+// how many raw clicks went with it.
+func (s *RetentionService) PurgeUser(userID uuid.UUID) (int64, error) {
+	scope := &models.PurgeScope{UserID: &userID, Cutoff: time.Now()}
+	if err := s.purgeScope(scope, nil, false); err != nil {
+		return scope.Clicks, err
+	}
+	return scope.Clicks, s.repo.DeleteUserData(userID)
+}
+
+func (s *RetentionService) purgeScope(scope *models.PurgeScope, excluded []uuid.UUID, dryRun bool) error {
+	if dryRun {
+		count, err := s.repo.CountPurgeableClicks(scope.UserID, excluded, scope.Cutoff)
+		scope.Clicks = count
+		return err
+	}
+
+	for {
+		deleted, err := s.repo.PurgeClicks(scope.UserID, excluded, scope.Cutoff, s.batchSize)
+		scope.Clicks += deleted
+		if err != nil {
+			return err
+		}
+		if deleted < int64(s.batchSize) {
+			break
+		}
+	}
+
+	if scope.Clicks > 0 {
+		log.Printf("Retention: purged %d clicks older than %s", scope.Clicks, scope.Cutoff.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// cutoff is the start of the day `days` ago, held back to the rollup
+// watermark's day so unrolled clicks are never purged.
+func cutoff(days int, now, watermark time.Time) time.Time {
+	c := now.AddDate(0, 0, -days).Truncate(24 * time.Hour)
+	if limit := watermark.Truncate(24 * time.Hour); limit.Before(c) {
+		return limit
+	}
+	return c
+}
@@ -1,90 +1,166 @@
 package service
 
 import (
-	"strings"
+	"encoding/json"
+	"errors"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/urlshortener/shared/useragent"
 	"github.com/urlshortener/stats-service/internal/models"
 	"github.com/urlshortener/stats-service/internal/repository"
+	"github.com/urlshortener/stats-service/pkg/ipanon"
+	"github.com/urlshortener/stats-service/pkg/referrer"
 )
 
 type StatsService struct {
-	repo *repository.StatsRepository
+	repo      *repository.StatsRepository
+	uaParser  *useragent.Parser
+	referrers *referrer.Classifier
+	ipAnon    *ipanon.Anonymizer
 }
 
-func NewStatsService(repo *repository.StatsRepository) *StatsService {
-	return &StatsService{repo: repo}
+func NewStatsService(repo *repository.StatsRepository, uaParser *useragent.Parser, referrers *referrer.Classifier, ipAnon *ipanon.Anonymizer) *StatsService {
+	return &StatsService{repo: repo, uaParser: uaParser, referrers: referrers, ipAnon: ipAnon}
 }
 
-func (s *StatsService) RecordClick(event *models.ClickEvent) error {
+// RecordClick enriches and stores a click event and returns the stored click.
+func (s *StatsService) RecordClick(event *models.ClickEvent) (*models.Click, error) {
+	ua := s.uaParser.Parse(event.UserAgent)
+
+	// url-service flags bots with its own lighter check; trust either side
+	if event.IsBot && !ua.IsBot {
+		ua.IsBot = true
+		ua.BotName = "Other Bot"
+		ua.Device = useragent.DeviceBot
+	}
+
+	ref := s.referrers.Classify(event.Referer, referrer.UTM{
+		Source:   event.UTM.Source,
+		Medium:   event.UTM.Medium,
+		Campaign: event.UTM.Campaign,
+	})
+
 	click := &models.Click{
-		ShortCode: event.ShortCode,
-		UserAgent: event.UserAgent,
-		IP:        event.IP,
-		Referer:   event.Referer,
-		Device:    s.parseDevice(event.UserAgent),
-		Browser:   s.parseBrowser(event.UserAgent),
-		CreatedAt: event.Timestamp,
+		ShortCode:      event.ShortCode,
+		UserID:         event.UserID,
+		UserAgent:      event.UserAgent,
+		IP:             s.ipAnon.Anonymize(event.IP),
+		Referer:        event.Referer,
+		RefererDomain:  ref.Domain,
+		Channel:        ref.Channel,
+		UTMSource:      event.UTM.Source,
+		UTMMedium:      event.UTM.Medium,
+		UTMCampaign:    event.UTM.Campaign,
+		Country:        event.Country,
+		Device:         ua.Device,
+		Browser:        ua.Browser,
+		BrowserVersion: ua.BrowserVersion,
+		OS:             ua.OS,
+		OSVersion:      ua.OSVersion,
+		IsBot:          ua.IsBot,
+		BotName:        ua.BotName,
+		CreatedAt:      event.Timestamp,
+	}
+	if event.ClickID != nil {
+		click.ID = *event.ClickID
+	}
+
+	// Keep only what the aggregates need: the parsed user-agent, referrer
+	// domain and channel. This also goes for links that skip click storage,
+	// whose clicks still reach the live stream and webhooks
+	if event.DoNotTrack || event.SkipClickStorage {
+		click.IP = ""
+		click.UserAgent = ""
+		click.Referer = ""
+	}
+
+	if event.SkipClickStorage {
+		return click, s.repo.RecordAggregateClick(click)
+	}
+	return click, s.repo.RecordClick(click)
+}
+
+// RecordLinkTags stores the tags of links created or updated in url-service.
+// Tags stay in place once a link is deleted or expires, so its clicks can
+// still be grouped by them.
+func (s *StatsService) RecordLinkTags(event *models.LinkEvent) error {
+	if event.Type != models.EventLinkCreated && event.Type != models.EventLinkUpdated {
+		return nil
 	}
 
-	return s.repo.RecordClick(click)
+	var link struct {
+		ShortCode string   `json:"short_code"`
+		Tags      []string `json:"tags"`
+	}
+	if err := json.Unmarshal(event.Link, &link); err != nil {
+		return err
+	}
+	if link.ShortCode == "" {
+		return errors.New("link event without short code")
+	}
+	return s.repo.SetLinkTags(link.ShortCode, link.Tags)
 }
 
-func (s *StatsService) GetURLStats(shortCode string) (*models.URLStats, error) {
-	totalClicks, err := s.repo.GetTotalClicks(shortCode)
+func (s *StatsService) GetURLStats(shortCode string, filter models.StatsFilter) (*models.URLStats, error) {
+	totalClicks, err := s.repo.GetTotalClicks(shortCode, filter)
 	if err != nil {
 		return nil, err
 	}
 
-	byDay, _ := s.repo.GetClicksByDay(shortCode, 30)
-	byDevice, _ := s.repo.GetClicksByDevice(shortCode)
-	byBrowser, _ := s.repo.GetClicksByBrowser(shortCode)
-	byReferer, _ := s.repo.GetClicksByReferer(shortCode)
+	byDay, _ := s.repo.GetClicksByDay(shortCode, 30, filter)
+	byDevice, _ := s.repo.GetClicksByDevice(shortCode, filter)
+	byBrowser, _ := s.repo.GetClicksByBrowser(shortCode, filter)
+	byOS, _ := s.repo.GetClicksByOS(shortCode, filter)
+	byReferer, _ := s.repo.GetClicksByReferer(shortCode, filter)
+	byChannel, _ := s.repo.GetClicksByChannel(shortCode, filter)
 
-	return &models.URLStats{
+	stats := &models.URLStats{
 		ShortCode:   shortCode,
 		TotalClicks: totalClicks,
 		ByDay:       byDay,
 		ByDevice:    byDevice,
 		ByBrowser:   byBrowser,
+		ByOS:        byOS,
 		ByReferer:   byReferer,
-	}, nil
+		ByChannel:   byChannel,
+	}
+
+	if filter.IncludeBots {
+		stats.BotClicks, _ = s.repo.GetBotClicks(shortCode)
+		stats.ByBot, _ = s.repo.GetClicksByBot(shortCode)
+	}
+
+	return stats, nil
 }
 
-func (s *StatsService) GetOverallStats() (*models.OverallStats, error) {
-	return s.repo.GetOverallStats()
+func (s *StatsService) GetOverallStats(filter models.StatsFilter) (*models.OverallStats, error) {
+	return s.repo.GetOverallStats(filter)
 }
 
-func (s *StatsService) GetRecentClicks(limit int) ([]models.Click, error) {
-	return s.repo.GetRecentClicks(limit)
+func (s *StatsService) GetRecentClicks(userID uuid.UUID, limit int, filter models.StatsFilter) ([]models.Click, error) {
+	return s.repo.GetRecentClicks(userID, limit, filter)
 }
 
-func (s *StatsService) parseDevice(userAgent string) string {
-	ua := strings.ToLower(userAgent)
-	
-	if strings.Contains(ua, "mobile") || strings.Contains(ua, "android") {
-		return "Mobile"
-	}
-	if strings.Contains(ua, "tablet") || strings.Contains(ua, "ipad") {
-		return "Tablet"
+// CompactRollups rolls raw clicks up to the start of the current hour into
+// the rollup tables, a day at a time.
+func (s *StatsService) CompactRollups() error {
+	until := time.Now().Truncate(time.Hour)
+	for {
+		watermark, err := s.repo.CompactRollups(until, 24*time.Hour)
+		if err != nil {
+			return err
+		}
+		if !watermark.Before(until) {
+			return nil
+		}
 	}
-	return "Desktop"
 }
 
-func (s *StatsService) parseBrowser(userAgent string) string {
-	ua := strings.ToLower(userAgent)
-	
-	switch {
-	case strings.Contains(ua, "chrome") && !strings.Contains(ua, "edge"):
-		return "Chrome"
-	case strings.Contains(ua, "firefox"):
-		return "Firefox"
-	case strings.Contains(ua, "safari") && !strings.Contains(ua, "chrome"):
-		return "Safari"
-	case strings.Contains(ua, "edge"):
-		return "Edge"
-	case strings.Contains(ua, "opera"):
-		return "Opera"
-	default:
-		return "Other"
+// RebuildRollups recomputes the rollups from the raw clicks still on record.
+func (s *StatsService) RebuildRollups() error {
+	if err := s.repo.ResetRollups(); err != nil {
+		return err
 	}
+	return s.CompactRollups()
 }
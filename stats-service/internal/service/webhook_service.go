@@ -0,0 +1,334 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+	"github.com/urlshortener/stats-service/internal/repository"
+	"github.com/urlshortener/stats-service/pkg/webhook"
+	"gorm.io/gorm"
+)
+
+const (
+	maxDeliveryAttempts = 8
+	deliveryBatch       = 100
+	// An endpoint is disabled once it has failed this many attempts in a row
+	// over at least this long
+	disableAfterFailures = 10
+	disableAfterFailing  = 24 * time.Hour
+	deliveryLogRetention = 30 * 24 * time.Hour
+)
+
+// WebhookService fans link lifecycle and click events out to the webhook
+// endpoints users subscribe, keeping a delivery log with retries.
+type WebhookService struct {
+	repo   *repository.StatsRepository
+	sender *webhook.Sender
+
+	mu        sync.RWMutex
+	endpoints map[uuid.UUID][]models.WebhookEndpoint // enabled endpoints by owner
+}
+
+func NewWebhookService(repo *repository.StatsRepository, sender *webhook.Sender) *WebhookService {
+	return &WebhookService{
+		repo:      repo,
+		sender:    sender,
+		endpoints: make(map[uuid.UUID][]models.WebhookEndpoint),
+	}
+}
+
+func (s *WebhookService) ListEndpoints(userID uuid.UUID) ([]models.WebhookEndpoint, error) {
+	return s.repo.ListWebhookEndpoints(userID)
+}
+
+func (s *WebhookService) CreateEndpoint(userID uuid.UUID, req *models.WebhookEndpointRequest) (*models.WebhookEndpoint, error) {
+	endpoint := &models.WebhookEndpoint{UserID: userID, Secret: webhook.NewSecret(), Enabled: true}
+	if err := applyEndpointRequest(endpoint, req); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateWebhookEndpoint(endpoint); err != nil {
+		return nil, err
+	}
+	return endpoint, s.ReloadEndpoints()
+}
+
+// UpdateEndpoint replaces an endpoint's settings. Enabling a disabled
+// endpoint clears its failure history.
+func (s *WebhookService) UpdateEndpoint(userID, id uuid.UUID, req *models.WebhookEndpointRequest) (*models.WebhookEndpoint, error) {
+	endpoint, err := s.repo.FindWebhookEndpoint(userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	wasEnabled := endpoint.Enabled
+	if err := applyEndpointRequest(endpoint, req); err != nil {
+		return nil, err
+	}
+	if endpoint.Enabled && !wasEnabled {
+		endpoint.FailureCount = 0
+		endpoint.FailingSince = nil
+		endpoint.DisabledReason = ""
+	}
+
+	if err := s.repo.UpdateWebhookEndpoint(endpoint); err != nil {
+		return nil, err
+	}
+	return endpoint, s.ReloadEndpoints()
+}
+
+func (s *WebhookService) DeleteEndpoint(userID, id uuid.UUID) error {
+	if _, err := s.repo.FindWebhookEndpoint(userID, id); err != nil {
+		return err
+	}
+	if err := s.repo.DeleteWebhookEndpoint(userID, id); err != nil {
+		return err
+	}
+	return s.ReloadEndpoints()
+}
+
+func applyEndpointRequest(endpoint *models.WebhookEndpoint, req *models.WebhookEndpointRequest) error {
+	if err := webhook.ValidateURL(req.URL); err != nil {
+		return err
+	}
+	for _, event := range req.Events {
+		if !isWebhookEventType(event) {
+			return fmt.Errorf("unknown event type %q", event)
+		}
+	}
+
+	endpoint.URL = req.URL
+	endpoint.Events = req.Events
+	if req.Enabled != nil {
+		endpoint.Enabled = *req.Enabled
+	}
+	return nil
+}
+
+func isWebhookEventType(eventType string) bool {
+	for _, t := range models.WebhookEventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *WebhookService) ListDeliveries(userID uuid.UUID, endpointID *uuid.UUID, limit int) ([]models.WebhookDelivery, error) {
+	return s.repo.ListWebhookDeliveries(userID, endpointID, limit)
+}
+
+// Redeliver queues the event of a past delivery again, as a new delivery.
+func (s *WebhookService) Redeliver(userID, deliveryID uuid.UUID) (*models.WebhookDelivery, error) {
+	original, err := s.repo.FindWebhookDelivery(userID, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := s.repo.FindWebhookEndpoint(userID, original.EndpointID)
+	if err != nil {
+		return nil, err
+	}
+	if !endpoint.Enabled {
+		return nil, errors.New("endpoint is disabled")
+	}
+
+	now := time.Now()
+	delivery := models.WebhookDelivery{
+		EndpointID:    original.EndpointID,
+		UserID:        userID,
+		EventID:       original.EventID,
+		EventType:     original.EventType,
+		Payload:       original.Payload,
+		Status:        models.DeliveryPending,
+		NextAttemptAt: &now,
+	}
+	if err := s.repo.CreateWebhookDeliveries([]models.WebhookDelivery{delivery}); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ReloadEndpoints refreshes the cached enabled endpoints.
+func (s *WebhookService) ReloadEndpoints() error {
+	endpoints, err := s.repo.ListEnabledWebhookEndpoints()
+	if err != nil {
+		return err
+	}
+
+	byUser := make(map[uuid.UUID][]models.WebhookEndpoint)
+	for _, endpoint := range endpoints {
+		byUser[endpoint.UserID] = append(byUser[endpoint.UserID], endpoint)
+	}
+
+	s.mu.Lock()
+	s.endpoints = byUser
+	s.mu.Unlock()
+	return nil
+}
+
+// Publish queues an event for every endpoint of the user subscribed to it.
+func (s *WebhookService) Publish(userID *uuid.UUID, eventType string, data interface{}, at time.Time) error {
+	if userID == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	var targets []uuid.UUID
+	for _, endpoint := range s.endpoints[*userID] {
+		if endpoint.Subscribed(eventType) {
+			targets = append(targets, endpoint.ID)
+		}
+	}
+	s.mu.RUnlock()
+	if len(targets) == 0 {
+		return nil
+	}
+
+	eventID := uuid.New()
+	payload, err := json.Marshal(models.WebhookPayload{ID: eventID, Type: eventType, CreatedAt: at, Data: data})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	deliveries := make([]models.WebhookDelivery, 0, len(targets))
+	for _, endpointID := range targets {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			EndpointID:    endpointID,
+			UserID:        *userID,
+			EventID:       eventID,
+			EventType:     eventType,
+			Payload:       string(payload),
+			Status:        models.DeliveryPending,
+			NextAttemptAt: &now,
+		})
+	}
+	return s.repo.CreateWebhookDeliveries(deliveries)
+}
+
+// Dispatch attempts due deliveries.
+func (s *WebhookService) Dispatch(ctx context.Context) error {
+	deliveries, err := s.repo.DueWebhookDeliveries(time.Now(), deliveryBatch)
+	if err != nil {
+		return err
+	}
+
+	endpoints := make(map[uuid.UUID]*models.WebhookEndpoint)
+	for i := range deliveries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		delivery := &deliveries[i]
+
+		endpoint, ok := endpoints[delivery.EndpointID]
+		if !ok {
+			endpoint, err = s.repo.FindWebhookEndpoint(delivery.UserID, delivery.EndpointID)
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			endpoints[delivery.EndpointID] = endpoint
+		}
+		s.deliver(ctx, delivery, endpoint)
+	}
+	return nil
+}
+
+func (s *WebhookService) deliver(ctx context.Context, delivery *models.WebhookDelivery, endpoint *models.WebhookEndpoint) {
+	now := time.Now()
+	attemptFailed := false
+	fail := func(reason string) {
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = reason
+		delivery.NextAttemptAt = nil
+	}
+
+	switch {
+	case endpoint == nil:
+		fail("endpoint was deleted")
+	case !endpoint.Enabled:
+		fail("endpoint is disabled")
+	default:
+		delivery.Attempts++
+		status, err := s.sender.Send(ctx, endpoint.URL, endpoint.Secret, delivery.EventType, delivery.EventID.String(), []byte(delivery.Payload))
+		delivery.ResponseStatus = status
+
+		if err == nil {
+			delivery.Status = models.DeliveryDelivered
+			delivery.DeliveredAt = &now
+			delivery.NextAttemptAt = nil
+			delivery.LastError = ""
+			s.recordSuccess(endpoint)
+			break
+		}
+
+		if delivery.Attempts >= maxDeliveryAttempts {
+			fail(err.Error())
+		} else {
+			next := now.Add(webhook.Backoff(delivery.Attempts))
+			delivery.NextAttemptAt = &next
+			delivery.LastError = err.Error()
+		}
+		attemptFailed = true
+	}
+
+	if err := s.repo.UpdateWebhookDelivery(delivery); err != nil {
+		log.Printf("Webhooks: Error saving delivery %s: %v", delivery.ID, err)
+	}
+	if attemptFailed {
+		s.recordFailure(endpoint, now)
+	}
+}
+
+func (s *WebhookService) recordSuccess(endpoint *models.WebhookEndpoint) {
+	if endpoint.FailureCount == 0 {
+		return
+	}
+	endpoint.FailureCount = 0
+	endpoint.FailingSince = nil
+	if err := s.repo.UpdateWebhookEndpointHealth(endpoint); err != nil {
+		log.Printf("Webhooks: Error saving endpoint %s: %v", endpoint.ID, err)
+	}
+}
+
+// recordFailure counts a failed attempt and disables the endpoint once it
+// has kept failing for long enough.
+func (s *WebhookService) recordFailure(endpoint *models.WebhookEndpoint, now time.Time) {
+	endpoint.FailureCount++
+	if endpoint.FailingSince == nil {
+		endpoint.FailingSince = &now
+	}
+
+	disable := endpoint.FailureCount >= disableAfterFailures && now.Sub(*endpoint.FailingSince) >= disableAfterFailing
+	if disable {
+		endpoint.Enabled = false
+		endpoint.DisabledReason = fmt.Sprintf("disabled after %d failed attempts since %s",
+			endpoint.FailureCount, endpoint.FailingSince.UTC().Format(time.RFC3339))
+	}
+
+	if err := s.repo.UpdateWebhookEndpointHealth(endpoint); err != nil {
+		log.Printf("Webhooks: Error saving endpoint %s: %v", endpoint.ID, err)
+		return
+	}
+	if !disable {
+		return
+	}
+
+	log.Printf("Webhooks: Disabled endpoint %s: %s", endpoint.ID, endpoint.DisabledReason)
+	if err := s.repo.FailPendingWebhookDeliveries(endpoint.ID, "endpoint is disabled"); err != nil {
+		log.Printf("Webhooks: Error failing deliveries of endpoint %s: %v", endpoint.ID, err)
+	}
+	if err := s.ReloadEndpoints(); err != nil {
+		log.Printf("Webhooks: Error reloading endpoints: %v", err)
+	}
+}
+
+// PurgeDeliveries trims the delivery log.
+func (s *WebhookService) PurgeDeliveries() error {
+	return s.repo.PurgeWebhookDeliveries(time.Now().Add(-deliveryLogRetention))
+}
@@ -0,0 +1,141 @@
+package stream
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/stats-service/internal/models"
+)
+
+// Event is a recorded click as pushed to live subscribers. IDs increase
+// monotonically and are used for Last-Event-ID resume.
+type Event struct {
+	ID    uint64        `json:"id"`
+	Click *models.Click `json:"click"`
+}
+
+// Filter selects the clicks a subscriber receives: always limited to links
+// owned by UserID, optionally to some short codes, bots excluded by default.
+type Filter struct {
+	UserID      uuid.UUID
+	ShortCodes  map[string]bool
+	IncludeBots bool
+}
+
+func (f Filter) Match(click *models.Click) bool {
+	if click.UserID == nil || *click.UserID != f.UserID {
+		return false
+	}
+	if click.IsBot && !f.IncludeBots {
+		return false
+	}
+	return len(f.ShortCodes) == 0 || f.ShortCodes[click.ShortCode]
+}
+
+// Subscription delivers matching events on C. C is closed when the
+// subscription ends; Lagged then reports whether it was dropped for falling
+// behind, in which case the client should reconnect and resume.
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	filter Filter
+	lagged bool
+}
+
+func (s *Subscription) Lagged() bool {
+	return s.lagged
+}
+
+// Hub fans clicks out to live subscribers and keeps a short history so
+// reconnecting clients can catch up. Slow subscribers are never waited on:
+// once a subscriber's buffer is full it is disconnected.
+type Hub struct {
+	mu      sync.Mutex
+	subs    map[*Subscription]struct{}
+	history []Event
+	size    int
+	buffer  int
+	nextID  uint64
+}
+
+// NewHub keeps the last history events for resume and buffers up to buffer
+// events per subscriber.
+func NewHub(history, buffer int) *Hub {
+	return &Hub{
+		subs:    make(map[*Subscription]struct{}),
+		history: make([]Event, 0, history),
+		size:    history,
+		buffer:  buffer,
+		// Start from the clock so IDs keep increasing across restarts
+		nextID: uint64(time.Now().UnixMilli()) * 1000,
+	}
+}
+
+func (h *Hub) Publish(click *models.Click) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	event := Event{ID: h.nextID, Click: click}
+
+	if len(h.history) == h.size {
+		copy(h.history, h.history[1:])
+		h.history = h.history[:h.size-1]
+	}
+	h.history = append(h.history, event)
+
+	for sub := range h.subs {
+		if !sub.filter.Match(click) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			sub.lagged = true
+			h.remove(sub)
+		}
+	}
+}
+
+// Subscribe registers a subscriber. Events after lastID still in the history
+// are replayed first (at most one buffer's worth, newest kept).
+func (h *Hub) Subscribe(filter Filter, lastID uint64) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan Event, h.buffer)
+	sub := &Subscription{C: ch, ch: ch, filter: filter}
+
+	if lastID > 0 {
+		var missed []Event
+		for _, event := range h.history {
+			if event.ID > lastID && filter.Match(event.Click) {
+				missed = append(missed, event)
+			}
+		}
+		if len(missed) > h.buffer {
+			missed = missed[len(missed)-h.buffer:]
+		}
+		for _, event := range missed {
+			ch <- event
+		}
+	}
+
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(sub)
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	if _, ok := h.subs[sub]; !ok {
+		return
+	}
+	delete(h.subs, sub)
+	close(sub.ch)
+}
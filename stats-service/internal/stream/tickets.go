@@ -0,0 +1,82 @@
+package stream
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// TicketTTL is how long a stream ticket can be redeemed.
+const TicketTTL = 30 * time.Second
+
+var ErrInvalidTicket = errors.New("invalid or expired stream ticket")
+
+// Ticket lets a client that cannot set headers (EventSource, browser
+// WebSockets) open a stream without putting its bearer token in the URL,
+// where access logs would keep it.
+type Ticket struct {
+	UserID        uuid.UUID `json:"user_id"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+}
+
+// Tickets issues short-lived, single-use stream tickets, kept in Redis so
+// any instance can redeem them.
+type Tickets struct {
+	redis *redis.Client
+}
+
+func NewTickets(redisClient *redis.Client) *Tickets {
+	return &Tickets{redis: redisClient}
+}
+
+// Issue stores a ticket for the authenticated user and returns its value.
+func (t *Tickets) Issue(ctx context.Context, ticket Ticket) (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	value := base64.RawURLEncoding.EncodeToString(buf)
+
+	data, err := json.Marshal(ticket)
+	if err != nil {
+		return "", err
+	}
+	if err := t.redis.Set(ctx, ticketKey(value), data, TicketTTL).Err(); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// Redeem returns the ticket stored under value and removes it, so it cannot
+// be used again.
+func (t *Tickets) Redeem(ctx context.Context, value string) (*Ticket, error) {
+	var get *redis.StringCmd
+	_, err := t.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, ticketKey(value))
+		pipe.Del(ctx, ticketKey(value))
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidTicket
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ticket Ticket
+	if err := json.Unmarshal([]byte(get.Val()), &ticket); err != nil {
+		return nil, ErrInvalidTicket
+	}
+	return &ticket, nil
+}
+
+func ticketKey(value string) string {
+	return "stream:ticket:" + value
+}
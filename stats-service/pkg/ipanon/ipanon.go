@@ -0,0 +1,84 @@
+package ipanon
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Mode controls how client IPs are stored.
+type Mode string
+
+const (
+	// ModeFull stores the address unchanged.
+	ModeFull Mode = "full"
+	// ModeTruncate zeroes the last IPv4 octet or everything past the IPv6 /48.
+	ModeTruncate Mode = "truncate"
+	// ModeHash stores a keyed HMAC-SHA256 of the address, so repeat visitors
+	// can still be told apart without keeping the address itself.
+	ModeHash Mode = "hash"
+	// ModeNone drops the address entirely.
+	ModeNone Mode = "none"
+)
+
+type Anonymizer struct {
+	mode Mode
+	key  []byte
+}
+
+// NewAnonymizer validates the mode. An empty mode defaults to truncation;
+// hashing requires a key.
+func NewAnonymizer(mode Mode, key string) (*Anonymizer, error) {
+	switch mode {
+	case "":
+		mode = ModeTruncate
+	case ModeFull, ModeTruncate, ModeNone:
+	case ModeHash:
+		if key == "" {
+			return nil, errors.New("ip hashing requires a key")
+		}
+	default:
+		return nil, fmt.Errorf("unknown ip storage mode %q", mode)
+	}
+	return &Anonymizer{mode: mode, key: []byte(key)}, nil
+}
+
+func (a *Anonymizer) Mode() Mode {
+	return a.mode
+}
+
+// Anonymize returns the form of ip to persist. Unparsable input is dropped
+// unless the mode is full.
+func (a *Anonymizer) Anonymize(ip string) string {
+	if ip == "" || a.mode == ModeFull {
+		return ip
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+
+	switch a.mode {
+	case ModeTruncate:
+		return Truncate(parsed).String()
+	case ModeHash:
+		mac := hmac.New(sha256.New, a.key)
+		mac.Write(parsed.To16())
+		return hex.EncodeToString(mac.Sum(nil))[:32]
+	default:
+		return ""
+	}
+}
+
+// Truncate zeroes the host part of an address: the last octet for IPv4 and
+// everything after the first 48 bits for IPv6.
+func Truncate(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32))
+	}
+	return ip.Mask(net.CIDRMask(48, 128))
+}
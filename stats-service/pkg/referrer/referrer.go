@@ -0,0 +1,171 @@
+package referrer
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	ChannelDirect = "direct"
+	ChannelSearch = "search"
+	ChannelSocial = "social"
+	ChannelEmail  = "email"
+	ChannelOther  = "other"
+)
+
+//go:embed sources.json
+var defaultSources []byte
+
+// Sources is the on-disk mapping of referrer domains and utm_medium values to
+// channels. A domain entry ending in ".*" matches any TLD (e.g. "google.*").
+type Sources struct {
+	Domains    map[string][]string `json:"domains"`
+	UTMMediums map[string][]string `json:"utm_mediums"`
+}
+
+// UTM holds campaign parameters reported with a click.
+type UTM struct {
+	Source   string
+	Medium   string
+	Campaign string
+}
+
+// Result is a normalized referrer.
+type Result struct {
+	Domain  string
+	Channel string
+}
+
+type Classifier struct {
+	domains map[string]string
+	names   map[string]string
+	mediums map[string]string
+}
+
+// LoadSources reads the mapping from a JSON file. An empty path returns the
+// bundled mapping.
+func LoadSources(path string) (*Sources, error) {
+	data := defaultSources
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var sources Sources
+	if err := json.Unmarshal(data, &sources); err != nil {
+		return nil, fmt.Errorf("invalid referrer sources: %w", err)
+	}
+	return &sources, nil
+}
+
+func NewClassifier(sources *Sources) *Classifier {
+	c := &Classifier{
+		domains: make(map[string]string),
+		names:   make(map[string]string),
+		mediums: make(map[string]string),
+	}
+
+	for channel, domains := range sources.Domains {
+		for _, domain := range domains {
+			domain = strings.ToLower(domain)
+			c.domains[domain] = channel
+			// "facebook.com" and "google.*" also let utm_source=facebook
+			// or utm_source=google resolve
+			name, tld, _ := strings.Cut(domain, ".")
+			if len(name) >= 3 && !strings.Contains(tld, ".") {
+				if _, exists := c.names[name]; !exists {
+					c.names[name] = channel
+				}
+			}
+		}
+	}
+	for channel, mediums := range sources.UTMMediums {
+		for _, medium := range mediums {
+			c.mediums[strings.ToLower(medium)] = channel
+		}
+	}
+
+	return c
+}
+
+// Classify normalizes a raw Referer header to its domain and assigns a
+// traffic channel. UTM parameters, when present, take precedence over the
+// referrer since they are set deliberately by the link owner.
+func (c *Classifier) Classify(referer string, utm UTM) Result {
+	result := Result{Domain: Domain(referer)}
+
+	if channel, ok := c.mediums[strings.ToLower(utm.Medium)]; ok {
+		result.Channel = channel
+		return result
+	}
+	if source := strings.ToLower(utm.Source); source != "" {
+		if channel := c.lookup(source); channel != "" {
+			result.Channel = channel
+			return result
+		}
+		if channel, ok := c.names[source]; ok {
+			result.Channel = channel
+			return result
+		}
+	}
+
+	switch {
+	case result.Domain != "":
+		if result.Channel = c.lookup(result.Domain); result.Channel == "" {
+			result.Channel = ChannelOther
+		}
+	case utm.Source != "" || utm.Medium != "":
+		result.Channel = ChannelOther
+	default:
+		result.Channel = ChannelDirect
+	}
+	return result
+}
+
+// lookup matches a domain and then each parent domain against the mapping,
+// trying both the exact name and its any-TLD wildcard form.
+func (c *Classifier) lookup(domain string) string {
+	for candidate := domain; candidate != ""; {
+		if channel, ok := c.domains[candidate]; ok {
+			return channel
+		}
+		name, rest, ok := strings.Cut(candidate, ".")
+		if !ok {
+			break
+		}
+		if channel, ok := c.domains[name+".*"]; ok {
+			return channel
+		}
+		candidate = rest
+	}
+	return ""
+}
+
+// Domain reduces a Referer header to a lower-case host without port or
+// common "www."/"m." prefixes. It returns "" for empty or unparsable values.
+func Domain(referer string) string {
+	referer = strings.TrimSpace(referer)
+	if referer == "" {
+		return ""
+	}
+	if !strings.Contains(referer, "://") {
+		referer = "http://" + referer
+	}
+
+	u, err := url.Parse(referer)
+	if err != nil {
+		return ""
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, prefix := range []string{"www.", "m.", "mobile."} {
+		host = strings.TrimPrefix(host, prefix)
+	}
+	return host
+}
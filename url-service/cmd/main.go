@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/urlshortener/shared/auth"
+	"github.com/urlshortener/shared/useragent"
 	"github.com/urlshortener/url-service/internal/handlers"
 	"github.com/urlshortener/url-service/internal/middleware"
 	"github.com/urlshortener/url-service/internal/models"
 	"github.com/urlshortener/url-service/internal/repository"
 	"github.com/urlshortener/url-service/internal/service"
+	"github.com/urlshortener/url-service/internal/worker"
 	"github.com/urlshortener/url-service/pkg/redis"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -36,18 +42,85 @@ func main() {
 	// Initialize Redis
 	redisClient := redis.NewRedisClient()
 
+	// Bots are recognised with the user-agent rules stats-service uses
+	// (bundled defaults unless UA_RULES_FILE is set)
+	uaRules, err := useragent.LoadRules(os.Getenv("UA_RULES_FILE"))
+	if err != nil {
+		log.Fatal("Failed to load user-agent rules:", err)
+	}
+	bots, err := useragent.NewParser(uaRules)
+	if err != nil {
+		log.Fatal("Failed to compile user-agent rules:", err)
+	}
+
 	// Initialize layers
 	urlRepo := repository.NewURLRepository(db)
-	urlService := service.NewURLService(urlRepo, redisClient)
-	urlHandler := handlers.NewURLHandler(urlService)
+	urlService := service.NewURLService(urlRepo, redisClient, bots)
+
+	// Announce expired links in background
+	expiryInterval, err := time.ParseDuration(os.Getenv("EXPIRY_CHECK_INTERVAL"))
+	if err != nil || expiryInterval <= 0 {
+		expiryInterval = time.Minute
+	}
+	go worker.NewExpiryNotifier(urlService, expiryInterval).Start(context.Background())
+
+	// Remove the links of accounts deleted in user-service
+	go worker.NewAccountListener(urlService, redisClient).Start(context.Background())
+
+	// Token validation, shared by all authenticated routes
+	userServiceURL := os.Getenv("USER_SERVICE_URL")
+	if userServiceURL == "" {
+		userServiceURL = "http://localhost:8081"
+	}
+	issuer := os.Getenv("JWT_ISSUER")
+	if issuer == "" {
+		issuer = "urlshortener"
+	}
+	authTimeout, _ := time.ParseDuration(os.Getenv("AUTH_TIMEOUT"))
+	authCacheTTL, _ := time.ParseDuration(os.Getenv("AUTH_CACHE_TTL"))
+	validator := auth.NewValidator(auth.Config{
+		UserServiceURL:  userServiceURL,
+		Issuer:          issuer,
+		Timeout:         authTimeout,
+		CacheTTL:        authCacheTTL,
+		CheckRevocation: os.Getenv("AUTH_CHECK_REVOCATION") != "false",
+	})
 
 	// Setup Gin
 	r := gin.Default()
 
+	// Client IPs (passed on to user-service for API key usage) are only taken
+	// from X-Forwarded-For when the request comes through one of these
+	// proxies (comma-separated IPs or CIDRs); by default from none
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+
+	// Visitor countries are only read from CF-IPCountry or X-Country-Code on
+	// requests coming from one of these edges (comma-separated IPs or CIDRs),
+	// directly or through the trusted proxies; by default from none
+	var edgeProxies []string
+	for _, edge := range strings.Split(os.Getenv("TRUSTED_EDGE_PROXIES"), ",") {
+		if edge = strings.TrimSpace(edge); edge != "" {
+			edgeProxies = append(edgeProxies, edge)
+		}
+	}
+	countries, err := handlers.NewCountryHeaders(trustedProxies, edgeProxies)
+	if err != nil {
+		log.Fatal("Invalid TRUSTED_EDGE_PROXIES:", err)
+	}
+	urlHandler := handlers.NewURLHandler(urlService, countries)
+
 	// CORS
 	r.Use(cors.New(cors.Config{
 		AllowAllOrigins:  true,
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Accept"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: false,
@@ -66,14 +139,15 @@ func main() {
 		api.GET("/info/:code", urlHandler.GetURL)
 
 		// Optional auth for creating URLs (works with or without auth)
-		api.POST("", middleware.OptionalAuthMiddleware(), urlHandler.CreateURL)
+		api.POST("", middleware.OptionalAuthMiddleware(validator), middleware.RequireScope(auth.ScopeLinksWrite), urlHandler.CreateURL)
 
 		// Protected routes
 		protected := api.Group("")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(validator))
 		{
-			protected.GET("", urlHandler.GetUserURLs)
-			protected.DELETE("/:id", urlHandler.DeleteURL)
+			protected.GET("", middleware.RequireScope(auth.ScopeLinksRead), urlHandler.GetUserURLs)
+			protected.PATCH("/:id", middleware.RequireScope(auth.ScopeLinksWrite), urlHandler.UpdateURL)
+			protected.DELETE("/:id", middleware.RequireScope(auth.ScopeLinksWrite), urlHandler.DeleteURL)
 		}
 	}
 
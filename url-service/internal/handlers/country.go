@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CountryHeaders reads the visitor's country from the headers a CDN or edge
+// proxy sets (CF-IPCountry, X-Country-Code). Anyone can send those headers,
+// so they are only believed on requests that came through one of the
+// trusted edges, possibly via the trusted proxies behind it.
+type CountryHeaders struct {
+	proxies []*net.IPNet
+	edges   []*net.IPNet
+}
+
+// NewCountryHeaders takes the trusted proxies and edges as IPs or CIDRs.
+// Without edges no country is ever read from headers.
+func NewCountryHeaders(proxies, edges []string) (*CountryHeaders, error) {
+	parsedProxies, err := parseNetworks(proxies)
+	if err != nil {
+		return nil, err
+	}
+	parsedEdges, err := parseNetworks(edges)
+	if err != nil {
+		return nil, err
+	}
+	return &CountryHeaders{proxies: parsedProxies, edges: parsedEdges}, nil
+}
+
+// Country returns the ISO country code of the request, or "" if it is
+// unknown or did not come through a trusted edge.
+func (h *CountryHeaders) Country(c *gin.Context) string {
+	if h == nil || len(h.edges) == 0 || !h.fromEdge(c) {
+		return ""
+	}
+	for _, header := range []string{"CF-IPCountry", "X-Country-Code"} {
+		country := strings.ToUpper(strings.TrimSpace(c.GetHeader(header)))
+		// XX and T1 are Cloudflare's unknown and Tor markers
+		if len(country) == 2 && country != "XX" && country != "T1" {
+			return country
+		}
+	}
+	return ""
+}
+
+// fromEdge walks back from the direct peer through X-Forwarded-For while
+// the hops are trusted proxies, and reports whether it reaches an edge.
+func (h *CountryHeaders) fromEdge(c *gin.Context) bool {
+	hops := []string{c.RemoteIP()}
+	forwarded := strings.Split(c.GetHeader("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hops = append(hops, strings.TrimSpace(forwarded[i]))
+	}
+
+	for _, hop := range hops {
+		ip := net.ParseIP(hop)
+		if ip == nil {
+			return false
+		}
+		if contains(h.edges, ip) {
+			return true
+		}
+		if !contains(h.proxies, ip) {
+			return false
+		}
+	}
+	return false
+}
+
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP or CIDR %q", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
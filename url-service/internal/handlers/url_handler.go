@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	neturl "net/url"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -11,11 +12,12 @@ import (
 )
 
 type URLHandler struct {
-	service *service.URLService
+	service   *service.URLService
+	countries *CountryHeaders
 }
 
-func NewURLHandler(service *service.URLService) *URLHandler {
-	return &URLHandler{service: service}
+func NewURLHandler(service *service.URLService, countries *CountryHeaders) *URLHandler {
+	return &URLHandler{service: service, countries: countries}
 }
 
 // CreateURL godoc
@@ -25,6 +27,8 @@ func NewURLHandler(service *service.URLService) *URLHandler {
 // @Produce json
 // @Param request body models.CreateURLRequest true "URL request"
 // @Success 201 {object} models.URLResponse
+// @Failure 401 {object} map[string]interface{} "Custom alias requested without signing in"
+// @Failure 403 {object} map[string]interface{} "Not allowed until the user verifies their email address"
 // @Router /api/urls [post]
 func (h *URLHandler) CreateURL(c *gin.Context) {
 	var req models.CreateURLRequest
@@ -38,6 +42,16 @@ func (h *URLHandler) CreateURL(c *gin.Context) {
 	if id, exists := c.Get("user_id"); exists {
 		uid := id.(uuid.UUID)
 		userID = &uid
+
+		if !c.GetBool("email_verified") {
+			if err := h.service.CheckUnverified(&req); err != nil {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
+		}
+	} else if err := h.service.CheckAnonymous(&req); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
 	}
 
 	response, err := h.service.CreateURL(&req, userID)
@@ -64,15 +78,39 @@ func (h *URLHandler) Redirect(c *gin.Context) {
 		return
 	}
 
+	info := models.ClickInfo{
+		UserAgent:  c.GetHeader("User-Agent"),
+		IP:         c.ClientIP(),
+		Referer:    c.GetHeader("Referer"),
+		Country:    h.countries.Country(c),
+		UTM:        utmParams(c.Request.URL, url.OriginalURL),
+		DoNotTrack: c.GetHeader("DNT") == "1" || c.GetHeader("Sec-GPC") == "1",
+	}
+
+	// The click ID ties later conversions back to this click
+	clickID := h.service.AssignClickID(url, &info)
+
 	// Record click asynchronously
-	go h.service.RecordClick(
-		code,
-		c.GetHeader("User-Agent"),
-		c.ClientIP(),
-		c.GetHeader("Referer"),
-	)
-
-	c.Redirect(http.StatusFound, url.OriginalURL)
+	go h.service.RecordClick(url, info)
+
+	c.Redirect(http.StatusFound, h.service.Destination(url, clickID))
+}
+
+// utmParams prefers UTM parameters on the short link request and falls back
+// to the ones baked into the destination URL.
+func utmParams(reqURL *neturl.URL, originalURL string) models.UTM {
+	query := reqURL.Query()
+	if query.Get("utm_source") == "" && query.Get("utm_medium") == "" {
+		if dest, err := neturl.Parse(originalURL); err == nil {
+			query = dest.Query()
+		}
+	}
+
+	return models.UTM{
+		Source:   query.Get("utm_source"),
+		Medium:   query.Get("utm_medium"),
+		Campaign: query.Get("utm_campaign"),
+	}
 }
 
 // GetURL godoc
@@ -92,11 +130,12 @@ func (h *URLHandler) GetURL(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"id":           url.ID,
-		"short_code":   url.ShortCode,
-		"original_url": url.OriginalURL,
-		"click_count":  url.ClickCount,
-		"created_at":   url.CreatedAt,
+		"id":                    url.ID,
+		"short_code":            url.ShortCode,
+		"original_url":          url.OriginalURL,
+		"click_count":           url.ClickCount,
+		"disable_click_storage": url.DisableClickStorage,
+		"created_at":            url.CreatedAt,
 	})
 }
 
@@ -155,6 +194,44 @@ func (h *URLHandler) GetAllURLs(c *gin.Context) {
 	})
 }
 
+// UpdateURL godoc
+// @Summary Update a URL's settings
+// @Tags urls
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "URL ID"
+// @Param request body models.UpdateURLRequest true "Settings to change"
+// @Success 200 {object} models.URLResponse
+// @Router /api/urls/{id} [patch]
+func (h *URLHandler) UpdateURL(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var req models.UpdateURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.service.UpdateURL(id, userID.(uuid.UUID), &req)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
 // DeleteURL godoc
 // @Summary Delete a URL
 // @Tags urls
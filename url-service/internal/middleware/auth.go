@@ -1,22 +1,16 @@
 package middleware
 
 import (
-	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
+	"github.com/urlshortener/shared/auth"
 )
 
-type ValidateResponse struct {
-	Valid  bool      `json:"valid"`
-	UserID uuid.UUID `json:"user_id"`
-	Email  string    `json:"email"`
-}
-
-func AuthMiddleware() gin.HandlerFunc {
+func AuthMiddleware(validator *auth.Validator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -32,51 +26,29 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Validate token with User Service
-		userServiceURL := os.Getenv("USER_SERVICE_URL")
-		if userServiceURL == "" {
-			userServiceURL = "http://localhost:8081"
-		}
-
-		req, _ := http.NewRequest("GET", userServiceURL+"/api/users/validate", nil)
-		req.Header.Set("Authorization", authHeader)
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to validate token"})
+		identity, err := validator.Validate(c.Request.Context(), tokenString, c.ClientIP())
+		if errors.Is(err, auth.ErrUnavailable) {
+			log.Printf("Auth: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "authentication temporarily unavailable"})
 			c.Abort()
 			return
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
-			c.Abort()
-			return
-		}
-
-		var validateResp ValidateResponse
-		if err := json.NewDecoder(resp.Body).Decode(&validateResp); err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to parse validation response"})
-			c.Abort()
-			return
-		}
-
-		if !validateResp.Valid {
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			c.Abort()
 			return
 		}
 
-		c.Set("user_id", validateResp.UserID)
-		c.Set("email", validateResp.Email)
+		setIdentity(c, identity)
 		c.Next()
 	}
 }
 
-// OptionalAuthMiddleware tries to authenticate but doesn't require it
-func OptionalAuthMiddleware() gin.HandlerFunc {
+// OptionalAuthMiddleware tries to authenticate but doesn't require it. An
+// invalid token is treated as anonymous; one that cannot be checked, or an
+// invalid API key, is rejected, so a link is not silently created without
+// owner.
+func OptionalAuthMiddleware(validator *auth.Validator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -90,38 +62,45 @@ func OptionalAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		userServiceURL := os.Getenv("USER_SERVICE_URL")
-		if userServiceURL == "" {
-			userServiceURL = "http://localhost:8081"
-		}
-
-		req, _ := http.NewRequest("GET", userServiceURL+"/api/users/validate", nil)
-		req.Header.Set("Authorization", authHeader)
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			c.Next()
+		identity, err := validator.Validate(c.Request.Context(), tokenString, c.ClientIP())
+		if errors.Is(err, auth.ErrUnavailable) {
+			log.Printf("Auth: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "authentication temporarily unavailable"})
+			c.Abort()
 			return
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			c.Next()
+		if err != nil && auth.IsAPIKey(tokenString) {
+			// A script with a bad key should fail, not create ownerless links
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid API key"})
+			c.Abort()
 			return
 		}
-
-		var validateResp ValidateResponse
-		if err := json.NewDecoder(resp.Body).Decode(&validateResp); err != nil {
-			c.Next()
-			return
+		if err == nil {
+			setIdentity(c, identity)
 		}
 
-		if validateResp.Valid {
-			c.Set("user_id", validateResp.UserID)
-			c.Set("email", validateResp.Email)
-		}
+		c.Next()
+	}
+}
 
+// RequireScope rejects API keys that were not granted scope. Access tokens
+// and anonymous requests pass; it must run after the auth middleware.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value, exists := c.Get("identity"); exists {
+			if !value.(*auth.Identity).Allows(scope) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + scope + " scope"})
+				c.Abort()
+				return
+			}
+		}
 		c.Next()
 	}
 }
+
+func setIdentity(c *gin.Context, identity *auth.Identity) {
+	c.Set("identity", identity)
+	c.Set("user_id", identity.UserID)
+	c.Set("email", identity.Email)
+	c.Set("email_verified", identity.EmailVerified)
+}
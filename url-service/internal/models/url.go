@@ -8,15 +8,19 @@ import (
 )
 
 type URL struct {
-	ID          uuid.UUID      `gorm:"type:uuid;primary_key" json:"id"`
-	ShortCode   string         `gorm:"uniqueIndex;not null;size:10" json:"short_code"`
-	OriginalURL string         `gorm:"not null" json:"original_url"`
-	UserID      *uuid.UUID     `gorm:"type:uuid;index" json:"user_id,omitempty"`
-	ClickCount  int64          `gorm:"default:0" json:"click_count"`
-	ExpiresAt   *time.Time     `json:"expires_at,omitempty"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+	ID                  uuid.UUID      `gorm:"type:uuid;primary_key" json:"id"`
+	ShortCode           string         `gorm:"uniqueIndex;not null;size:10" json:"short_code"`
+	OriginalURL         string         `gorm:"not null" json:"original_url"`
+	UserID              *uuid.UUID     `gorm:"type:uuid;index" json:"user_id,omitempty"`
+	ClickCount          int64          `gorm:"default:0" json:"click_count"`
+	ExpiresAt           *time.Time     `json:"expires_at,omitempty"`
+	DisableClickStorage bool           `gorm:"default:false" json:"disable_click_storage"`
+	AppendClickID       bool           `gorm:"default:false" json:"append_click_id"`
+	Tags                []string       `gorm:"serializer:json" json:"tags"`
+	ExpiryNotified      bool           `gorm:"default:false" json:"-"`
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+	DeletedAt           gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 func (u *URL) BeforeCreate(tx *gorm.DB) error {
@@ -27,25 +31,103 @@ func (u *URL) BeforeCreate(tx *gorm.DB) error {
 }
 
 type CreateURLRequest struct {
-	OriginalURL string `json:"original_url" binding:"required,url"`
-	CustomCode  string `json:"custom_code,omitempty"`
-	ExpiresIn   int    `json:"expires_in,omitempty"` // hours
+	OriginalURL         string `json:"original_url" binding:"required,url"`
+	CustomCode          string `json:"custom_code,omitempty"`
+	ExpiresIn           int    `json:"expires_in,omitempty"` // hours
+	DisableClickStorage bool   `json:"disable_click_storage,omitempty"`
+	AppendClickID       bool   `json:"append_click_id,omitempty"`
+	// Labels to group links by in stats, e.g. a campaign
+	Tags []string `json:"tags,omitempty" binding:"omitempty,max=10,dive,max=32"`
+}
+
+type UpdateURLRequest struct {
+	DisableClickStorage *bool `json:"disable_click_storage,omitempty"`
+	AppendClickID       *bool `json:"append_click_id,omitempty"`
+	// Replaces the link's tags; an empty list removes them
+	Tags *[]string `json:"tags,omitempty" binding:"omitempty,max=10,dive,max=32"`
 }
 
 type URLResponse struct {
-	ID          uuid.UUID  `json:"id"`
-	ShortCode   string     `json:"short_code"`
-	ShortURL    string     `json:"short_url"`
-	OriginalURL string     `json:"original_url"`
-	ClickCount  int64      `json:"click_count"`
-	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
-	CreatedAt   time.Time  `json:"created_at"`
+	ID                  uuid.UUID  `json:"id"`
+	ShortCode           string     `json:"short_code"`
+	ShortURL            string     `json:"short_url"`
+	OriginalURL         string     `json:"original_url"`
+	ClickCount          int64      `json:"click_count"`
+	ExpiresAt           *time.Time `json:"expires_at,omitempty"`
+	DisableClickStorage bool       `json:"disable_click_storage"`
+	AppendClickID       bool       `json:"append_click_id"`
+	Tags                []string   `json:"tags"`
+	CreatedAt           time.Time  `json:"created_at"`
 }
 
 type ClickEvent struct {
-	ShortCode string    `json:"short_code"`
-	UserAgent string    `json:"user_agent"`
-	IP        string    `json:"ip"`
-	Referer   string    `json:"referer"`
+	ClickID   *uuid.UUID `json:"click_id,omitempty"`
+	ShortCode string     `json:"short_code"`
+	UserID    *uuid.UUID `json:"user_id,omitempty"`
+	UserAgent string     `json:"user_agent"`
+	IP        string     `json:"ip"`
+	Referer   string     `json:"referer"`
+	Country   string     `json:"country,omitempty"`
+	IsBot     bool       `json:"is_bot"`
+	Timestamp time.Time  `json:"timestamp"`
+	UTM
+
+	// DoNotTrack is set when the visitor sent DNT or Sec-GPC; IP and full
+	// referrer are then already stripped.
+	DoNotTrack bool `json:"do_not_track,omitempty"`
+	// SkipClickStorage asks stats-service to count the click in aggregates
+	// only, without storing it individually.
+	SkipClickStorage bool `json:"skip_click_storage,omitempty"`
+}
+
+// Link lifecycle event types, published on the "url:events" channel.
+const (
+	LinkCreated = "link.created"
+	LinkUpdated = "link.updated"
+	LinkDeleted = "link.deleted"
+	LinkExpired = "link.expired"
+)
+
+// LinkEvent announces a change to a link to other services.
+type LinkEvent struct {
+	Type      string      `json:"type"`
+	UserID    *uuid.UUID  `json:"user_id,omitempty"`
+	Link      URLResponse `json:"link"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// UserDeleted is published by user-service on the "user:events" channel
+// once an account is gone, and repeated until answered with
+// UserDeletionAcked on the "user:acks" channel.
+const (
+	UserDeleted       = "user.deleted"
+	UserDeletionAcked = "user.deletion_acked"
+)
+
+// UserEvent announces a change to an account, or acknowledges one.
+type UserEvent struct {
+	Type   string    `json:"type"`
+	UserID uuid.UUID `json:"user_id"`
+	// The acknowledging service
+	Service   string    `json:"service,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
 }
+
+// ClickInfo is what Redirect knows about a visitor.
+type ClickInfo struct {
+	ClickID    *uuid.UUID
+	UserAgent  string
+	IP         string
+	Referer    string
+	Country    string
+	UTM        UTM
+	DoNotTrack bool
+}
+
+// UTM holds the campaign parameters seen on a click, taken from the short
+// link request or, failing that, from the destination URL.
+type UTM struct {
+	Source   string `json:"utm_source,omitempty"`
+	Medium   string `json:"utm_medium,omitempty"`
+	Campaign string `json:"utm_campaign,omitempty"`
+}
@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/urlshortener/url-service/internal/models"
 	"gorm.io/gorm"
@@ -62,10 +64,59 @@ func (r *URLRepository) IncrementClickCount(code string) error {
 		UpdateColumn("click_count", gorm.Expr("click_count + ?", 1)).Error
 }
 
+// UpdateSettings stores the settings owners may change on a link. Other
+// columns, such as the click count kept up by redirects, are left alone, and
+// a link deleted meanwhile is not brought back.
+func (r *URLRepository) UpdateSettings(url *models.URL) error {
+	result := r.db.Model(url).
+		Select("disable_click_storage", "append_click_id", "tags").
+		Updates(url)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 func (r *URLRepository) Delete(id uuid.UUID, userID uuid.UUID) error {
 	return r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.URL{}).Error
 }
 
+// DeleteByUserID deletes all of a user's links.
+func (r *URLRepository) DeleteByUserID(userID uuid.UUID) (int64, error) {
+	result := r.db.Where("user_id = ?", userID).Delete(&models.URL{})
+	return result.RowsAffected, result.Error
+}
+
+// AnonymizeByUserID detaches a user's links from them; the links keep
+// redirecting.
+func (r *URLRepository) AnonymizeByUserID(userID uuid.UUID) (int64, error) {
+	result := r.db.Model(&models.URL{}).Where("user_id = ?", userID).UpdateColumn("user_id", nil)
+	return result.RowsAffected, result.Error
+}
+
+// FindExpiredUnnotified returns links that expired since the given time and
+// have not been announced as expired yet.
+func (r *URLRepository) FindExpiredUnnotified(since, now time.Time, limit int) ([]models.URL, error) {
+	var urls []models.URL
+	err := r.db.Where("expires_at > ? AND expires_at <= ? AND expiry_notified = ?", since, now, false).
+		Order("expires_at").
+		Limit(limit).
+		Find(&urls).Error
+	return urls, err
+}
+
+// MarkExpiryNotified flags a link as announced. It reports false if another
+// instance got there first.
+func (r *URLRepository) MarkExpiryNotified(id uuid.UUID) (bool, error) {
+	result := r.db.Model(&models.URL{}).
+		Where("id = ? AND expiry_notified = ?", id, false).
+		UpdateColumn("expiry_notified", true)
+	return result.RowsAffected > 0, result.Error
+}
+
 func (r *URLRepository) GetAll(limit, offset int) ([]models.URL, int64, error) {
 	var urls []models.URL
 	var total int64
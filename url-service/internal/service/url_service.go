@@ -5,23 +5,105 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
+	"log"
+	neturl "net/url"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/urlshortener/shared/clickid"
+	"github.com/urlshortener/shared/useragent"
 	"github.com/urlshortener/url-service/internal/models"
 	"github.com/urlshortener/url-service/internal/repository"
 	"github.com/urlshortener/url-service/pkg/redis"
 )
 
+// Policies for users who have not verified their email address yet
+// (UNVERIFIED_USER_POLICY)
+const (
+	// UnverifiedFull lets them do everything verified users can
+	UnverifiedFull = "full"
+	// UnverifiedLimited lets them create links, but not with custom codes
+	UnverifiedLimited = "limited"
+	// UnverifiedNone lets them create no links until they verify
+	UnverifiedNone = "none"
+)
+
+var ErrEmailNotVerified = errors.New("email address not verified")
+
 type URLService struct {
 	repo  *repository.URLRepository
 	redis *redis.RedisClient
+	bots  *useragent.Parser
+
+	// clickIDs signs the click IDs appended to destination URLs
+	// (CLICK_ID_SECRET); without it no click IDs are handed out
+	clickIDs *clickid.Signer
+
+	// honorPrivacySignals strips identifying details from clicks sent with
+	// DNT or Sec-GPC (HONOR_PRIVACY_SIGNALS=false turns this off)
+	honorPrivacySignals bool
+
+	unverifiedPolicy string
+
+	// anonymizeDeletedUsers keeps a deleted account's links working without
+	// an owner instead of deleting them (DELETED_USER_LINKS=anonymize)
+	anonymizeDeletedUsers bool
 }
 
-func NewURLService(repo *repository.URLRepository, redis *redis.RedisClient) *URLService {
-	return &URLService{repo: repo, redis: redis}
+func NewURLService(repo *repository.URLRepository, redis *redis.RedisClient, bots *useragent.Parser) *URLService {
+	unverifiedPolicy := os.Getenv("UNVERIFIED_USER_POLICY")
+	switch unverifiedPolicy {
+	case UnverifiedFull, UnverifiedLimited, UnverifiedNone:
+	case "":
+		unverifiedPolicy = UnverifiedLimited
+	default:
+		log.Printf("Unknown UNVERIFIED_USER_POLICY %q, using %q", unverifiedPolicy, UnverifiedLimited)
+		unverifiedPolicy = UnverifiedLimited
+	}
+
+	clickIDs := clickid.NewSigner(os.Getenv("CLICK_ID_SECRET"))
+	if clickIDs == nil {
+		log.Println("CLICK_ID_SECRET is not set, click IDs for conversion tracking are disabled")
+	}
+
+	return &URLService{
+		repo:                  repo,
+		redis:                 redis,
+		bots:                  bots,
+		clickIDs:              clickIDs,
+		honorPrivacySignals:   os.Getenv("HONOR_PRIVACY_SIGNALS") != "false",
+		unverifiedPolicy:      unverifiedPolicy,
+		anonymizeDeletedUsers: os.Getenv("DELETED_USER_LINKS") == "anonymize",
+	}
+}
+
+// CheckUnverified applies the unverified-user policy to a link a user who
+// has not verified their email address wants to create.
+func (s *URLService) CheckUnverified(req *models.CreateURLRequest) error {
+	switch s.unverifiedPolicy {
+	case UnverifiedFull:
+		return nil
+	case UnverifiedNone:
+		return fmt.Errorf("%w: verify it to create links", ErrEmailNotVerified)
+	default:
+		if req.CustomCode != "" {
+			return fmt.Errorf("%w: verify it to use custom aliases", ErrEmailNotVerified)
+		}
+		return nil
+	}
+}
+
+// CheckAnonymous applies the same limit to links created without signing in:
+// custom aliases need a verified account unless the policy is "full", or
+// signing out would be a way around it.
+func (s *URLService) CheckAnonymous(req *models.CreateURLRequest) error {
+	if s.unverifiedPolicy != UnverifiedFull && req.CustomCode != "" {
+		return errors.New("sign in with a verified account to use custom aliases")
+	}
+	return nil
 }
 
 func (s *URLService) CreateURL(req *models.CreateURLRequest, userID *uuid.UUID) (*models.URLResponse, error) {
@@ -43,9 +125,12 @@ func (s *URLService) CreateURL(req *models.CreateURLRequest, userID *uuid.UUID)
 	}
 
 	url := &models.URL{
-		ShortCode:   shortCode,
-		OriginalURL: req.OriginalURL,
-		UserID:      userID,
+		ShortCode:           shortCode,
+		OriginalURL:         req.OriginalURL,
+		UserID:              userID,
+		DisableClickStorage: req.DisableClickStorage,
+		AppendClickID:       req.AppendClickID,
+		Tags:                normalizeTags(req.Tags),
 	}
 
 	if req.ExpiresIn > 0 {
@@ -57,6 +142,7 @@ func (s *URLService) CreateURL(req *models.CreateURLRequest, userID *uuid.UUID)
 		return nil, err
 	}
 
+	s.publishLinkEvent(models.LinkCreated, url)
 	return s.toURLResponse(url), nil
 }
 
@@ -74,25 +160,80 @@ func (s *URLService) GetURL(shortCode string) (*models.URL, error) {
 	return url, nil
 }
 
-func (s *URLService) RecordClick(shortCode string, userAgent, ip, referer string) error {
-	// Increment click count in database
-	if err := s.repo.IncrementClickCount(shortCode); err != nil {
-		return err
+func (s *URLService) RecordClick(url *models.URL, info models.ClickInfo) error {
+	// Bots and link-preview crawlers are still published (flagged) so stats
+	// can report them on request, but they don't count as clicks
+	isBot := s.bots.IsBot(info.UserAgent)
+	if !isBot {
+		if err := s.repo.IncrementClickCount(url.ShortCode); err != nil {
+			return err
+		}
 	}
 
 	// Publish click event to Redis for Stats Service
 	event := models.ClickEvent{
-		ShortCode: shortCode,
-		UserAgent: userAgent,
-		IP:        ip,
-		Referer:   referer,
-		Timestamp: time.Now(),
+		ClickID:          info.ClickID,
+		ShortCode:        url.ShortCode,
+		UserID:           url.UserID,
+		UserAgent:        info.UserAgent,
+		IP:               info.IP,
+		Referer:          info.Referer,
+		Country:          info.Country,
+		IsBot:            isBot,
+		UTM:              info.UTM,
+		Timestamp:        time.Now(),
+		SkipClickStorage: url.DisableClickStorage,
+	}
+
+	// The IP never leaves this service and the referrer is cut down to its
+	// origin; the user-agent is kept so the click can still be classified
+	if info.DoNotTrack && s.honorPrivacySignals {
+		event.DoNotTrack = true
+		event.IP = ""
+		event.Referer = refererOrigin(info.Referer)
 	}
 
 	ctx := context.Background()
 	return s.redis.Publish(ctx, "url:click", event)
 }
 
+// AssignClickID gives the click an ID that conversions can later refer to.
+// Only links appending the ID to their destination hand one out, and never
+// to visitors asking not to be tracked or on links whose clicks are not
+// stored individually.
+func (s *URLService) AssignClickID(url *models.URL, info *models.ClickInfo) *uuid.UUID {
+	if s.clickIDs == nil || !url.AppendClickID || url.DisableClickStorage || (info.DoNotTrack && s.honorPrivacySignals) {
+		return nil
+	}
+	id := uuid.New()
+	info.ClickID = &id
+	return &id
+}
+
+// Destination returns where to redirect a click: the original URL, with the
+// signed click ID appended if the click was given one.
+func (s *URLService) Destination(url *models.URL, clickID *uuid.UUID) string {
+	if clickID == nil {
+		return url.OriginalURL
+	}
+	u, err := neturl.Parse(url.OriginalURL)
+	if err != nil {
+		return url.OriginalURL
+	}
+	query := u.Query()
+	query.Set(clickid.Param, s.clickIDs.Sign(*clickID))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func refererOrigin(referer string) string {
+	u, err := neturl.Parse(referer)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
 func (s *URLService) GetUserURLs(userID uuid.UUID, limit, offset int) ([]models.URLResponse, int64, error) {
 	urls, total, err := s.repo.FindByUserID(userID, limit, offset)
 	if err != nil {
@@ -107,8 +248,91 @@ func (s *URLService) GetUserURLs(userID uuid.UUID, limit, offset int) ([]models.
 	return responses, total, nil
 }
 
+func (s *URLService) UpdateURL(id uuid.UUID, userID uuid.UUID, req *models.UpdateURLRequest) (*models.URLResponse, error) {
+	url, err := s.repo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if url.UserID == nil || *url.UserID != userID {
+		return nil, errors.New("URL not found")
+	}
+
+	if req.DisableClickStorage != nil {
+		url.DisableClickStorage = *req.DisableClickStorage
+	}
+	if req.AppendClickID != nil {
+		url.AppendClickID = *req.AppendClickID
+	}
+	if req.Tags != nil {
+		url.Tags = normalizeTags(*req.Tags)
+	}
+
+	if err := s.repo.UpdateSettings(url); err != nil {
+		return nil, err
+	}
+
+	s.publishLinkEvent(models.LinkUpdated, url)
+	return s.toURLResponse(url), nil
+}
+
 func (s *URLService) DeleteURL(id uuid.UUID, userID uuid.UUID) error {
-	return s.repo.Delete(id, userID)
+	url, err := s.repo.FindByID(id)
+	if err != nil || url.UserID == nil || *url.UserID != userID {
+		return s.repo.Delete(id, userID)
+	}
+
+	if err := s.repo.Delete(id, userID); err != nil {
+		return err
+	}
+	s.publishLinkEvent(models.LinkDeleted, url)
+	return nil
+}
+
+// RemoveUserLinks deletes the links of a deleted account, or detaches them
+// from it when deleted users' links are kept.
+func (s *URLService) RemoveUserLinks(userID uuid.UUID) (int64, error) {
+	if s.anonymizeDeletedUsers {
+		return s.repo.AnonymizeByUserID(userID)
+	}
+	return s.repo.DeleteByUserID(userID)
+}
+
+// NotifyExpired announces links that expired within the last day. Older
+// expiries (e.g. from before this was deployed) are not announced.
+func (s *URLService) NotifyExpired() error {
+	now := time.Now()
+	for {
+		urls, err := s.repo.FindExpiredUnnotified(now.Add(-24*time.Hour), now, 100)
+		if err != nil {
+			return err
+		}
+		for i := range urls {
+			claimed, err := s.repo.MarkExpiryNotified(urls[i].ID)
+			if err != nil {
+				return err
+			}
+			if claimed {
+				s.publishLinkEvent(models.LinkExpired, &urls[i])
+			}
+		}
+		if len(urls) < 100 {
+			return nil
+		}
+	}
+}
+
+// publishLinkEvent tells other services about a change to a link. Failures
+// are logged only; the change itself has already been made.
+func (s *URLService) publishLinkEvent(eventType string, url *models.URL) {
+	event := models.LinkEvent{
+		Type:      eventType,
+		UserID:    url.UserID,
+		Link:      *s.toURLResponse(url),
+		Timestamp: time.Now(),
+	}
+	if err := s.redis.Publish(context.Background(), "url:events", event); err != nil {
+		log.Printf("Failed to publish %s for %s: %v", eventType, url.ShortCode, err)
+	}
 }
 
 func (s *URLService) GetAllURLs(limit, offset int) ([]models.URLResponse, int64, error) {
@@ -148,14 +372,36 @@ func (s *URLService) toURLResponse(url *models.URL) *models.URLResponse {
 	if baseURL == "" {
 		baseURL = "http://localhost:8082"
 	}
+	tags := url.Tags
+	if tags == nil {
+		tags = []string{}
+	}
 
 	return &models.URLResponse{
-		ID:          url.ID,
-		ShortCode:   url.ShortCode,
-		ShortURL:    baseURL + "/" + url.ShortCode,
-		OriginalURL: url.OriginalURL,
-		ClickCount:  url.ClickCount,
-		ExpiresAt:   url.ExpiresAt,
-		CreatedAt:   url.CreatedAt,
+		ID:                  url.ID,
+		ShortCode:           url.ShortCode,
+		ShortURL:            baseURL + "/" + url.ShortCode,
+		OriginalURL:         url.OriginalURL,
+		ClickCount:          url.ClickCount,
+		ExpiresAt:           url.ExpiresAt,
+		DisableClickStorage: url.DisableClickStorage,
+		AppendClickID:       url.AppendClickID,
+		Tags:                tags,
+		CreatedAt:           url.CreatedAt,
+	}
+}
+
+// normalizeTags lowercases and trims tags, dropping empty and repeated ones.
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
 	}
+	return normalized
 }
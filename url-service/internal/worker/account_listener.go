@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/urlshortener/url-service/internal/models"
+	"github.com/urlshortener/url-service/internal/service"
+	"github.com/urlshortener/url-service/pkg/redis"
+)
+
+// AccountListener removes the links of accounts deleted in user-service and
+// acknowledges each deletion once done. user-service repeats deletions until
+// they are acknowledged, so they may arrive more than once.
+type AccountListener struct {
+	service *service.URLService
+	redis   *redis.RedisClient
+}
+
+func NewAccountListener(service *service.URLService, redis *redis.RedisClient) *AccountListener {
+	return &AccountListener{
+		service: service,
+		redis:   redis,
+	}
+}
+
+func (l *AccountListener) Start(ctx context.Context) {
+	pubsub := l.redis.Subscribe(ctx, "user:events")
+	defer pubsub.Close()
+
+	log.Println("Account Listener: Listening for account events...")
+
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Account Listener: Error receiving message: %v", err)
+			continue
+		}
+
+		var event models.UserEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+			log.Printf("Account Listener: Error unmarshaling event: %v", err)
+			continue
+		}
+		if event.Type != models.UserDeleted {
+			continue
+		}
+
+		removed, err := l.service.RemoveUserLinks(event.UserID)
+		if err != nil {
+			log.Printf("Account Listener: Error removing links of user %s: %v", event.UserID, err)
+			continue
+		}
+		log.Printf("Account Listener: Removed %d links of deleted user %s", removed, event.UserID)
+
+		ack := models.UserEvent{Type: models.UserDeletionAcked, UserID: event.UserID, Service: "url-service", Timestamp: time.Now()}
+		if err := l.redis.Publish(ctx, "user:acks", ack); err != nil {
+			log.Printf("Account Listener: Error acknowledging deletion of user %s: %v", event.UserID, err)
+		}
+	}
+}
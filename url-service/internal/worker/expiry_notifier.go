@@ -0,0 +1,41 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/urlshortener/url-service/internal/service"
+)
+
+// ExpiryNotifier periodically publishes link.expired events for links whose
+// expiry has passed.
+type ExpiryNotifier struct {
+	service  *service.URLService
+	interval time.Duration
+}
+
+func NewExpiryNotifier(service *service.URLService, interval time.Duration) *ExpiryNotifier {
+	return &ExpiryNotifier{
+		service:  service,
+		interval: interval,
+	}
+}
+
+func (n *ExpiryNotifier) Start(ctx context.Context) {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	log.Printf("Expiry Notifier: Running every %s", n.interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := n.service.NotifyExpired(); err != nil {
+				log.Printf("Expiry Notifier: Error announcing expired links: %v", err)
+			}
+		}
+	}
+}
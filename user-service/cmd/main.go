@@ -1,24 +1,35 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/urlshortener/shared/mailer"
 	"github.com/urlshortener/user-service/internal/handlers"
 	"github.com/urlshortener/user-service/internal/middleware"
 	"github.com/urlshortener/user-service/internal/models"
 	"github.com/urlshortener/user-service/internal/repository"
 	"github.com/urlshortener/user-service/internal/service"
+	"github.com/urlshortener/user-service/pkg/attempts"
 	"github.com/urlshortener/user-service/pkg/jwt"
+	"github.com/urlshortener/user-service/pkg/oidc"
+	"github.com/urlshortener/user-service/pkg/redis"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 func main() {
-	// Initialize JWT
-	jwt.Init()
+	// Initialize JWT signing keys
+	if err := jwt.Init(); err != nil {
+		log.Fatal(err)
+	}
 
 	// Database connection
 	dsn := os.Getenv("DATABASE_URL")
@@ -32,22 +43,117 @@ func main() {
 	}
 
 	// Auto migrate
-	if err := db.AutoMigrate(&models.User{}); err != nil {
+	verificationAdded := !db.Migrator().HasColumn(&models.User{}, "email_verified_at")
+	if err := db.AutoMigrate(&models.User{}, &models.Session{}, &models.RefreshToken{}, &models.PasswordResetToken{}, &models.EmailVerificationToken{},
+		&models.TOTPFactor{}, &models.RecoveryCode{}, &models.MFAChallenge{},
+		&models.ExternalIdentity{}, &models.OIDCLoginState{}, &models.OIDCLoginCode{},
+		&models.APIKey{}, &models.AccountDeletion{}); err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
 	// Initialize layers
 	userRepo := repository.NewUserRepository(db)
-	userService := service.NewUserService(userRepo)
-	userHandler := handlers.NewUserHandler(userService)
+	if verificationAdded {
+		// Accounts from before email verification keep working as before
+		if err := userRepo.MarkExistingUsersVerified(); err != nil {
+			log.Fatal("Failed to mark existing users verified:", err)
+		}
+	}
+
+	// Sessions: short-lived access tokens plus rotating refresh tokens
+	refreshTTL, _ := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL"))
+	tokenService := service.NewTokenService(userRepo, refreshTTL)
+	jwt.SetRevocationCheck(tokenService.CheckSession)
+
+	// Account emails: address verification and password reset links
+	mailSender := newMailSender()
+	dashboardURL := os.Getenv("DASHBOARD_URL")
+	if dashboardURL == "" {
+		dashboardURL = "http://localhost:3000"
+	}
+
+	// Account events for url-service and stats-service, and failed login
+	// counts shared between instances
+	redisClient := redis.NewRedisClient()
+
+	verificationTTL, _ := time.ParseDuration(os.Getenv("EMAIL_VERIFICATION_TTL"))
+	verificationService := service.NewVerificationService(userRepo, mailSender, redisClient, dashboardURL, verificationTTL)
+	verificationHandler := handlers.NewVerificationHandler(verificationService)
+
+	resetTTL, _ := time.ParseDuration(os.Getenv("PASSWORD_RESET_TTL"))
+	passwordService := service.NewPasswordService(userRepo, tokenService, mailSender, dashboardURL, resetTTL)
+	passwordHandler := handlers.NewPasswordHandler(passwordService)
+
+	// Brute-force protection: failed logins are counted in Redis, or in
+	// memory while it is unreachable
+	lockoutThreshold, _ := strconv.Atoi(os.Getenv("LOGIN_LOCKOUT_THRESHOLD"))
+	lockoutDuration, _ := time.ParseDuration(os.Getenv("LOGIN_LOCKOUT_DURATION"))
+	ipThreshold, _ := strconv.Atoi(os.Getenv("LOGIN_IP_THRESHOLD"))
+	loginGuard := service.NewLoginGuard(attempts.WithFallback(redisClient, attempts.NewMemoryStore()), mailSender, dashboardURL, service.LoginGuardConfig{
+		LockoutThreshold: lockoutThreshold,
+		LockoutDuration:  lockoutDuration,
+		IPThreshold:      ipThreshold,
+	})
+
+	// Two-factor authentication with authenticator apps
+	mfaService := newMFAService(userRepo, tokenService, loginGuard)
+	mfaHandler := handlers.NewMFAHandler(mfaService)
+
+	// Single sign-on with OpenID Connect providers
+	oidcService := service.NewOIDCService(userRepo, tokenService, mfaService, dashboardURL, loadOIDCProviders())
+	oidcHandler := handlers.NewOIDCHandler(oidcService)
+
+	go purgeExpiredTokens(map[string]func() error{
+		"refresh tokens":              tokenService.PurgeExpired,
+		"password reset tokens":       passwordService.PurgeExpired,
+		"email verification tokens":   verificationService.PurgeExpired,
+		"two-factor login challenges": mfaService.PurgeExpired,
+		"single sign-on logins":       oidcService.PurgeExpired,
+	})
+
+	// Deleted accounts are announced until every service listed in
+	// ACCOUNT_DELETION_SERVICES has acknowledged dropping their data
+	deletionServices := []string{"url-service", "stats-service"}
+	if env := os.Getenv("ACCOUNT_DELETION_SERVICES"); env != "" {
+		deletionServices = nil
+		for _, name := range strings.Split(env, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				deletionServices = append(deletionServices, name)
+			}
+		}
+	}
+	deletionRelay := service.NewDeletionRelay(userRepo, redisClient, deletionServices)
+	go deletionRelay.Start(context.Background(), 30*time.Second)
+
+	userService := service.NewUserService(userRepo, tokenService, verificationService, mfaService, loginGuard, deletionRelay)
+	// Long-lived keys for scripts, accepted by url-service and stats-service
+	apiKeyService := service.NewAPIKeyService(userRepo)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService)
+
+	userHandler := handlers.NewUserHandler(userService, apiKeyService)
 
 	// Setup Gin
 	r := gin.Default()
 
+	// Client IPs (used for login throttling and session details) are only
+	// taken from X-Forwarded-For when the request comes through one of these
+	// proxies (comma-separated IPs or CIDRs); by default from none. List
+	// url-service and stats-service too, which pass on their clients' IPs
+	// when validating API keys
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+
 	// CORS
 	r.Use(cors.New(cors.Config{
 		AllowAllOrigins:  true,
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Accept"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: false,
@@ -55,11 +161,21 @@ func main() {
 
 	// Routes
 	r.GET("/health", userHandler.Health)
+	r.GET("/.well-known/jwks.json", userHandler.JWKS)
 
 	api := r.Group("/api/users")
 	{
 		api.POST("/register", userHandler.Register)
 		api.POST("/login", userHandler.Login)
+		api.POST("/login/mfa", mfaHandler.LoginMFA)
+		api.GET("/oidc/providers", oidcHandler.Providers)
+		api.GET("/oidc/:provider/login", oidcHandler.Login)
+		api.GET("/oidc/:provider/callback", oidcHandler.Callback)
+		api.POST("/oidc/token", oidcHandler.Token)
+		api.POST("/refresh", userHandler.Refresh)
+		api.POST("/password/forgot", passwordHandler.ForgotPassword)
+		api.POST("/password/reset", passwordHandler.ResetPassword)
+		api.POST("/verify-email", verificationHandler.VerifyEmail)
 		api.GET("/validate", userHandler.ValidateToken)
 		api.GET("/:id", userHandler.GetUser)
 
@@ -68,6 +184,20 @@ func main() {
 		protected.Use(middleware.AuthMiddleware())
 		{
 			protected.GET("/profile", userHandler.GetProfile)
+			protected.PATCH("/profile", userHandler.UpdateProfile)
+			protected.DELETE("/profile", userHandler.DeleteAccount)
+			protected.PUT("/password", userHandler.ChangePassword)
+			protected.POST("/logout", userHandler.Logout)
+			protected.POST("/verify-email/resend", verificationHandler.ResendVerification)
+			protected.GET("/mfa", mfaHandler.Status)
+			protected.POST("/mfa/totp", mfaHandler.SetupTOTP)
+			protected.POST("/mfa/totp/confirm", mfaHandler.ConfirmTOTP)
+			protected.DELETE("/mfa/totp", mfaHandler.DisableTOTP)
+			protected.POST("/mfa/recovery-codes", mfaHandler.RegenerateRecoveryCodes)
+			protected.GET("/oidc/identities", oidcHandler.Identities)
+			protected.GET("/api-keys", apiKeyHandler.ListAPIKeys)
+			protected.POST("/api-keys", apiKeyHandler.CreateAPIKey)
+			protected.DELETE("/api-keys/:id", apiKeyHandler.RevokeAPIKey)
 		}
 	}
 
@@ -82,3 +212,100 @@ func main() {
 		log.Fatal("Failed to start server:", err)
 	}
 }
+
+// purgeExpiredTokens hourly deletes expired refresh, password reset and
+// email verification tokens, two-factor login challenges and single sign-on
+// login states.
+func purgeExpiredTokens(purges map[string]func() error) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		for name, purge := range purges {
+			if err := purge(); err != nil {
+				log.Printf("Failed to purge expired %s: %v", name, err)
+			}
+		}
+	}
+}
+
+// newMFAService encrypts TOTP secrets with MFA_ENCRYPTION_KEY (base64, 32
+// bytes) when set. Without it secrets are stored in plain text.
+func newMFAService(repo *repository.UserRepository, tokens *service.TokenService, guard *service.LoginGuard) *service.MFAService {
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = "LinkShort"
+	}
+
+	var key []byte
+	if encoded := os.Getenv("MFA_ENCRYPTION_KEY"); encoded != "" {
+		var err error
+		key, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			log.Fatal("MFA_ENCRYPTION_KEY must be 32 bytes, base64 encoded")
+		}
+	} else {
+		log.Println("MFA_ENCRYPTION_KEY not set, TOTP secrets are stored unencrypted")
+	}
+
+	mfaService, err := service.NewMFAService(repo, tokens, guard, issuer, key)
+	if err != nil {
+		log.Fatal("Failed to configure two-factor authentication:", err)
+	}
+	return mfaService
+}
+
+// loadOIDCProviders reads the providers users can log in with from the JSON
+// file at OIDC_CONFIG_FILE. Without it only password login is offered.
+func loadOIDCProviders() []*oidc.Provider {
+	path := os.Getenv("OIDC_CONFIG_FILE")
+	if path == "" {
+		return nil
+	}
+
+	configs, err := oidc.LoadConfig(path)
+	if err != nil {
+		log.Fatal("Failed to load OIDC_CONFIG_FILE:", err)
+	}
+	providers := make([]*oidc.Provider, 0, len(configs))
+	for _, cfg := range configs {
+		providers = append(providers, oidc.NewProvider(cfg))
+		log.Printf("Single sign-on enabled with %s (%s)", cfg.Name, cfg.Issuer)
+	}
+	return providers
+}
+
+// newMailSender sends over SMTP when SMTP_HOST is set, otherwise writes mail
+// to MAIL_DIR, or to the log when that is not set either.
+func newMailSender() mailer.Sender {
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "URL Shortener <accounts@localhost>"
+	}
+
+	if smtpHost := os.Getenv("SMTP_HOST"); smtpHost != "" {
+		smtpPort, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		sender, err := mailer.New(mailer.Config{
+			Host:     smtpHost,
+			Port:     smtpPort,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		})
+		if err != nil {
+			log.Fatal("Failed to configure SMTP:", err)
+		}
+		return sender
+	}
+
+	if dir := os.Getenv("MAIL_DIR"); dir != "" {
+		sender, err := mailer.NewFileSender(dir, from)
+		if err != nil {
+			log.Fatal("Failed to configure MAIL_DIR:", err)
+		}
+		log.Printf("SMTP_HOST not set, writing emails to %s", dir)
+		return sender
+	}
+
+	log.Println("SMTP_HOST not set, emails are written to the log")
+	return mailer.LogSender{}
+}
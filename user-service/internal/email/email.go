@@ -0,0 +1,54 @@
+// Package email renders the account emails user-service sends.
+package email
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+
+	"github.com/urlshortener/shared/mailer"
+)
+
+//go:embed templates
+var files embed.FS
+
+var (
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(files, "templates/*.html"))
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(files, "templates/*.txt"))
+)
+
+// LinkData fills emails built around a single expiring link.
+type LinkData struct {
+	Name      string
+	Link      string
+	ExpiresIn string
+}
+
+// PasswordReset builds the email carrying a password reset link.
+func PasswordReset(to string, data LinkData) (mailer.Message, error) {
+	return build(to, "Reset your password", "password_reset", data)
+}
+
+// EmailVerification builds the email asking the user to confirm their
+// address.
+func EmailVerification(to string, data LinkData) (mailer.Message, error) {
+	return build(to, "Confirm your email address", "email_verification", data)
+}
+
+// AccountLocked tells the user logins were paused after repeated failed
+// attempts; Link points to the dashboard to reset the password from.
+func AccountLocked(to string, data LinkData) (mailer.Message, error) {
+	return build(to, "Too many failed login attempts", "account_locked", data)
+}
+
+func build(to, subject, name string, data interface{}) (mailer.Message, error) {
+	var h, t bytes.Buffer
+	if err := htmlTemplates.ExecuteTemplate(&h, name+".html", data); err != nil {
+		return mailer.Message{}, err
+	}
+	if err := textTemplates.ExecuteTemplate(&t, name+".txt", data); err != nil {
+		return mailer.Message{}, err
+	}
+	return mailer.Message{To: to, Subject: subject, HTML: h.String(), Text: t.String()}, nil
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/urlshortener/user-service/internal/models"
+	"github.com/urlshortener/user-service/internal/service"
+)
+
+type MFAHandler struct {
+	service *service.MFAService
+}
+
+func NewMFAHandler(service *service.MFAService) *MFAHandler {
+	return &MFAHandler{service: service}
+}
+
+// LoginMFA godoc
+// @Summary Complete a login with a TOTP or recovery code
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Param request body models.MFALoginRequest true "Challenge token from /login and a code"
+// @Success 200 {object} models.AuthResponse
+// @Failure 401 {object} map[string]interface{}
+// @Router /api/users/login/mfa [post]
+func (h *MFAHandler) LoginMFA(c *gin.Context) {
+	var req models.MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.service.CompleteLogin(&req, clientInfo(c))
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Status godoc
+// @Summary Two-factor authentication status
+// @Tags mfa
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.MFAStatus
+// @Router /api/users/mfa [get]
+func (h *MFAHandler) Status(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	status, err := h.service.Status(userID.(uuid.UUID))
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// SetupTOTP godoc
+// @Summary Start enrolling an authenticator app
+// @Description Returns the secret and an otpauth:// URI to show as a QR code; confirm with a code from the app
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.PasswordConfirmRequest true "Current password"
+// @Success 200 {object} models.TOTPSetupResponse
+// @Failure 403 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /api/users/mfa/totp [post]
+func (h *MFAHandler) SetupTOTP(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req models.PasswordConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	setup, err := h.service.Setup(userID.(uuid.UUID), req.Password)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, setup)
+}
+
+// ConfirmTOTP godoc
+// @Summary Turn two-factor authentication on with a code from the app
+// @Description Returns one-time recovery codes, which are not shown again
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.TOTPConfirmRequest true "Code from the authenticator app"
+// @Success 200 {object} models.RecoveryCodesResponse
+// @Failure 400 {object} map[string]interface{}
+// @Router /api/users/mfa/totp/confirm [post]
+func (h *MFAHandler) ConfirmTOTP(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req models.TOTPConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.service.Confirm(userID.(uuid.UUID), req.Code)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, codes)
+}
+
+// DisableTOTP godoc
+// @Summary Turn two-factor authentication off
+// @Tags mfa
+// @Accept json
+// @Security BearerAuth
+// @Param request body models.PasswordConfirmRequest true "Current password"
+// @Success 204
+// @Failure 403 {object} map[string]interface{}
+// @Router /api/users/mfa/totp [delete]
+func (h *MFAHandler) DisableTOTP(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req models.PasswordConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.Disable(userID.(uuid.UUID), req.Password); err != nil {
+		h.fail(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Replace all recovery codes
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.PasswordConfirmRequest true "Current password"
+// @Success 200 {object} models.RecoveryCodesResponse
+// @Failure 403 {object} map[string]interface{}
+// @Router /api/users/mfa/recovery-codes [post]
+func (h *MFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req models.PasswordConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(userID.(uuid.UUID), req.Password)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, codes)
+}
+
+func (h *MFAHandler) fail(c *gin.Context, err error) {
+	if respondThrottled(c, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, service.ErrInvalidMFAChallenge), errors.Is(err, service.ErrInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrWrongPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrMFANotEnabled), errors.Is(err, service.ErrMFANotStarted):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "two-factor authentication failed"})
+	}
+}
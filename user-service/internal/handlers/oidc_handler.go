@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/urlshortener/user-service/internal/models"
+	"github.com/urlshortener/user-service/internal/service"
+)
+
+// Binds a provider login to the browser that started it, so a callback
+// cannot be replayed into someone else's browser
+const oidcStateCookie = "oidc_state"
+
+type OIDCHandler struct {
+	service *service.OIDCService
+}
+
+func NewOIDCHandler(service *service.OIDCService) *OIDCHandler {
+	return &OIDCHandler{service: service}
+}
+
+// Providers godoc
+// @Summary List the identity providers users can log in with
+// @Tags oidc
+// @Produce json
+// @Success 200 {array} models.OIDCProvider
+// @Router /api/users/oidc/providers [get]
+func (h *OIDCHandler) Providers(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.Providers())
+}
+
+// Login godoc
+// @Summary Start logging in with an identity provider
+// @Description Redirects the browser to the provider, which sends it back to the callback
+// @Tags oidc
+// @Param provider path string true "Provider name"
+// @Success 302
+// @Failure 404 {object} map[string]interface{}
+// @Router /api/users/oidc/{provider}/login [get]
+func (h *OIDCHandler) Login(c *gin.Context) {
+	authURL, state, err := h.service.Begin(c.Request.Context(), c.Param("provider"))
+	if errors.Is(err, service.ErrUnknownProvider) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start %s login: %v", c.Param("provider"), err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "login provider unavailable"})
+		return
+	}
+
+	h.setStateCookie(c, state, 600)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback godoc
+// @Summary Finish logging in with an identity provider
+// @Description Called by the provider. Redirects to the dashboard with ?oidc_code= to trade at /api/users/oidc/token, or with ?oidc_error=
+// @Tags oidc
+// @Param provider path string true "Provider name"
+// @Param code query string false "Authorization code"
+// @Param state query string true "State from the login redirect"
+// @Success 302
+// @Router /api/users/oidc/{provider}/callback [get]
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	h.setStateCookie(c, "", -1)
+
+	if c.Query("error") != "" {
+		h.fail(c, service.ErrOIDCRefused)
+		return
+	}
+	if state == "" || cookie != state {
+		h.fail(c, service.ErrInvalidOIDCState)
+		return
+	}
+
+	code, err := h.service.Callback(c.Request.Context(), c.Param("provider"), state, c.Query("code"))
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, h.service.FrontendURL(url.Values{"oidc_code": {code}}))
+}
+
+// Token godoc
+// @Summary Trade a login code from the callback for tokens
+// @Description Users with two-factor authentication get an MFAChallengeResponse instead of tokens; complete it at /api/users/login/mfa
+// @Tags oidc
+// @Accept json
+// @Produce json
+// @Param request body models.OIDCTokenRequest true "Login code"
+// @Success 200 {object} models.AuthResponse
+// @Failure 401 {object} map[string]interface{}
+// @Router /api/users/oidc/token [post]
+func (h *OIDCHandler) Token(c *gin.Context) {
+	var req models.OIDCTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, challenge, err := h.service.CompleteLogin(req.Code, clientInfo(c))
+	if errors.Is(err, service.ErrInvalidOIDCLoginCode) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed"})
+		return
+	}
+	if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Identities godoc
+// @Summary List the provider accounts linked to the current user
+// @Tags oidc
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.ExternalIdentity
+// @Router /api/users/oidc/identities [get]
+func (h *OIDCHandler) Identities(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	identities, err := h.service.Identities(userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list linked accounts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+// fail sends the browser back to the dashboard with a message it can show.
+// Unexpected errors are logged and not passed on.
+func (h *OIDCHandler) fail(c *gin.Context, err error) {
+	message := err.Error()
+	switch {
+	case errors.Is(err, service.ErrUnknownProvider),
+		errors.Is(err, service.ErrInvalidOIDCState),
+		errors.Is(err, service.ErrOIDCEmailNotVerified),
+		errors.Is(err, service.ErrOIDCAccountUnverified),
+		errors.Is(err, service.ErrOIDCRefused):
+	default:
+		log.Printf("Failed to complete %s login: %v", c.Param("provider"), err)
+		message = "login failed, please try again"
+	}
+	c.Redirect(http.StatusFound, h.service.FrontendURL(url.Values{"oidc_error": {message}}))
+}
+
+func (h *OIDCHandler) setStateCookie(c *gin.Context, value string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	// Lax, so the cookie comes back on the provider's redirect
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, "/api/users/oidc", "", secure, true)
+}
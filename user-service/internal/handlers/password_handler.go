@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/urlshortener/user-service/internal/models"
+	"github.com/urlshortener/user-service/internal/service"
+)
+
+type PasswordHandler struct {
+	service *service.PasswordService
+}
+
+func NewPasswordHandler(service *service.PasswordService) *PasswordHandler {
+	return &PasswordHandler{service: service}
+}
+
+// ForgotPassword godoc
+// @Summary Email a password reset link
+// @Description Always answers 202, whether or not the address is registered
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.ForgotPasswordRequest true "Account email"
+// @Success 202 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Router /api/users/password/forgot [post]
+func (h *PasswordHandler) ForgotPassword(c *gin.Context) {
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.service.RequestReset(req.Email, c.ClientIP())
+	if errors.Is(err, service.ErrTooManyResetRequests) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to request password reset"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "if the address is registered, a reset link is on its way"})
+}
+
+// ResetPassword godoc
+// @Summary Set a new password with a reset token
+// @Description Signs the user out of every session
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.ResetPasswordRequest true "Reset token and new password"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /api/users/password/reset [post]
+func (h *PasswordHandler) ResetPassword(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.service.ResetPassword(req.Token, req.Password)
+	if errors.Is(err, service.ErrInvalidResetToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "password updated, please log in again"})
+}
@@ -1,21 +1,26 @@
 package handlers
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/urlshortener/user-service/internal/models"
 	"github.com/urlshortener/user-service/internal/service"
+	"github.com/urlshortener/user-service/pkg/jwt"
 )
 
 type UserHandler struct {
 	service *service.UserService
+	apiKeys *service.APIKeyService
 }
 
-func NewUserHandler(service *service.UserService) *UserHandler {
-	return &UserHandler{service: service}
+func NewUserHandler(service *service.UserService, apiKeys *service.APIKeyService) *UserHandler {
+	return &UserHandler{service: service, apiKeys: apiKeys}
 }
 
 // Register godoc
@@ -33,7 +38,7 @@ func (h *UserHandler) Register(c *gin.Context) {
 		return
 	}
 
-	response, err := h.service.Register(&req)
+	response, err := h.service.Register(&req, clientInfo(c))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -47,8 +52,10 @@ func (h *UserHandler) Register(c *gin.Context) {
 // @Tags users
 // @Accept json
 // @Produce json
+// @Description Users with two-factor authentication get an MFAChallengeResponse instead of tokens; complete it at /api/users/login/mfa
 // @Param request body models.LoginRequest true "Login request"
 // @Success 200 {object} models.AuthResponse
+// @Failure 429 {object} map[string]interface{}
 // @Router /api/users/login [post]
 func (h *UserHandler) Login(c *gin.Context) {
 	var req models.LoginRequest
@@ -57,15 +64,77 @@ func (h *UserHandler) Login(c *gin.Context) {
 		return
 	}
 
-	response, err := h.service.Login(&req)
+	response, challenge, err := h.service.Login(&req, clientInfo(c))
+	if respondThrottled(c, err) {
+		return
+	}
+	if errors.Is(err, service.ErrInvalidCredentials) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed"})
+		return
+	}
+	if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Refresh godoc
+// @Summary Exchange a refresh token for new access and refresh tokens
+// @Description Each refresh token works once. Reusing one revokes the whole session.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.RefreshRequest true "Refresh request"
+// @Success 200 {object} models.AuthResponse
+// @Router /api/users/refresh [post]
+func (h *UserHandler) Refresh(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.service.Refresh(req.RefreshToken, clientInfo(c))
+	if errors.Is(err, service.ErrInvalidRefreshToken) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh session"})
+		return
+	}
 
 	c.JSON(http.StatusOK, response)
 }
 
+// Logout godoc
+// @Summary Log out, revoking the session's access and refresh tokens
+// @Tags users
+// @Security BearerAuth
+// @Param all query bool false "Log out every session of the user"
+// @Success 204
+// @Router /api/users/logout [post]
+func (h *UserHandler) Logout(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if err := h.service.Logout(claims.(*jwt.Claims), c.Query("all") == "true"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
 // GetProfile godoc
 // @Summary Get user profile
 // @Tags users
@@ -89,6 +158,116 @@ func (h *UserHandler) GetProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
+// UpdateProfile godoc
+// @Summary Change the user's name or email address
+// @Description A new email address needs current_password and must be verified again
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.UpdateProfileRequest true "Fields to change"
+// @Success 200 {object} models.User
+// @Failure 403 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /api/users/profile [patch]
+func (h *UserHandler) UpdateProfile(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req models.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.service.UpdateProfile(userID.(uuid.UUID), &req)
+	switch {
+	case errors.Is(err, service.ErrWrongPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update profile"})
+	default:
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+// ChangePassword godoc
+// @Summary Change the user's password
+// @Description Logs out every other session
+// @Tags users
+// @Accept json
+// @Security BearerAuth
+// @Param request body models.ChangePasswordRequest true "Current and new password"
+// @Success 204
+// @Failure 403 {object} map[string]interface{}
+// @Router /api/users/password [put]
+func (h *UserHandler) ChangePassword(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.service.ChangePassword(claims.(*jwt.Claims), &req)
+	if errors.Is(err, service.ErrWrongPassword) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change password"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteAccount godoc
+// @Summary Delete the user's account
+// @Description Also deletes or anonymizes the user's links and purges their click data
+// @Tags users
+// @Accept json
+// @Security BearerAuth
+// @Param request body models.DeleteAccountRequest true "Current password"
+// @Success 204
+// @Failure 403 {object} map[string]interface{}
+// @Router /api/users/profile [delete]
+func (h *UserHandler) DeleteAccount(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req models.DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.service.DeleteAccount(userID.(uuid.UUID), req.Password)
+	if errors.Is(err, service.ErrWrongPassword) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete account"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
 // GetUser godoc
 // @Summary Get user by ID
 // @Tags users
@@ -118,7 +297,8 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 }
 
 // ValidateToken godoc
-// @Summary Validate JWT token
+// @Summary Validate JWT token or API key
+// @Description API keys (lsk_...) are answered with their scopes; access tokens carry every scope
 // @Tags users
 // @Produce json
 // @Security BearerAuth
@@ -132,6 +312,11 @@ func (h *UserHandler) ValidateToken(c *gin.Context) {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	if strings.HasPrefix(tokenString, service.APIKeyPrefix) {
+		h.validateAPIKey(c, tokenString)
+		return
+	}
+
 	claims, err := h.service.ValidateToken(tokenString)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"valid": false})
@@ -139,12 +324,62 @@ func (h *UserHandler) ValidateToken(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"valid":   true,
-		"user_id": claims.UserID,
-		"email":   claims.Email,
+		"valid":          true,
+		"user_id":        claims.UserID,
+		"email":          claims.Email,
+		"email_verified": claims.EmailVerified,
+	})
+}
+
+func (h *UserHandler) validateAPIKey(c *gin.Context, raw string) {
+	key, user, err := h.apiKeys.Authenticate(raw, c.ClientIP())
+	if errors.Is(err, service.ErrInvalidAPIKey) {
+		c.JSON(http.StatusUnauthorized, gin.H{"valid": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to validate API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"valid":          true,
+		"user_id":        user.ID,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified(),
+		"api_key_id":     key.ID,
+		"scopes":         key.Scopes,
 	})
 }
 
+// JWKS godoc
+// @Summary Public keys for verifying access tokens (JSON Web Key Set)
+// @Tags users
+// @Produce json
+// @Success 200 {object} jwt.JWKSet
+// @Router /.well-known/jwks.json [get]
+func (h *UserHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwt.PublicKeys())
+}
+
+// respondThrottled answers a login refused after repeated failures with 429
+// and Retry-After, and reports whether err was one.
+func respondThrottled(c *gin.Context, err error) bool {
+	var throttled *service.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+	retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "retry_after": retryAfter})
+	return true
+}
+
+func clientInfo(c *gin.Context) models.ClientInfo {
+	return models.ClientInfo{UserAgent: c.Request.UserAgent(), IP: c.ClientIP()}
+}
+
 func (h *UserHandler) Health(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "healthy", "service": "user-service"})
 }
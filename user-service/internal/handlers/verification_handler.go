@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/urlshortener/user-service/internal/models"
+	"github.com/urlshortener/user-service/internal/service"
+)
+
+type VerificationHandler struct {
+	service *service.VerificationService
+}
+
+func NewVerificationHandler(service *service.VerificationService) *VerificationHandler {
+	return &VerificationHandler{service: service}
+}
+
+// VerifyEmail godoc
+// @Summary Confirm an email address with the token from the verification email
+// @Description Access tokens issued before this still say unverified; refresh to get one that does not
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.VerifyEmailRequest true "Verification token"
+// @Success 200 {object} models.User
+// @Failure 400 {object} map[string]interface{}
+// @Router /api/users/verify-email [post]
+func (h *VerificationHandler) VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.service.Verify(req.Token)
+	if errors.Is(err, service.ErrInvalidVerificationToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// ResendVerification godoc
+// @Summary Send the verification email again
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 202 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Router /api/users/verify-email/resend [post]
+func (h *VerificationHandler) ResendVerification(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	err := h.service.Resend(userID.(uuid.UUID))
+	switch {
+	case errors.Is(err, service.ErrEmailAlreadyVerified):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTooManyVerificationEmails):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"message": "verification email sent"})
+	}
+}
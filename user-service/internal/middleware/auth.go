@@ -33,6 +33,7 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		c.Set("user_id", claims.UserID)
 		c.Set("email", claims.Email)
+		c.Set("claims", claims)
 		c.Next()
 	}
 }
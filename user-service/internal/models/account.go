@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UpdateProfileRequest struct {
+	Name  *string `json:"name,omitempty" binding:"omitempty,min=1"`
+	Email *string `json:"email,omitempty" binding:"omitempty,email"`
+	// CurrentPassword is required to change the email address
+	CurrentPassword string `json:"current_password,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// UserDeleted is published on the "user:events" channel once an account is
+// gone, so other services can drop the user's data. It is repeated until
+// each service answers with UserDeletionAcked on the "user:acks" channel.
+const (
+	UserDeleted       = "user.deleted"
+	UserDeletionAcked = "user.deletion_acked"
+)
+
+// UserEmailChanged is published when a user changes their email address,
+// unverified at first, and again once they verify it.
+const UserEmailChanged = "user.email_changed"
+
+// UserEvent announces a change to an account to other services, or
+// acknowledges one.
+type UserEvent struct {
+	Type   string    `json:"type"`
+	UserID uuid.UUID `json:"user_id"`
+	// The acknowledging service
+	Service string `json:"service,omitempty"`
+	// The new address, for UserEmailChanged
+	Email         string    `json:"email,omitempty"`
+	EmailVerified bool      `json:"email_verified,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// AccountDeletion records that a service has yet to acknowledge a deleted
+// account. It is written in the same transaction that deletes the user and
+// removed once the service acknowledges, so the event cannot be lost to a
+// Redis outage or a service that was down.
+type AccountDeletion struct {
+	UserID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Service       string    `gorm:"primaryKey"`
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"not null;index"`
+	CreatedAt     time.Time
+}
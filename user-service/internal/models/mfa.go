@@ -0,0 +1,99 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TOTPFactor is a user's authenticator app. Until ConfirmedAt is set the
+// user is still enrolling and logins do not ask for a code.
+type TOTPFactor struct {
+	UserID uuid.UUID `gorm:"type:uuid;primary_key"`
+	// Secret is encrypted when MFA_ENCRYPTION_KEY is set
+	Secret      string `gorm:"not null"`
+	ConfirmedAt *time.Time
+	// LastUsedStep is the time step of the last accepted code; codes from
+	// it or earlier steps are rejected so none can be replayed
+	LastUsedStep int64
+	// Wrong codes at login since the last right one; too many lock logins
+	// with the factor until LockedUntil
+	FailedAttempts int `gorm:"not null;default:0"`
+	LockedUntil    *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// RecoveryCode is a one-time code that stands in for a TOTP code when the
+// authenticator is lost. Only its hash is stored.
+type RecoveryCode struct {
+	ID       uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID   uuid.UUID `gorm:"type:uuid;index;not null"`
+	CodeHash string    `gorm:"uniqueIndex;not null"`
+	UsedAt   *time.Time
+}
+
+func (c *RecoveryCode) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+// MFAChallenge is the second half of a login: the password was right, and
+// the holder of the challenge token has a few minutes and attempts to enter
+// a code.
+type MFAChallenge struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;index;not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	Attempts  int       `gorm:"not null;default:0"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (c *MFAChallenge) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+// MFAChallengeResponse is what Login returns instead of tokens when the
+// user has two-factor authentication on.
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	// Code is a TOTP code or a recovery code
+	Code string `json:"code" binding:"required"`
+}
+
+type TOTPSetupResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type TOTPConfirmRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type PasswordConfirmRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MFAStatus struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesRemaining int64      `json:"recovery_codes_remaining"`
+}
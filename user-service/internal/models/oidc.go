@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ExternalIdentity links a user to their account (Subject) at an OpenID
+// Connect provider.
+type ExternalIdentity struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;index;not null" json:"-"`
+	Provider  string    `gorm:"uniqueIndex:idx_external_identity;not null" json:"provider"`
+	Subject   string    `gorm:"uniqueIndex:idx_external_identity;not null" json:"-"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (i *ExternalIdentity) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return nil
+}
+
+// OIDCLoginState remembers a login sent to a provider until it comes back.
+// Only the state's hash is stored; it expires and works once.
+type OIDCLoginState struct {
+	ID           uuid.UUID `gorm:"type:uuid;primary_key"`
+	Provider     string    `gorm:"not null"`
+	StateHash    string    `gorm:"uniqueIndex;not null"`
+	Nonce        string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"`
+	ExpiresAt    time.Time `gorm:"not null"`
+	UsedAt       *time.Time
+	CreatedAt    time.Time
+}
+
+func (s *OIDCLoginState) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// OIDCLoginCode hands a completed provider login to the frontend, which
+// trades it for tokens. Only its hash is stored; it expires and works once.
+type OIDCLoginCode struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;index;not null"`
+	CodeHash  string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (c *OIDCLoginCode) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+type OIDCProvider struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	LoginURL    string `json:"login_url"`
+}
+
+type OIDCTokenRequest struct {
+	Code string `json:"code" binding:"required"`
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Session is a login: the family of refresh tokens rotated from one
+// another, and the access tokens issued from them. Revoking it logs the
+// login out everywhere its tokens are used.
+type Session struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID        uuid.UUID  `gorm:"type:uuid;index;not null" json:"user_id"`
+	UserAgent     string     `json:"user_agent"`
+	IP            string     `json:"ip"`
+	LastUsedAt    time.Time  `json:"last_used_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason string     `json:"revoked_reason,omitempty"`
+	// Derives each refresh token from the one it replaces, so a client
+	// exchanging the same token twice in quick succession gets the same one
+	RotationKey string    `gorm:"not null;default:''" json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (s *Session) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// RefreshToken is one token in a session's rotation chain. Only its hash is
+// stored. A token is used once; presenting it again means it leaked.
+type RefreshToken struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	SessionID uuid.UUID `gorm:"type:uuid;index;not null"`
+	UserID    uuid.UUID `gorm:"type:uuid;index;not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (t *RefreshToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// ClientInfo describes where a login comes from.
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/user-service/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UpdateProfile saves the user's name, email address and whether that
+// address is verified.
+func (r *UserRepository) UpdateProfile(user *models.User) error {
+	return r.db.Model(user).Select("name", "email", "email_verified_at").Updates(user).Error
+}
+
+func (r *UserRepository) UpdatePassword(userID uuid.UUID, passwordHash string) error {
+	return r.db.Model(&models.User{}).Where("id = ?", userID).Update("password", passwordHash).Error
+}
+
+// DeleteUser removes a user together with their sessions and outstanding
+// tokens, recording a pending deletion for each of services, first announced
+// at notifyAt. The row is deleted outright so the address can be registered
+// again.
+func (r *UserRepository) DeleteUser(userID uuid.UUID, services []string, notifyAt time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, service := range services {
+			deletion := models.AccountDeletion{UserID: userID, Service: service, NextAttemptAt: notifyAt}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&deletion).Error; err != nil {
+				return err
+			}
+		}
+		for _, model := range []interface{}{
+			&models.RefreshToken{},
+			&models.Session{},
+			&models.PasswordResetToken{},
+			&models.EmailVerificationToken{},
+			&models.TOTPFactor{},
+			&models.RecoveryCode{},
+			&models.MFAChallenge{},
+			&models.ExternalIdentity{},
+			&models.OIDCLoginCode{},
+			&models.APIKey{},
+		} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Where("id = ?", userID).Delete(&models.User{}).Error
+	})
+}
+
+// DueAccountDeletions returns up to limit pending deletions due to be
+// announced again at now.
+func (r *UserRepository) DueAccountDeletions(now time.Time, limit int) ([]models.AccountDeletion, error) {
+	var deletions []models.AccountDeletion
+	err := r.db.Where("next_attempt_at <= ?", now).Order("next_attempt_at").Limit(limit).Find(&deletions).Error
+	return deletions, err
+}
+
+// RescheduleAccountDeletion counts an announcement of a pending deletion and
+// sets when to repeat it.
+func (r *UserRepository) RescheduleAccountDeletion(deletion *models.AccountDeletion, next time.Time) error {
+	return r.db.Model(&models.AccountDeletion{}).
+		Where("user_id = ? AND service = ?", deletion.UserID, deletion.Service).
+		Updates(map[string]interface{}{"attempts": gorm.Expr("attempts + 1"), "next_attempt_at": next}).Error
+}
+
+// AckAccountDeletion removes a pending deletion once service has dropped the
+// user's data.
+func (r *UserRepository) AckAccountDeletion(userID uuid.UUID, service string) (bool, error) {
+	result := r.db.Where("user_id = ? AND service = ?", userID, service).Delete(&models.AccountDeletion{})
+	return result.RowsAffected > 0, result.Error
+}
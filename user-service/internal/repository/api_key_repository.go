@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/user-service/internal/models"
+)
+
+func (r *UserRepository) CreateAPIKey(key *models.APIKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r *UserRepository) ListAPIKeys(userID uuid.UUID) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+func (r *UserRepository) CountAPIKeys(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.APIKey{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *UserRepository) FindAPIKey(hash string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := r.db.Where("key_hash = ?", hash).First(&key).Error
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// DeleteAPIKey revokes one of the user's keys. It reports false if the user
+// has no such key.
+func (r *UserRepository) DeleteAPIKey(userID, id uuid.UUID) (bool, error) {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.APIKey{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// TouchAPIKey records a use of the key, at most once per interval so busy
+// keys do not write on every request.
+func (r *UserRepository) TouchAPIKey(id uuid.UUID, ip string, interval time.Duration) error {
+	now := time.Now()
+	return r.db.Model(&models.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, now.Add(-interval)).
+		Updates(map[string]interface{}{"last_used_at": now, "last_used_ip": ip}).Error
+}
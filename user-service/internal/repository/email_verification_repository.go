@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/urlshortener/user-service/internal/models"
+	"gorm.io/gorm"
+)
+
+// CreateEmailVerificationToken stores token, retiring the user's earlier
+// unused verification tokens so only the latest link works.
+func (r *UserRepository) CreateEmailVerificationToken(token *models.EmailVerificationToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.EmailVerificationToken{}).
+			Where("user_id = ? AND used_at IS NULL", token.UserID).
+			Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+func (r *UserRepository) FindEmailVerificationToken(hash string) (*models.EmailVerificationToken, error) {
+	var token models.EmailVerificationToken
+	err := r.db.Where("token_hash = ?", hash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// VerifyEmail spends token and marks the user's address verified, provided
+// it is still the address the token was sent to. It reports false, changing
+// nothing, otherwise or if the token had already been spent.
+func (r *UserRepository) VerifyEmail(token *models.EmailVerificationToken) (bool, error) {
+	verified := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&models.EmailVerificationToken{}).
+			Where("id = ? AND used_at IS NULL", token.ID).
+			Update("used_at", now)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		result = tx.Model(&models.User{}).
+			Where("id = ? AND email = ?", token.UserID, token.Email).
+			Update("email_verified_at", now)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		verified = true
+		return nil
+	})
+	return verified, err
+}
+
+// MarkExistingUsersVerified treats every user created before email
+// verification existed as verified.
+func (r *UserRepository) MarkExistingUsersVerified() error {
+	return r.db.Model(&models.User{}).
+		Where("email_verified_at IS NULL").
+		Update("email_verified_at", gorm.Expr("created_at")).Error
+}
+
+// DeleteExpiredEmailVerificationTokens removes verification tokens that
+// expired before t.
+func (r *UserRepository) DeleteExpiredEmailVerificationTokens(t time.Time) error {
+	return r.db.Where("expires_at < ?", t).Delete(&models.EmailVerificationToken{}).Error
+}
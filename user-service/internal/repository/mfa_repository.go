@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/user-service/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func (r *UserRepository) FindTOTPFactor(userID uuid.UUID) (*models.TOTPFactor, error) {
+	var factor models.TOTPFactor
+	err := r.db.First(&factor, "user_id = ?", userID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &factor, nil
+}
+
+// SaveTOTPFactor stores a new, unconfirmed factor, replacing an earlier
+// unconfirmed one.
+func (r *UserRepository) SaveTOTPFactor(factor *models.TOTPFactor) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "totp_factors.confirmed_at IS NULL"}}},
+		DoUpdates: clause.AssignmentColumns([]string{"secret", "confirmed_at", "last_used_step", "updated_at"}),
+	}).Create(factor).Error
+}
+
+// ConfirmTOTPFactor turns the factor on and replaces the user's recovery
+// codes.
+func (r *UserRepository) ConfirmTOTPFactor(userID uuid.UUID, step int64, codes []models.RecoveryCode) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.TOTPFactor{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+			"confirmed_at":   time.Now(),
+			"last_used_step": step,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(tx, userID, codes)
+	})
+}
+
+// UseTOTPStep records step as used. It reports false if that step or a
+// later one was already used.
+func (r *UserRepository) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	result := r.db.Model(&models.TOTPFactor{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	return result.RowsAffected > 0, result.Error
+}
+
+// RecordMFAFailure counts a wrong code at login. The failure that reaches
+// maxFailures locks the factor until lockedUntil and starts the count over;
+// it reports whether this one did.
+func (r *UserRepository) RecordMFAFailure(userID uuid.UUID, maxFailures int, lockedUntil time.Time) (bool, error) {
+	locked := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var factor models.TOTPFactor
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&factor, "user_id = ?", userID).Error
+		if err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{"failed_attempts": factor.FailedAttempts + 1}
+		if factor.FailedAttempts+1 >= maxFailures {
+			updates = map[string]interface{}{"failed_attempts": 0, "locked_until": lockedUntil}
+			locked = true
+		}
+		return tx.Model(&models.TOTPFactor{}).Where("user_id = ?", userID).Updates(updates).Error
+	})
+	return locked, err
+}
+
+// ResetMFAFailures forgets wrong codes after a successful login.
+func (r *UserRepository) ResetMFAFailures(userID uuid.UUID) error {
+	return r.db.Model(&models.TOTPFactor{}).Where("user_id = ?", userID).
+		Updates(map[string]interface{}{"failed_attempts": 0, "locked_until": nil}).Error
+}
+
+// DeleteTOTPFactor turns two-factor authentication off, dropping the
+// factor and the recovery codes.
+func (r *UserRepository) DeleteTOTPFactor(userID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.TOTPFactor{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+func (r *UserRepository) ReplaceRecoveryCodes(userID uuid.UUID, codes []models.RecoveryCode) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, codes)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID, codes []models.RecoveryCode) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	return tx.Create(&codes).Error
+}
+
+// UseRecoveryCode spends the user's recovery code with the given hash. It
+// reports false if there is no such unused code.
+func (r *UserRepository) UseRecoveryCode(userID uuid.UUID, hash string) (bool, error) {
+	result := r.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *UserRepository) CountRecoveryCodes(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *UserRepository) CreateMFAChallenge(challenge *models.MFAChallenge) error {
+	return r.db.Create(challenge).Error
+}
+
+func (r *UserRepository) FindMFAChallenge(hash string) (*models.MFAChallenge, error) {
+	var challenge models.MFAChallenge
+	err := r.db.Where("token_hash = ?", hash).First(&challenge).Error
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// CountMFAAttempt records an attempt at a challenge. It reports false if the
+// challenge is spent or out of attempts.
+func (r *UserRepository) CountMFAAttempt(id uuid.UUID, maxAttempts int) (bool, error) {
+	result := r.db.Model(&models.MFAChallenge{}).
+		Where("id = ? AND used_at IS NULL AND attempts < ?", id, maxAttempts).
+		UpdateColumn("attempts", gorm.Expr("attempts + 1"))
+	return result.RowsAffected > 0, result.Error
+}
+
+// UseMFAChallenge spends a challenge. It reports false if it already was.
+func (r *UserRepository) UseMFAChallenge(id uuid.UUID) (bool, error) {
+	result := r.db.Model(&models.MFAChallenge{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteExpiredMFAChallenges removes challenges that expired before t.
+func (r *UserRepository) DeleteExpiredMFAChallenges(t time.Time) error {
+	return r.db.Where("expires_at < ?", t).Delete(&models.MFAChallenge{}).Error
+}
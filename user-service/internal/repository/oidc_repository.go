@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/user-service/internal/models"
+	"gorm.io/gorm"
+)
+
+func (r *UserRepository) FindExternalIdentity(provider, subject string) (*models.ExternalIdentity, error) {
+	var identity models.ExternalIdentity
+	err := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *UserRepository) ListExternalIdentities(userID uuid.UUID) ([]models.ExternalIdentity, error) {
+	var identities []models.ExternalIdentity
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error
+	return identities, err
+}
+
+func (r *UserRepository) CreateExternalIdentity(identity *models.ExternalIdentity) error {
+	return r.db.Create(identity).Error
+}
+
+// CreateUserWithIdentity creates a user signing up through a provider
+// together with the link to their provider account.
+func (r *UserRepository) CreateUserWithIdentity(user *models.User, identity *models.ExternalIdentity) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		identity.UserID = user.ID
+		return tx.Create(identity).Error
+	})
+}
+
+func (r *UserRepository) CreateOIDCLoginState(state *models.OIDCLoginState) error {
+	return r.db.Create(state).Error
+}
+
+func (r *UserRepository) FindOIDCLoginState(hash string) (*models.OIDCLoginState, error) {
+	var state models.OIDCLoginState
+	err := r.db.Where("state_hash = ?", hash).First(&state).Error
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// UseOIDCLoginState spends a login state. It reports false if it already was.
+func (r *UserRepository) UseOIDCLoginState(id uuid.UUID) (bool, error) {
+	result := r.db.Model(&models.OIDCLoginState{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *UserRepository) CreateOIDCLoginCode(code *models.OIDCLoginCode) error {
+	return r.db.Create(code).Error
+}
+
+func (r *UserRepository) FindOIDCLoginCode(hash string) (*models.OIDCLoginCode, error) {
+	var code models.OIDCLoginCode
+	err := r.db.Where("code_hash = ?", hash).First(&code).Error
+	if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+// UseOIDCLoginCode spends a login code. It reports false if it already was.
+func (r *UserRepository) UseOIDCLoginCode(id uuid.UUID) (bool, error) {
+	result := r.db.Model(&models.OIDCLoginCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteExpiredOIDCLogins removes login states and codes that expired
+// before t.
+func (r *UserRepository) DeleteExpiredOIDCLogins(t time.Time) error {
+	if err := r.db.Where("expires_at < ?", t).Delete(&models.OIDCLoginState{}).Error; err != nil {
+		return err
+	}
+	return r.db.Where("expires_at < ?", t).Delete(&models.OIDCLoginCode{}).Error
+}
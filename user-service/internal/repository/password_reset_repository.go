@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/urlshortener/user-service/internal/models"
+	"gorm.io/gorm"
+)
+
+// CreatePasswordResetToken stores token, retiring the user's earlier unused
+// reset tokens so only the latest link works.
+func (r *UserRepository) CreatePasswordResetToken(token *models.PasswordResetToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", token.UserID).
+			Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+func (r *UserRepository) FindPasswordResetToken(hash string) (*models.PasswordResetToken, error) {
+	var token models.PasswordResetToken
+	err := r.db.Where("token_hash = ?", hash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ResetPassword spends token and sets the user's password hash. It reports
+// false, changing nothing, if the token had already been spent.
+func (r *UserRepository) ResetPassword(token *models.PasswordResetToken, passwordHash string) (bool, error) {
+	reset := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", token.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", token.UserID).Update("password", passwordHash).Error; err != nil {
+			return err
+		}
+		reset = true
+		return nil
+	})
+	return reset, err
+}
+
+// DeleteExpiredPasswordResetTokens removes reset tokens that expired before t.
+func (r *UserRepository) DeleteExpiredPasswordResetTokens(t time.Time) error {
+	return r.db.Where("expires_at < ?", t).Delete(&models.PasswordResetToken{}).Error
+}
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/user-service/internal/models"
+	"gorm.io/gorm"
+)
+
+// CreateSession stores a new session together with its first refresh token.
+func (r *UserRepository) CreateSession(session *models.Session, token *models.RefreshToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		token.SessionID = session.ID
+		return tx.Create(token).Error
+	})
+}
+
+func (r *UserRepository) FindSession(id uuid.UUID) (*models.Session, error) {
+	var session models.Session
+	err := r.db.First(&session, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// SetSessionRotationKey gives a session that predates rotation keys one. It
+// reports false if a concurrent call got there first.
+func (r *UserRepository) SetSessionRotationKey(id uuid.UUID, key string) (bool, error) {
+	result := r.db.Model(&models.Session{}).
+		Where("id = ? AND rotation_key = ''", id).
+		Update("rotation_key", key)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *UserRepository) FindRefreshToken(hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.db.Where("token_hash = ?", hash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RotateRefreshToken marks used as spent and stores next in its place. It
+// reports false, storing nothing, if used had already been spent.
+func (r *UserRepository) RotateRefreshToken(used, next *models.RefreshToken, client models.ClientInfo) (bool, error) {
+	rotated := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", used.ID).
+			Update("used_at", now)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		next.SessionID = used.SessionID
+		if err := tx.Create(next).Error; err != nil {
+			return err
+		}
+		rotated = true
+		return tx.Model(&models.Session{}).Where("id = ?", used.SessionID).Updates(map[string]interface{}{
+			"last_used_at": now,
+			"user_agent":   client.UserAgent,
+			"ip":           client.IP,
+		}).Error
+	})
+	return rotated, err
+}
+
+// RevokeSession revokes a session and discards its refresh tokens.
+func (r *UserRepository) RevokeSession(id uuid.UUID, reason string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Session{}).
+			Where("id = ? AND revoked_at IS NULL", id).
+			Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("session_id = ?", id).Delete(&models.RefreshToken{}).Error
+	})
+}
+
+// RevokeUserSessions revokes all of a user's sessions except keep (which
+// may be uuid.Nil to revoke them all).
+func (r *UserRepository) RevokeUserSessions(userID, keep uuid.UUID, reason string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Session{}).
+			Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keep).
+			Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND session_id <> ?", userID, keep).Delete(&models.RefreshToken{}).Error
+	})
+}
+
+// DeleteExpiredRefreshTokens removes refresh tokens that expired before t.
+func (r *UserRepository) DeleteExpiredRefreshTokens(t time.Time) error {
+	return r.db.Where("expires_at < ?", t).Delete(&models.RefreshToken{}).Error
+}
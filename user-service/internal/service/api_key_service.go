@@ -0,0 +1,150 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/user-service/internal/models"
+	"github.com/urlshortener/user-service/internal/repository"
+	"gorm.io/gorm"
+)
+
+const (
+	// APIKeyPrefix marks API keys, so they can be told from access tokens
+	// and recognized when leaked
+	APIKeyPrefix = "lsk_"
+	// Characters of the key kept to tell keys apart
+	apiKeyDisplayLength = len(APIKeyPrefix) + 8
+	maxAPIKeysPerUser   = 25
+	// Last use is recorded at most this often per key
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey      = errors.New("invalid or expired API key")
+	ErrAPIKeyNotFound     = errors.New("API key not found")
+	ErrTooManyAPIKeys     = fmt.Errorf("at most %d API keys per user", maxAPIKeysPerUser)
+	ErrInvalidAPIKeyScope = errors.New("unknown scope")
+)
+
+type APIKeyService struct {
+	repo *repository.UserRepository
+}
+
+func NewAPIKeyService(repo *repository.UserRepository) *APIKeyService {
+	return &APIKeyService{repo: repo}
+}
+
+// Create issues a key limited to req.Scopes. The returned response is the
+// only place the key appears in full.
+func (s *APIKeyService) Create(userID uuid.UUID, req *models.CreateAPIKeyRequest) (*models.APIKeyCreatedResponse, error) {
+	scopes, err := normalizeScopes(req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := s.repo.CountAPIKeys(userID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxAPIKeysPerUser {
+		return nil, ErrTooManyAPIKeys
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	raw := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	key := models.APIKey{
+		UserID:  userID,
+		Name:    strings.TrimSpace(req.Name),
+		Prefix:  raw[:apiKeyDisplayLength],
+		KeyHash: hashToken(raw),
+		Scopes:  scopes,
+	}
+	if req.ExpiresInDays > 0 {
+		expires := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		key.ExpiresAt = &expires
+	}
+	if err := s.repo.CreateAPIKey(&key); err != nil {
+		return nil, err
+	}
+	return &models.APIKeyCreatedResponse{Key: raw, APIKey: key}, nil
+}
+
+func (s *APIKeyService) List(userID uuid.UUID) ([]models.APIKey, error) {
+	return s.repo.ListAPIKeys(userID)
+}
+
+// Revoke deletes one of the user's keys. Services that cached it stop
+// accepting it within their cache TTL.
+func (s *APIKeyService) Revoke(userID, id uuid.UUID) error {
+	deleted, err := s.repo.DeleteAPIKey(userID, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Authenticate returns the key and its owner, recording the use.
+func (s *APIKeyService) Authenticate(raw, ip string) (*models.APIKey, *models.User, error) {
+	if !strings.HasPrefix(raw, APIKeyPrefix) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.repo.FindAPIKey(hashToken(raw))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	user, err := s.repo.FindByID(key.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.repo.TouchAPIKey(key.ID, ip, apiKeyTouchInterval); err != nil {
+		return nil, nil, err
+	}
+	return key, user, nil
+}
+
+// normalizeScopes checks scopes against the known ones and drops duplicates.
+func normalizeScopes(scopes []string) ([]string, error) {
+	known := make(map[string]bool, len(models.APIKeyScopes))
+	for _, scope := range models.APIKeyScopes {
+		known[scope] = true
+	}
+
+	seen := make(map[string]bool)
+	var normalized []string
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if !known[scope] {
+			return nil, fmt.Errorf("%w %q, expected one of %s", ErrInvalidAPIKeyScope, scope, strings.Join(models.APIKeyScopes, ", "))
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized, nil
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/user-service/internal/models"
+	"github.com/urlshortener/user-service/internal/repository"
+	"github.com/urlshortener/user-service/pkg/redis"
+)
+
+const (
+	// Pending deletions announced per run
+	deletionBatchSize = 100
+	// Delay before the first repeat of an unacknowledged deletion; it
+	// doubles with every attempt up to deletionMaxRetryDelay
+	deletionRetryDelay    = time.Minute
+	deletionMaxRetryDelay = time.Hour
+)
+
+// DeletionRelay tells url-service and stats-service about deleted accounts
+// until each has acknowledged dropping the user's data. The services handle
+// repeats of an event they already acted on as a no-op.
+type DeletionRelay struct {
+	repo     *repository.UserRepository
+	redis    *redis.RedisClient
+	services []string
+}
+
+// NewDeletionRelay creates a relay waiting for acknowledgements from the
+// named services.
+func NewDeletionRelay(repo *repository.UserRepository, redis *redis.RedisClient, services []string) *DeletionRelay {
+	return &DeletionRelay{repo: repo, redis: redis, services: services}
+}
+
+// Delete removes the user and announces the deletion. The announcement is
+// repeated by Relay if it fails or goes unacknowledged.
+func (r *DeletionRelay) Delete(userID uuid.UUID) error {
+	if err := r.repo.DeleteUser(userID, r.services, time.Now().Add(deletionRetryDelay)); err != nil {
+		return err
+	}
+	if err := r.announce(userID); err != nil {
+		log.Printf("Failed to publish %s for %s, will retry: %v", models.UserDeleted, userID, err)
+	}
+	return nil
+}
+
+// Relay announces the pending deletions that are due again.
+func (r *DeletionRelay) Relay() error {
+	deletions, err := r.repo.DueAccountDeletions(time.Now(), deletionBatchSize)
+	if err != nil {
+		return err
+	}
+
+	announced := make(map[uuid.UUID]error)
+	for i := range deletions {
+		deletion := &deletions[i]
+		err, done := announced[deletion.UserID]
+		if !done {
+			err = r.announce(deletion.UserID)
+			announced[deletion.UserID] = err
+		}
+		if err != nil {
+			log.Printf("Failed to publish %s for %s: %v", models.UserDeleted, deletion.UserID, err)
+		} else if deletion.Attempts > 0 && deletion.Attempts%10 == 0 {
+			log.Printf("%s has not acknowledged deletion of %s after %d attempts", deletion.Service, deletion.UserID, deletion.Attempts)
+		}
+
+		delay := deletionRetryDelay << deletion.Attempts
+		if deletion.Attempts > 6 || delay > deletionMaxRetryDelay {
+			delay = deletionMaxRetryDelay
+		}
+		if err := r.repo.RescheduleAccountDeletion(deletion, time.Now().Add(delay)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start repeats due deletions every interval and records acknowledgements
+// until ctx is done.
+func (r *DeletionRelay) Start(ctx context.Context, interval time.Duration) {
+	go r.listen(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Relay(); err != nil {
+				log.Printf("Failed to relay account deletions: %v", err)
+			}
+		}
+	}
+}
+
+// listen records the acknowledgements published on "user:acks".
+func (r *DeletionRelay) listen(ctx context.Context) {
+	pubsub := r.redis.Subscribe(ctx, "user:acks")
+	defer pubsub.Close()
+
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to receive deletion acknowledgement: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		var event models.UserEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil || event.Type != models.UserDeletionAcked {
+			continue
+		}
+		acked, err := r.repo.AckAccountDeletion(event.UserID, event.Service)
+		if err != nil {
+			// The deletion is announced again and acknowledged again
+			log.Printf("Failed to record deletion acknowledgement: %v", err)
+			continue
+		}
+		if acked {
+			log.Printf("%s dropped the data of deleted user %s", event.Service, event.UserID)
+		}
+	}
+}
+
+func (r *DeletionRelay) announce(userID uuid.UUID) error {
+	event := models.UserEvent{Type: models.UserDeleted, UserID: userID, Timestamp: time.Now()}
+	return r.redis.Publish(context.Background(), "user:events", event)
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/urlshortener/shared/mailer"
+	"github.com/urlshortener/user-service/internal/email"
+	"github.com/urlshortener/user-service/internal/models"
+	"github.com/urlshortener/user-service/pkg/attempts"
+)
+
+// LoginThrottledError is returned for logins refused because of earlier
+// failures, before the password is checked.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return "too many failed login attempts, try again later"
+}
+
+type LoginGuardConfig struct {
+	// Failures are counted over this window
+	Window time.Duration
+	// Failures per email from one IP after which each further attempt from
+	// that IP has to wait, doubling from one second up to MaxDelay
+	DelayAfter int
+	MaxDelay   time.Duration
+	// Failures per email from one IP that lock the email for that IP for
+	// LockoutDuration; the owner is told by email. Delays and lockouts only
+	// apply to the IP that failed, and logins from elsewhere still work, so
+	// nobody can lock an owner out of their account.
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// Failures from one IP, across all emails, that block it for Window
+	IPThreshold int
+}
+
+// LoginGuard tracks failed logins per email and per IP. Everything is keyed
+// by the email as typed, whether or not an account has it, so responses do
+// not tell registered addresses apart.
+type LoginGuard struct {
+	store        attempts.Store
+	mail         mailer.Sender
+	dashboardURL string
+	cfg          LoginGuardConfig
+}
+
+func NewLoginGuard(store attempts.Store, mail mailer.Sender, dashboardURL string, cfg LoginGuardConfig) *LoginGuard {
+	if cfg.Window <= 0 {
+		cfg.Window = 15 * time.Minute
+	}
+	if cfg.DelayAfter <= 0 {
+		cfg.DelayAfter = 3
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = 30 * time.Second
+	}
+	if cfg.LockoutThreshold <= 0 {
+		cfg.LockoutThreshold = 10
+	}
+	if cfg.LockoutDuration <= 0 {
+		cfg.LockoutDuration = 15 * time.Minute
+	}
+	if cfg.IPThreshold <= 0 {
+		cfg.IPThreshold = 50
+	}
+	return &LoginGuard{
+		store:        store,
+		mail:         mail,
+		dashboardURL: strings.TrimRight(dashboardURL, "/"),
+		cfg:          cfg,
+	}
+}
+
+// Check refuses a login attempt while the IP is blocked, or the email is
+// locked or waiting out a delay for the IP.
+func (g *LoginGuard) Check(address, ip string) error {
+	ctx := context.Background()
+	account := accountKey(address)
+
+	var wait time.Duration
+	for _, key := range []string{"login:block:ip:" + ip, "login:lock:" + account + ":ip:" + ip, "login:delay:" + account + ":ip:" + ip} {
+		ttl, err := g.store.TTL(ctx, key)
+		if err != nil {
+			return err
+		}
+		if ttl > wait {
+			wait = ttl
+		}
+	}
+	if wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Failure records a failed attempt. user is the account with the address,
+// or nil if there is none; only it decides whether a lockout email is sent.
+func (g *LoginGuard) Failure(address, ip string, user *models.User) {
+	ctx := context.Background()
+	account := accountKey(address)
+
+	ipFailures, err := g.store.Incr(ctx, "login:fail:ip:"+ip, g.cfg.Window)
+	if err != nil {
+		log.Printf("Failed to record login failure: %v", err)
+	}
+	if ipFailures >= int64(g.cfg.IPThreshold) {
+		if ipFailures == int64(g.cfg.IPThreshold) {
+			log.Printf("Blocking logins from %s after %d failures", ip, ipFailures)
+		}
+		g.store.Set(ctx, "login:block:ip:"+ip, g.cfg.Window)
+	}
+
+	fromIP, err := g.store.Incr(ctx, "login:fail:"+account+":ip:"+ip, g.cfg.Window)
+	if err != nil {
+		log.Printf("Failed to record login failure: %v", err)
+		return
+	}
+	if fromIP >= int64(g.cfg.DelayAfter) {
+		g.store.Set(ctx, "login:delay:"+account+":ip:"+ip, g.delay(int(fromIP)))
+	}
+	if fromIP >= int64(g.cfg.LockoutThreshold) {
+		g.store.Set(ctx, "login:lock:"+account+":ip:"+ip, g.cfg.LockoutDuration)
+		g.store.Del(ctx, "login:fail:"+account+":ip:"+ip)
+		if user != nil {
+			log.Printf("Locking logins for user %s from %s after %d failures", user.ID, ip, fromIP)
+			// One email per lockout period however many IPs get locked
+			if sent, _ := g.store.TTL(ctx, "login:notified:"+account); sent == 0 {
+				g.store.Set(ctx, "login:notified:"+account, g.cfg.LockoutDuration)
+				go g.notifyLocked(user)
+			}
+		}
+	}
+}
+
+// Success forgets the email's failures from the IP once a login is
+// complete.
+func (g *LoginGuard) Success(address, ip string) {
+	account := accountKey(address)
+	if err := g.store.Del(context.Background(), "login:delay:"+account+":ip:"+ip, "login:fail:"+account+":ip:"+ip); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+}
+
+// delay doubles from one second with each failure past DelayAfter.
+func (g *LoginGuard) delay(failures int) time.Duration {
+	shift := failures - g.cfg.DelayAfter
+	if shift > 16 {
+		return g.cfg.MaxDelay
+	}
+	d := time.Second << shift
+	if d > g.cfg.MaxDelay {
+		return g.cfg.MaxDelay
+	}
+	return d
+}
+
+func (g *LoginGuard) notifyLocked(user *models.User) {
+	msg, err := email.AccountLocked(user.Email, email.LinkData{
+		Name:      user.Name,
+		Link:      g.dashboardURL + "/",
+		ExpiresIn: describeDuration(g.cfg.LockoutDuration),
+	})
+	if err == nil {
+		err = g.mail.Send(msg)
+	}
+	if err != nil {
+		log.Printf("Failed to send account locked email: %v", err)
+	}
+}
+
+// accountKey identifies an email in the store without keeping the address.
+func accountKey(address string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(address))))
+	return fmt.Sprintf("email:%s", hex.EncodeToString(sum[:16]))
+}
@@ -0,0 +1,397 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/user-service/internal/models"
+	"github.com/urlshortener/user-service/internal/repository"
+	"github.com/urlshortener/user-service/pkg/totp"
+	"gorm.io/gorm"
+)
+
+const (
+	mfaTokenPrefix  = "mfa_"
+	mfaChallengeTTL = 5 * time.Minute
+	mfaMaxAttempts  = 5
+	// Wrong codes across challenges that lock logins with the factor for
+	// mfaLockout. Only someone with the password can get that far
+	mfaMaxFailures    = 10
+	mfaLockout        = 15 * time.Minute
+	recoveryCodeCount = 10
+	// Prefix of TOTP secrets encrypted with MFA_ENCRYPTION_KEY
+	sealedPrefix = "enc:"
+)
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrMFANotStarted       = errors.New("start two-factor setup first")
+	ErrInvalidMFACode      = errors.New("invalid authentication code")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired MFA token")
+)
+
+// MFAService manages TOTP two-factor authentication: enrolling an
+// authenticator app, recovery codes, and the second step of logging in.
+type MFAService struct {
+	repo   *repository.UserRepository
+	tokens *TokenService
+	guard  *LoginGuard
+	issuer string
+	aead   cipher.AEAD
+}
+
+// NewMFAService encrypts TOTP secrets at rest with encryptionKey (32 bytes,
+// AES-256-GCM); without one they are stored as they are.
+func NewMFAService(repo *repository.UserRepository, tokens *TokenService, guard *LoginGuard, issuer string, encryptionKey []byte) (*MFAService, error) {
+	s := &MFAService{repo: repo, tokens: tokens, guard: guard, issuer: issuer}
+	if len(encryptionKey) > 0 {
+		block, err := aes.NewCipher(encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		if s.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *MFAService) Status(userID uuid.UUID) (*models.MFAStatus, error) {
+	factor, err := s.confirmedFactor(userID)
+	if errors.Is(err, ErrMFANotEnabled) {
+		return &models.MFAStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	remaining, err := s.repo.CountRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.MFAStatus{Enabled: true, EnabledAt: factor.ConfirmedAt, RecoveryCodesRemaining: remaining}, nil
+}
+
+// Enabled reports whether logins of the user need a second factor.
+func (s *MFAService) Enabled(userID uuid.UUID) (bool, error) {
+	_, err := s.confirmedFactor(userID)
+	if errors.Is(err, ErrMFANotEnabled) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Setup starts enrolling an authenticator app after checking the password,
+// so a stolen access token cannot tie the account to an attacker's app.
+// Nothing changes for the user until Confirm is called with a code from the
+// app.
+func (s *MFAService) Setup(userID uuid.UUID, password string) (*models.TOTPSetupResponse, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPassword(user, password); err != nil {
+		return nil, err
+	}
+	if enabled, err := s.Enabled(userID); err != nil || enabled {
+		if err == nil {
+			err = ErrMFAAlreadyEnabled
+		}
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.seal(secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SaveTOTPFactor(&models.TOTPFactor{UserID: userID, Secret: sealed}); err != nil {
+		return nil, err
+	}
+
+	return &models.TOTPSetupResponse{Secret: secret, URI: totp.URI(s.issuer, user.Email, secret)}, nil
+}
+
+// Confirm turns two-factor authentication on once the user proves the app
+// works, and returns the recovery codes; they are not shown again.
+func (s *MFAService) Confirm(userID uuid.UUID, code string) (*models.RecoveryCodesResponse, error) {
+	factor, err := s.repo.FindTOTPFactor(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMFANotStarted
+	}
+	if err != nil {
+		return nil, err
+	}
+	if factor.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := s.open(factor.Secret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now(), factor.LastUsedStep)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashed, err := newRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ConfirmTOTPFactor(userID, step, hashed); err != nil {
+		return nil, err
+	}
+	return &models.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// Disable turns two-factor authentication off after checking the password.
+func (s *MFAService) Disable(userID uuid.UUID, password string) error {
+	if err := s.checkPassword(userID, password); err != nil {
+		return err
+	}
+	if _, err := s.repo.FindTOTPFactor(userID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrMFANotEnabled
+		}
+		return err
+	}
+	return s.repo.DeleteTOTPFactor(userID)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking the
+// password.
+func (s *MFAService) RegenerateRecoveryCodes(userID uuid.UUID, password string) (*models.RecoveryCodesResponse, error) {
+	if err := s.checkPassword(userID, password); err != nil {
+		return nil, err
+	}
+	if _, err := s.confirmedFactor(userID); err != nil {
+		return nil, err
+	}
+
+	codes, hashed, err := newRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ReplaceRecoveryCodes(userID, hashed); err != nil {
+		return nil, err
+	}
+	return &models.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// Challenge starts the second step of a login whose password was right.
+func (s *MFAService) Challenge(user *models.User) (*models.MFAChallengeResponse, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	raw := mfaTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	challenge := &models.MFAChallenge{
+		UserID:    user.ID,
+		TokenHash: hashToken(raw),
+		ExpiresAt: time.Now().Add(mfaChallengeTTL),
+	}
+	if err := s.repo.CreateMFAChallenge(challenge); err != nil {
+		return nil, err
+	}
+	return &models.MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    raw,
+		ExpiresIn:   int(mfaChallengeTTL.Seconds()),
+	}, nil
+}
+
+// CompleteLogin finishes a login with the challenge token and a TOTP or
+// recovery code, and starts the session.
+func (s *MFAService) CompleteLogin(req *models.MFALoginRequest, client models.ClientInfo) (*models.AuthResponse, error) {
+	challenge, err := s.repo.FindMFAChallenge(hashToken(req.MFAToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+	if challenge.UsedAt != nil || time.Now().After(challenge.ExpiresAt) {
+		return nil, ErrInvalidMFAChallenge
+	}
+	allowed, err := s.repo.CountMFAAttempt(challenge.ID, mfaMaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	// Wrong codes count as failed logins, so new challenges from the right
+	// password do not give unlimited guesses
+	user, err := s.repo.FindByID(challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.guard.Check(user.Email, client.IP); err != nil {
+		return nil, err
+	}
+	factor, err := s.confirmedFactor(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if factor.LockedUntil != nil && time.Now().Before(*factor.LockedUntil) {
+		return nil, &LoginThrottledError{RetryAfter: time.Until(*factor.LockedUntil)}
+	}
+	if err := s.verify(challenge.UserID, req.Code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			s.guard.Failure(user.Email, client.IP, user)
+			if locked, err := s.repo.RecordMFAFailure(user.ID, mfaMaxFailures, time.Now().Add(mfaLockout)); err != nil {
+				log.Printf("Failed to record MFA failure: %v", err)
+			} else if locked {
+				log.Printf("Locking two-factor logins for user %s after %d wrong codes", user.ID, mfaMaxFailures)
+			}
+		}
+		return nil, err
+	}
+	used, err := s.repo.UseMFAChallenge(challenge.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	s.guard.Success(user.Email, client.IP)
+	if factor.FailedAttempts > 0 || factor.LockedUntil != nil {
+		if err := s.repo.ResetMFAFailures(user.ID); err != nil {
+			log.Printf("Failed to reset MFA failures: %v", err)
+		}
+	}
+	return s.tokens.Issue(user, client)
+}
+
+// PurgeExpired removes MFA challenges that can no longer be answered.
+func (s *MFAService) PurgeExpired() error {
+	return s.repo.DeleteExpiredMFAChallenges(time.Now())
+}
+
+// verify accepts a TOTP code not used before, or an unused recovery code.
+func (s *MFAService) verify(userID uuid.UUID, code string) error {
+	factor, err := s.confirmedFactor(userID)
+	if err != nil {
+		return err
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		secret, err := s.open(factor.Secret)
+		if err != nil {
+			return err
+		}
+		step, ok := totp.Validate(secret, code, time.Now(), factor.LastUsedStep)
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		used, err := s.repo.UseTOTPStep(userID, step)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	used, err := s.repo.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+func (s *MFAService) confirmedFactor(userID uuid.UUID) (*models.TOTPFactor, error) {
+	factor, err := s.repo.FindTOTPFactor(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMFANotEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if factor.ConfirmedAt == nil {
+		return nil, ErrMFANotEnabled
+	}
+	return factor, nil
+}
+
+func (s *MFAService) checkPassword(userID uuid.UUID, password string) error {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	return checkPassword(user, password)
+}
+
+func (s *MFAService) seal(secret string) (string, error) {
+	if s.aead == nil {
+		return secret, nil
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *MFAService) open(stored string) (string, error) {
+	if !strings.HasPrefix(stored, sealedPrefix) {
+		return stored, nil
+	}
+	if s.aead == nil {
+		return "", errors.New("TOTP secret is encrypted but MFA_ENCRYPTION_KEY is not set")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < s.aead.NonceSize() {
+		return "", errors.New("malformed TOTP secret")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	secret, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// newRecoveryCodes returns fresh codes to show the user ("ABCDE-FGHIJ") and
+// their hashed records.
+func newRecoveryCodes(userID uuid.UUID) ([]string, []models.RecoveryCode, error) {
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := base32.StdEncoding.EncodeToString(b)[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		records[i] = models.RecoveryCode{UserID: userID, CodeHash: hashToken(code)}
+	}
+	return codes, records, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
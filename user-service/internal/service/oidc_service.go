@@ -0,0 +1,287 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/user-service/internal/models"
+	"github.com/urlshortener/user-service/internal/repository"
+	"github.com/urlshortener/user-service/pkg/oidc"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	// How long a user has to finish logging in at the provider
+	oidcStateTTL = 10 * time.Minute
+	// How long the frontend has to trade the login code for tokens
+	oidcCodeTTL    = time.Minute
+	oidcCodePrefix = "oc_"
+)
+
+var (
+	ErrUnknownProvider      = errors.New("unknown login provider")
+	ErrInvalidOIDCState     = errors.New("login expired or was already completed, please try again")
+	ErrInvalidOIDCLoginCode = errors.New("invalid or expired login code")
+	ErrOIDCRefused          = errors.New("login was cancelled or refused by the provider")
+	ErrOIDCEmailNotVerified = errors.New("the provider did not confirm your email address")
+	// A local account with the address exists but nobody has proven they own
+	// it; linking would hand it to whoever registered it
+	ErrOIDCAccountUnverified = errors.New("an account with this email exists but its address is not verified; verify it or log in with your password first")
+)
+
+// OIDCService signs users in through OpenID Connect providers. Provider
+// accounts are linked to the local user with the same verified email, or
+// create one.
+type OIDCService struct {
+	repo   *repository.UserRepository
+	tokens *TokenService
+	mfa    *MFAService
+	// Where the browser is sent back to after the provider
+	dashboardURL string
+	providers    map[string]*oidc.Provider
+	// Configuration order, for listing
+	names []string
+}
+
+func NewOIDCService(repo *repository.UserRepository, tokens *TokenService, mfa *MFAService, dashboardURL string, providers []*oidc.Provider) *OIDCService {
+	s := &OIDCService{
+		repo:         repo,
+		tokens:       tokens,
+		mfa:          mfa,
+		dashboardURL: strings.TrimRight(dashboardURL, "/"),
+		providers:    make(map[string]*oidc.Provider),
+	}
+	for _, p := range providers {
+		s.providers[p.Name()] = p
+		s.names = append(s.names, p.Name())
+	}
+	return s
+}
+
+// Providers lists the configured providers.
+func (s *OIDCService) Providers() []models.OIDCProvider {
+	providers := make([]models.OIDCProvider, 0, len(s.names))
+	for _, name := range s.names {
+		providers = append(providers, models.OIDCProvider{
+			Name:        name,
+			DisplayName: s.providers[name].DisplayName(),
+			LoginURL:    "/api/users/oidc/" + name + "/login",
+		})
+	}
+	return providers
+}
+
+// FrontendURL is the dashboard address carrying params, where callbacks
+// send the browser with a login code or an error.
+func (s *OIDCService) FrontendURL(params url.Values) string {
+	return s.dashboardURL + "/?" + params.Encode()
+}
+
+// Begin starts a login at provider. It returns the URL to send the browser
+// to and the state the callback has to present.
+func (s *OIDCService) Begin(ctx context.Context, provider string) (string, string, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	var values [3]string
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			return "", "", err
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = s.repo.CreateOIDCLoginState(&models.OIDCLoginState{
+		Provider:     provider,
+		StateHash:    hashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// Callback completes the provider side of a login: it checks state, redeems
+// code and finds or creates the user. The returned login code is traded for
+// tokens with CompleteLogin.
+func (s *OIDCService) Callback(ctx context.Context, provider, state, code string) (string, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+
+	loginState, err := s.repo.FindOIDCLoginState(hashToken(state))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrInvalidOIDCState
+	}
+	if err != nil {
+		return "", err
+	}
+	if loginState.Provider != provider || loginState.UsedAt != nil || time.Now().After(loginState.ExpiresAt) {
+		return "", ErrInvalidOIDCState
+	}
+	used, err := s.repo.UseOIDCLoginState(loginState.ID)
+	if err != nil {
+		return "", err
+	}
+	if !used {
+		return "", ErrInvalidOIDCState
+	}
+
+	claims, err := p.Exchange(ctx, code, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		return "", fmt.Errorf("%s login: %w", provider, err)
+	}
+
+	user, err := s.resolveUser(provider, claims)
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	raw := oidcCodePrefix + base64.RawURLEncoding.EncodeToString(b)
+	err = s.repo.CreateOIDCLoginCode(&models.OIDCLoginCode{
+		UserID:    user.ID,
+		CodeHash:  hashToken(raw),
+		ExpiresAt: time.Now().Add(oidcCodeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// CompleteLogin trades a login code for a session, or for a two-factor
+// challenge when the user has it turned on.
+func (s *OIDCService) CompleteLogin(raw string, client models.ClientInfo) (*models.AuthResponse, *models.MFAChallengeResponse, error) {
+	code, err := s.repo.FindOIDCLoginCode(hashToken(raw))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidOIDCLoginCode
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if code.UsedAt != nil || time.Now().After(code.ExpiresAt) {
+		return nil, nil, ErrInvalidOIDCLoginCode
+	}
+	used, err := s.repo.UseOIDCLoginCode(code.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !used {
+		return nil, nil, ErrInvalidOIDCLoginCode
+	}
+
+	user, err := s.repo.FindByID(code.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mfaEnabled, err := s.mfa.Enabled(user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mfaEnabled {
+		challenge, err := s.mfa.Challenge(user)
+		return nil, challenge, err
+	}
+
+	response, err := s.tokens.Issue(user, client)
+	return response, nil, err
+}
+
+// Identities lists the provider accounts linked to a user.
+func (s *OIDCService) Identities(userID uuid.UUID) ([]models.ExternalIdentity, error) {
+	return s.repo.ListExternalIdentities(userID)
+}
+
+// PurgeExpired removes login states and codes that can no longer be used.
+func (s *OIDCService) PurgeExpired() error {
+	return s.repo.DeleteExpiredOIDCLogins(time.Now())
+}
+
+// resolveUser returns the user linked to the provider account, linking it
+// to the user with the same verified email or creating one on first login.
+func (s *OIDCService) resolveUser(provider string, claims *oidc.Claims) (*models.User, error) {
+	identity, err := s.repo.FindExternalIdentity(provider, claims.Subject)
+	if err == nil {
+		return s.repo.FindByID(identity.UserID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, ErrOIDCEmailNotVerified
+	}
+	identity = &models.ExternalIdentity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+
+	user, err := s.repo.FindByEmail(claims.Email)
+	switch {
+	case err == nil:
+		if !user.EmailVerified() {
+			return nil, ErrOIDCAccountUnverified
+		}
+		identity.UserID = user.ID
+		if err := s.repo.CreateExternalIdentity(identity); err != nil {
+			return nil, err
+		}
+		log.Printf("Linked %s account to user %s", provider, user.ID)
+		return user, nil
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	// New user. They have no password of their own until they reset it.
+	password, err := oidc.RandomString()
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	name := claims.Name
+	if name == "" {
+		name = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	now := time.Now()
+	user = &models.User{
+		Email:           claims.Email,
+		Password:        string(hashedPassword),
+		Name:            name,
+		EmailVerifiedAt: &now,
+	}
+	if err := s.repo.CreateUserWithIdentity(user, identity); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
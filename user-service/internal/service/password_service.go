@@ -0,0 +1,158 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/shared/mailer"
+	"github.com/urlshortener/user-service/internal/email"
+	"github.com/urlshortener/user-service/internal/models"
+	"github.com/urlshortener/user-service/internal/repository"
+	"github.com/urlshortener/user-service/pkg/ratelimit"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const resetTokenPrefix = "pr_"
+
+// Reset requests allowed per hour for one address and for one client IP
+const (
+	resetsPerEmail = 3
+	resetsPerIP    = 10
+)
+
+var (
+	ErrInvalidResetToken    = errors.New("invalid or expired reset link")
+	ErrTooManyResetRequests = errors.New("too many reset requests, try again later")
+)
+
+// PasswordService handles forgotten passwords: it emails single-use reset
+// links and sets the new password when one is followed.
+type PasswordService struct {
+	repo         *repository.UserRepository
+	tokens       *TokenService
+	mail         mailer.Sender
+	dashboardURL string
+	ttl          time.Duration
+	perEmail     *ratelimit.Limiter
+	perIP        *ratelimit.Limiter
+}
+
+func NewPasswordService(repo *repository.UserRepository, tokens *TokenService, mail mailer.Sender, dashboardURL string, ttl time.Duration) *PasswordService {
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	return &PasswordService{
+		repo:         repo,
+		tokens:       tokens,
+		mail:         mail,
+		dashboardURL: strings.TrimRight(dashboardURL, "/"),
+		ttl:          ttl,
+		perEmail:     ratelimit.New(resetsPerEmail, time.Hour),
+		perIP:        ratelimit.New(resetsPerIP, time.Hour),
+	}
+}
+
+// RequestReset emails a reset link to address if it belongs to a user. The
+// lookup and the email happen in the background and the result is the same
+// either way, so callers cannot tell which addresses are registered.
+func (s *PasswordService) RequestReset(address, ip string) error {
+	if !s.perIP.Allow(ip) || !s.perEmail.Allow(strings.ToLower(address)) {
+		return ErrTooManyResetRequests
+	}
+	go func() {
+		if err := s.sendReset(address, ip); err != nil {
+			log.Printf("Failed to send password reset email: %v", err)
+		}
+	}()
+	return nil
+}
+
+// ResetPassword sets a new password with a reset token, then signs the user
+// out of every session.
+func (s *PasswordService) ResetPassword(raw, password string) error {
+	token, err := s.repo.FindPasswordResetToken(hashToken(raw))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	reset, err := s.repo.ResetPassword(token, string(hashedPassword))
+	if err != nil {
+		return err
+	}
+	if !reset {
+		return ErrInvalidResetToken
+	}
+
+	return s.tokens.LogoutOthers(token.UserID, uuid.Nil, "password reset")
+}
+
+// PurgeExpired removes reset tokens that can no longer be used.
+func (s *PasswordService) PurgeExpired() error {
+	return s.repo.DeleteExpiredPasswordResetTokens(time.Now())
+}
+
+func (s *PasswordService) sendReset(address, ip string) error {
+	user, err := s.repo.FindByEmail(address)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	raw := resetTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	token := &models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(raw),
+		ExpiresAt: time.Now().Add(s.ttl),
+		IP:        ip,
+	}
+	if err := s.repo.CreatePasswordResetToken(token); err != nil {
+		return err
+	}
+
+	msg, err := email.PasswordReset(user.Email, email.LinkData{
+		Name:      user.Name,
+		Link:      s.dashboardURL + "/?" + url.Values{"reset_token": {raw}}.Encode(),
+		ExpiresIn: describeDuration(s.ttl),
+	})
+	if err != nil {
+		return err
+	}
+	return s.mail.Send(msg)
+}
+
+// describeDuration renders a token lifetime for an email: "1 hour",
+// "30 minutes".
+func describeDuration(d time.Duration) string {
+	unit, n := "minute", int(d/time.Minute)
+	if d >= time.Hour && d%time.Hour == 0 {
+		unit, n = "hour", int(d/time.Hour)
+	}
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
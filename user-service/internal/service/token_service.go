@@ -0,0 +1,230 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/user-service/internal/models"
+	"github.com/urlshortener/user-service/internal/repository"
+	"github.com/urlshortener/user-service/pkg/jwt"
+	"gorm.io/gorm"
+)
+
+const (
+	refreshTokenPrefix = "rt_"
+	// How long after an exchange the same refresh token can be exchanged
+	// again for the same successor, for clients refreshing from several
+	// requests or tabs at once
+	refreshReuseGrace = 30 * time.Second
+)
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// TokenService issues access tokens with rotating refresh tokens and tracks
+// the sessions they belong to.
+type TokenService struct {
+	repo       *repository.UserRepository
+	refreshTTL time.Duration
+}
+
+func NewTokenService(repo *repository.UserRepository, refreshTTL time.Duration) *TokenService {
+	if refreshTTL <= 0 {
+		refreshTTL = 30 * 24 * time.Hour
+	}
+	return &TokenService{repo: repo, refreshTTL: refreshTTL}
+}
+
+// Issue starts a new session for user.
+func (s *TokenService) Issue(user *models.User, client models.ClientInfo) (*models.AuthResponse, error) {
+	raw, err := randomToken(refreshTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+	rotationKey, err := randomToken("")
+	if err != nil {
+		return nil, err
+	}
+	token := s.newRefreshToken(user.ID, raw)
+	session := &models.Session{
+		UserID:      user.ID,
+		UserAgent:   client.UserAgent,
+		IP:          client.IP,
+		LastUsedAt:  time.Now(),
+		RotationKey: rotationKey,
+	}
+	if err := s.repo.CreateSession(session, token); err != nil {
+		return nil, err
+	}
+	return s.respond(user, session.ID, raw)
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token.
+// Presenting a refresh token that was already exchanged revokes its whole
+// session, since either the client or an attacker holds a stolen copy;
+// within refreshReuseGrace, while its successor is unused, it returns that
+// successor again instead.
+func (s *TokenService) Refresh(raw string, client models.ClientInfo) (*models.AuthResponse, error) {
+	used, err := s.repo.FindRefreshToken(hashToken(raw))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(used.ExpiresAt) && used.UsedAt == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	session, err := s.repo.FindSession(used.SessionID)
+	if err != nil || session.RevokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	user, err := s.repo.FindByID(used.UserID)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if session.RotationKey == "" {
+		if session, err = s.addRotationKey(session); err != nil {
+			return nil, err
+		}
+	}
+
+	nextRaw := successorToken(session.RotationKey, raw)
+	if used.UsedAt != nil {
+		return s.replay(user, session, used, nextRaw)
+	}
+
+	rotated, err := s.repo.RotateRefreshToken(used, s.newRefreshToken(user.ID, nextRaw), client)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		// Lost a race with another exchange of the same token
+		used, err = s.repo.FindRefreshToken(used.TokenHash)
+		if err != nil {
+			return nil, ErrInvalidRefreshToken
+		}
+		return s.replay(user, session, used, nextRaw)
+	}
+
+	return s.respond(user, session.ID, nextRaw)
+}
+
+// replay answers a second exchange of used with its successor nextRaw if
+// that is within the grace period and the successor is unused, and revokes
+// the session otherwise.
+func (s *TokenService) replay(user *models.User, session *models.Session, used *models.RefreshToken, nextRaw string) (*models.AuthResponse, error) {
+	if used.UsedAt != nil && time.Since(*used.UsedAt) <= refreshReuseGrace {
+		next, err := s.repo.FindRefreshToken(hashToken(nextRaw))
+		if err == nil && next.UsedAt == nil && next.SessionID == session.ID {
+			return s.respond(user, session.ID, nextRaw)
+		}
+	}
+	s.revokeReused(used)
+	return nil, ErrInvalidRefreshToken
+}
+
+// addRotationKey gives a session created before rotation keys existed one,
+// returning the session as stored.
+func (s *TokenService) addRotationKey(session *models.Session) (*models.Session, error) {
+	key, err := randomToken("")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.repo.SetSessionRotationKey(session.ID, key); err != nil {
+		return nil, err
+	}
+	return s.repo.FindSession(session.ID)
+}
+
+// Logout revokes a session.
+func (s *TokenService) Logout(sessionID uuid.UUID) error {
+	return s.repo.RevokeSession(sessionID, "logout")
+}
+
+// LogoutOthers revokes every session of the user except keep (uuid.Nil for
+// all of them).
+func (s *TokenService) LogoutOthers(userID, keep uuid.UUID, reason string) error {
+	return s.repo.RevokeUserSessions(userID, keep, reason)
+}
+
+// CheckSession rejects access tokens whose session has been revoked. It is
+// installed as the jwt package's revocation check.
+func (s *TokenService) CheckSession(claims *jwt.Claims) error {
+	if claims.SessionID == uuid.Nil {
+		return nil
+	}
+	session, err := s.repo.FindSession(claims.SessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return jwt.ErrTokenRevoked
+	}
+	if err != nil {
+		return err
+	}
+	if session.RevokedAt != nil {
+		return jwt.ErrTokenRevoked
+	}
+	return nil
+}
+
+// PurgeExpired removes refresh tokens that can no longer be exchanged.
+func (s *TokenService) PurgeExpired() error {
+	return s.repo.DeleteExpiredRefreshTokens(time.Now())
+}
+
+func (s *TokenService) revokeReused(token *models.RefreshToken) {
+	log.Printf("Refresh token reuse detected for user %s, revoking session %s", token.UserID, token.SessionID)
+	if err := s.repo.RevokeSession(token.SessionID, "refresh token reuse"); err != nil {
+		log.Printf("Failed to revoke session %s: %v", token.SessionID, err)
+	}
+}
+
+func (s *TokenService) respond(user *models.User, sessionID uuid.UUID, refreshToken string) (*models.AuthResponse, error) {
+	accessToken, err := jwt.GenerateToken(user.ID, user.Email, sessionID, user.EmailVerified())
+	if err != nil {
+		return nil, err
+	}
+	return &models.AuthResponse{
+		Token:        accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(jwt.AccessTokenTTL().Seconds()),
+		RefreshToken: refreshToken,
+		User:         *user,
+	}, nil
+}
+
+func (s *TokenService) newRefreshToken(userID uuid.UUID, raw string) *models.RefreshToken {
+	return &models.RefreshToken{
+		UserID:    userID,
+		TokenHash: hashToken(raw),
+		ExpiresAt: time.Now().Add(s.refreshTTL),
+	}
+}
+
+func randomToken(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// successorToken derives the refresh token that replaces raw. Without the
+// session's key, a leaked token says nothing about its successors.
+func successorToken(rotationKey, raw string) string {
+	mac := hmac.New(sha256.New, []byte(rotationKey))
+	mac.Write([]byte(raw))
+	return refreshTokenPrefix + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -8,20 +8,35 @@ import (
 	"github.com/urlshortener/user-service/internal/repository"
 	"github.com/urlshortener/user-service/pkg/jwt"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrWrongPassword      = errors.New("current password is incorrect")
+	ErrEmailTaken         = errors.New("email already registered")
+)
+
+// Compared against when the email is unknown; the cost matches real hashes
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
 type UserService struct {
-	repo *repository.UserRepository
+	repo         *repository.UserRepository
+	tokens       *TokenService
+	verification *VerificationService
+	mfa          *MFAService
+	guard        *LoginGuard
+	deletions    *DeletionRelay
 }
 
-func NewUserService(repo *repository.UserRepository) *UserService {
-	return &UserService{repo: repo}
+func NewUserService(repo *repository.UserRepository, tokens *TokenService, verification *VerificationService, mfa *MFAService, guard *LoginGuard, deletions *DeletionRelay) *UserService {
+	return &UserService{repo: repo, tokens: tokens, verification: verification, mfa: mfa, guard: guard, deletions: deletions}
 }
 
-func (s *UserService) Register(req *models.RegisterRequest) (*models.AuthResponse, error) {
+func (s *UserService) Register(req *models.RegisterRequest, client models.ClientInfo) (*models.AuthResponse, error) {
 	// Check if email exists
 	if s.repo.EmailExists(req.Email) {
-		return nil, errors.New("email already registered")
+		return nil, ErrEmailTaken
 	}
 
 	// Hash password
@@ -40,41 +55,54 @@ func (s *UserService) Register(req *models.RegisterRequest) (*models.AuthRespons
 	if err := s.repo.Create(user); err != nil {
 		return nil, err
 	}
+	s.verification.SendVerification(user)
 
-	// Generate token
-	token, err := jwt.GenerateToken(user.ID, user.Email)
-	if err != nil {
-		return nil, err
-	}
-
-	return &models.AuthResponse{
-		Token: token,
-		User:  *user,
-	}, nil
+	// Start a session; until the address is verified the unverified-user
+	// policy applies
+	return s.tokens.Issue(user, client)
 }
 
-func (s *UserService) Login(req *models.LoginRequest) (*models.AuthResponse, error) {
+// Login checks the password and starts a session, or, for users with
+// two-factor authentication, returns a challenge to complete with a code.
+// Repeated failures are throttled with a *LoginThrottledError.
+func (s *UserService) Login(req *models.LoginRequest, client models.ClientInfo) (*models.AuthResponse, *models.MFAChallengeResponse, error) {
+	if err := s.guard.Check(req.Email, client.IP); err != nil {
+		return nil, nil, err
+	}
+
 	// Find user by email
 	user, err := s.repo.FindByEmail(req.Email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Spend as long as a wrong password would, so timing does not
+		// reveal which emails are registered
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+		s.guard.Failure(req.Email, client.IP, nil)
+		return nil, nil, ErrInvalidCredentials
+	}
 	if err != nil {
-		return nil, errors.New("invalid credentials")
+		return nil, nil, err
 	}
 
 	// Check password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		return nil, errors.New("invalid credentials")
+		s.guard.Failure(req.Email, client.IP, user)
+		return nil, nil, ErrInvalidCredentials
 	}
 
-	// Generate token
-	token, err := jwt.GenerateToken(user.ID, user.Email)
+	// Second factor
+	mfaEnabled, err := s.mfa.Enabled(user.ID)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if mfaEnabled {
+		challenge, err := s.mfa.Challenge(user)
+		return nil, challenge, err
 	}
 
-	return &models.AuthResponse{
-		Token: token,
-		User:  *user,
-	}, nil
+	// Start a session
+	s.guard.Success(req.Email, client.IP)
+	response, err := s.tokens.Issue(user, client)
+	return response, nil, err
 }
 
 func (s *UserService) GetUser(id uuid.UUID) (*models.User, error) {
@@ -84,3 +112,94 @@ func (s *UserService) GetUser(id uuid.UUID) (*models.User, error) {
 func (s *UserService) ValidateToken(tokenString string) (*jwt.Claims, error) {
 	return jwt.ValidateToken(tokenString)
 }
+
+func (s *UserService) Refresh(refreshToken string, client models.ClientInfo) (*models.AuthResponse, error) {
+	return s.tokens.Refresh(refreshToken, client)
+}
+
+// Logout revokes the session the access token belongs to, or with all set
+// every session of the user.
+func (s *UserService) Logout(claims *jwt.Claims, all bool) error {
+	if all {
+		return s.tokens.LogoutOthers(claims.UserID, uuid.Nil, "logout")
+	}
+	if claims.SessionID == uuid.Nil {
+		return nil
+	}
+	return s.tokens.Logout(claims.SessionID)
+}
+
+// UpdateProfile changes the user's name and email address. A new address
+// needs the current password and starts out unverified; a verification
+// email is sent to it.
+func (s *UserService) UpdateProfile(userID uuid.UUID, req *models.UpdateProfileRequest) (*models.User, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		user.Name = *req.Name
+	}
+	emailChanged := req.Email != nil && *req.Email != user.Email
+	if emailChanged {
+		if err := checkPassword(user, req.CurrentPassword); err != nil {
+			return nil, err
+		}
+		if s.repo.EmailExists(*req.Email) {
+			return nil, ErrEmailTaken
+		}
+		user.Email = *req.Email
+		user.EmailVerifiedAt = nil
+	}
+
+	if err := s.repo.UpdateProfile(user); err != nil {
+		return nil, err
+	}
+	if emailChanged {
+		s.verification.EmailChanged(user)
+	}
+	return user, nil
+}
+
+// ChangePassword sets a new password and logs out every other session.
+func (s *UserService) ChangePassword(claims *jwt.Claims, req *models.ChangePasswordRequest) error {
+	user, err := s.repo.FindByID(claims.UserID)
+	if err != nil {
+		return err
+	}
+	if err := checkPassword(user, req.CurrentPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.UpdatePassword(user.ID, string(hashedPassword)); err != nil {
+		return err
+	}
+	return s.tokens.LogoutOthers(user.ID, claims.SessionID, "password change")
+}
+
+// DeleteAccount deletes the user and their sessions, then announces it until
+// url-service and stats-service confirm they dropped the user's links and
+// click data.
+func (s *UserService) DeleteAccount(userID uuid.UUID, password string) error {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if err := checkPassword(user, password); err != nil {
+		return err
+	}
+
+	return s.deletions.Delete(user.ID)
+}
+
+func checkPassword(user *models.User, password string) error {
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return ErrWrongPassword
+	}
+	return nil
+}
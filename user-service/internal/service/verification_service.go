@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urlshortener/shared/mailer"
+	"github.com/urlshortener/user-service/internal/email"
+	"github.com/urlshortener/user-service/internal/models"
+	"github.com/urlshortener/user-service/internal/repository"
+	"github.com/urlshortener/user-service/pkg/ratelimit"
+	"github.com/urlshortener/user-service/pkg/redis"
+	"gorm.io/gorm"
+)
+
+const verificationTokenPrefix = "ev_"
+
+// Verification emails allowed per user: one a minute, five an hour
+const verificationsPerHour = 5
+
+var (
+	ErrInvalidVerificationToken  = errors.New("invalid or expired verification link")
+	ErrEmailAlreadyVerified      = errors.New("email address is already verified")
+	ErrTooManyVerificationEmails = errors.New("a verification email was sent recently, try again later")
+)
+
+// VerificationService confirms that users own the address they signed up
+// with, by emailing them a single-use link. Address changes are announced to
+// other services, so stats-service only mails reports to verified ones.
+type VerificationService struct {
+	repo         *repository.UserRepository
+	mail         mailer.Sender
+	events       *redis.RedisClient
+	dashboardURL string
+	ttl          time.Duration
+	cooldown     *ratelimit.Limiter
+	hourly       *ratelimit.Limiter
+}
+
+func NewVerificationService(repo *repository.UserRepository, mail mailer.Sender, events *redis.RedisClient, dashboardURL string, ttl time.Duration) *VerificationService {
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return &VerificationService{
+		repo:         repo,
+		mail:         mail,
+		events:       events,
+		dashboardURL: strings.TrimRight(dashboardURL, "/"),
+		ttl:          ttl,
+		cooldown:     ratelimit.New(1, time.Minute),
+		hourly:       ratelimit.New(verificationsPerHour, time.Hour),
+	}
+}
+
+// SendVerification emails user a link confirming their current address, in
+// the background.
+func (s *VerificationService) SendVerification(user *models.User) {
+	s.cooldown.Allow(user.ID.String())
+	s.hourly.Allow(user.ID.String())
+	go func() {
+		if err := s.send(user); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+		}
+	}()
+}
+
+// EmailChanged announces the user's new, unverified address and emails a
+// link confirming it.
+func (s *VerificationService) EmailChanged(user *models.User) {
+	s.announce(user)
+	s.SendVerification(user)
+}
+
+// Resend emails a fresh verification link, throttled per user.
+func (s *VerificationService) Resend(userID uuid.UUID) error {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified() {
+		return ErrEmailAlreadyVerified
+	}
+	if !s.cooldown.Allow(userID.String()) || !s.hourly.Allow(userID.String()) {
+		return ErrTooManyVerificationEmails
+	}
+	return s.send(user)
+}
+
+// Verify marks the address a verification token was sent to as verified and
+// returns the updated user.
+func (s *VerificationService) Verify(raw string) (*models.User, error) {
+	token, err := s.repo.FindEmailVerificationToken(hashToken(raw))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	verified, err := s.repo.VerifyEmail(token)
+	if err != nil {
+		return nil, err
+	}
+	if !verified {
+		// Spent, or the user has changed address since it was sent
+		return nil, ErrInvalidVerificationToken
+	}
+
+	user, err := s.repo.FindByID(token.UserID)
+	if err != nil {
+		return nil, err
+	}
+	s.announce(user)
+	return user, nil
+}
+
+// announce publishes the user's current address and whether it is verified.
+func (s *VerificationService) announce(user *models.User) {
+	event := models.UserEvent{
+		Type:          models.UserEmailChanged,
+		UserID:        user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified(),
+		Timestamp:     time.Now(),
+	}
+	if err := s.events.Publish(context.Background(), "user:events", event); err != nil {
+		log.Printf("Failed to publish %s for %s: %v", models.UserEmailChanged, user.ID, err)
+	}
+}
+
+// PurgeExpired removes verification tokens that can no longer be used.
+func (s *VerificationService) PurgeExpired() error {
+	return s.repo.DeleteExpiredEmailVerificationTokens(time.Now())
+}
+
+func (s *VerificationService) send(user *models.User) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	raw := verificationTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	token := &models.EmailVerificationToken{
+		UserID:    user.ID,
+		Email:     user.Email,
+		TokenHash: hashToken(raw),
+		ExpiresAt: time.Now().Add(s.ttl),
+	}
+	if err := s.repo.CreateEmailVerificationToken(token); err != nil {
+		return err
+	}
+
+	msg, err := email.EmailVerification(user.Email, email.LinkData{
+		Name:      user.Name,
+		Link:      s.dashboardURL + "/?" + url.Values{"verify_token": {raw}}.Encode(),
+		ExpiresIn: describeDuration(s.ttl),
+	})
+	if err != nil {
+		return err
+	}
+	return s.mail.Send(msg)
+}
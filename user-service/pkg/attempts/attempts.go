@@ -0,0 +1,187 @@
+// Package attempts keeps expiring counters and flags for tracking failed
+// attempts, in Redis with an in-memory fallback.
+package attempts
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Store holds counters and flags that expire. *redis.RedisClient is one.
+type Store interface {
+	// Incr increments key and returns the new value; a new key expires
+	// after ttl.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Set sets a flag that expires after ttl.
+	Set(ctx context.Context, key string, ttl time.Duration) error
+	// TTL returns how long key has left, or 0 if it does not exist.
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	Del(ctx context.Context, keys ...string) error
+}
+
+// After a primary failure, calls go straight to the fallback this long
+const retryPrimaryAfter = 30 * time.Second
+
+// WithFallback uses primary and switches to fallback for any call primary
+// fails, so an outage of a shared store degrades to per-instance limits
+// rather than none.
+func WithFallback(primary, fallback Store) Store {
+	return &fallbackStore{primary: primary, fallback: fallback}
+}
+
+type fallbackStore struct {
+	primary, fallback Store
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+func (s *fallbackStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	if s.down() {
+		return s.fallback.Incr(ctx, key, ttl)
+	}
+	n, err := s.primary.Incr(ctx, key, ttl)
+	if err != nil {
+		s.failed(err)
+		return s.fallback.Incr(ctx, key, ttl)
+	}
+	return n, nil
+}
+
+func (s *fallbackStore) Set(ctx context.Context, key string, ttl time.Duration) error {
+	if s.down() {
+		return s.fallback.Set(ctx, key, ttl)
+	}
+	if err := s.primary.Set(ctx, key, ttl); err != nil {
+		s.failed(err)
+		return s.fallback.Set(ctx, key, ttl)
+	}
+	return nil
+}
+
+// TTL asks both stores, so flags set during an outage are still seen after
+// the primary comes back.
+func (s *fallbackStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	local, _ := s.fallback.TTL(ctx, key)
+	if s.down() {
+		return local, nil
+	}
+	ttl, err := s.primary.TTL(ctx, key)
+	if err != nil {
+		s.failed(err)
+		return local, nil
+	}
+	if local > ttl {
+		return local, nil
+	}
+	return ttl, nil
+}
+
+func (s *fallbackStore) Del(ctx context.Context, keys ...string) error {
+	s.fallback.Del(ctx, keys...)
+	if s.down() {
+		return nil
+	}
+	if err := s.primary.Del(ctx, keys...); err != nil {
+		s.failed(err)
+	}
+	return nil
+}
+
+func (s *fallbackStore) down() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return time.Now().Before(s.downUntil)
+}
+
+// failed stops using the primary for a while, so an unreachable store does
+// not slow every call down.
+func (s *fallbackStore) failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.downUntil = time.Now().Add(retryPrimaryAfter)
+	log.Printf("Attempt store unavailable, counting in memory for %s: %v", retryPrimaryAfter, err)
+}
+
+type entry struct {
+	value   int64
+	expires time.Time
+}
+
+// MemoryStore keeps counters in this process only.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	swept   time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*entry)}
+}
+
+func (s *MemoryStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	e, ok := s.entries[key]
+	if !ok || !now.Before(e.expires) {
+		e = &entry{expires: now.Add(ttl)}
+		s.entries[key] = e
+	}
+	e.value++
+	return e.value, nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = &entry{value: 1, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return 0, nil
+	}
+	left := time.Until(e.expires)
+	if left <= 0 {
+		delete(s.entries, key)
+		return 0, nil
+	}
+	return left, nil
+}
+
+func (s *MemoryStore) Del(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// sweep drops expired entries, at most once a minute.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+	s.swept = now
+	for key, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package jwt
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"os"
 	"time"
 
@@ -9,40 +11,106 @@ import (
 	"github.com/google/uuid"
 )
 
-var jwtSecret []byte
+var (
+	signingKeys     *KeySet
+	issuer          = "urlshortener"
+	accessTokenTTL  = 15 * time.Minute
+	revocationCheck func(*Claims) error
+)
+
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// Init loads the signing keys. Keys are read from the PEM files in
+// JWT_KEYS_DIR, signing with JWT_ACTIVE_KID (default: the last key ID in
+// sort order), or derived from JWT_SIGNING_SEED; without either an ephemeral
+// Ed25519 key is generated, which invalidates all tokens on restart.
+func Init() error {
+	var err error
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		signingKeys, err = LoadKeySet(dir, os.Getenv("JWT_ACTIVE_KID"))
+	} else if seed := os.Getenv("JWT_SIGNING_SEED"); seed != "" {
+		signingKeys, err = SeedKeySet(seed)
+	} else {
+		log.Println("JWT_KEYS_DIR and JWT_SIGNING_SEED not set, signing tokens with an ephemeral key")
+		signingKeys, err = EphemeralKeySet()
+	}
+	if err != nil {
+		return fmt.Errorf("load JWT signing keys: %w", err)
+	}
 
-func Init() {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "default-secret-key"
+	if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+		issuer = iss
 	}
-	jwtSecret = []byte(secret)
+	if ttl, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && ttl > 0 {
+		accessTokenTTL = ttl
+	}
+	return nil
+}
+
+// SetRevocationCheck installs a check that ValidateToken runs on every
+// otherwise valid token; a non-nil error rejects the token.
+func SetRevocationCheck(check func(*Claims) error) {
+	revocationCheck = check
+}
+
+// AccessTokenTTL is how long tokens from GenerateToken stay valid.
+func AccessTokenTTL() time.Duration {
+	return accessTokenTTL
+}
+
+// PublicKeys returns the verification keys as a JSON Web Key Set.
+func PublicKeys() JWKSet {
+	return signingKeys.JWKS()
 }
 
 type Claims struct {
 	UserID uuid.UUID `json:"user_id"`
 	Email  string    `json:"email"`
+	// SessionID ties the token to the refresh token family it was issued
+	// from, so revoking the session revokes the token.
+	SessionID uuid.UUID `json:"sid,omitempty"`
+	// EmailVerified lets other services apply the unverified-user policy
+	// without asking user-service.
+	EmailVerified bool `json:"email_verified"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID uuid.UUID, email string) (string, error) {
+// GenerateToken issues a short-lived access token for a session, signed
+// with the active key.
+func GenerateToken(userID uuid.UUID, email string, sessionID uuid.UUID, emailVerified bool) (string, error) {
+	now := time.Now()
 	claims := Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:        userID,
+		Email:         email,
+		SessionID:     sessionID,
+		EmailVerified: emailVerified,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        uuid.NewString(),
+			Issuer:    issuer,
+			Subject:   userID.String(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	key := signingKeys.Active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
 }
 
 func ValidateToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		key := signingKeys.Find(kid)
+		if key == nil {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return key.Private.Public(), nil
+	}, jwt.WithValidMethods([]string{"RS256", "EdDSA"}), jwt.WithIssuer(issuer))
 
 	if err != nil {
 		return nil, err
@@ -53,5 +121,11 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid token")
 	}
 
+	if revocationCheck != nil {
+		if err := revocationCheck(claims); err != nil {
+			return nil, err
+		}
+	}
+
 	return claims, nil
 }
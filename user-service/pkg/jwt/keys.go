@@ -0,0 +1,215 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is a signing key with its key ID (the JWT "kid" header).
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+}
+
+// KeySet holds every key tokens may be verified with, one of which signs new
+// tokens. To rotate, add a key and make it active; keep the old one until
+// tokens signed with it have expired.
+type KeySet struct {
+	keys   map[string]*Key
+	active *Key
+}
+
+// LoadKeySet reads the RSA and Ed25519 private keys (PKCS#8, or PKCS#1 for
+// RSA) from the *.pem files in dir. Each key's ID is its file name without
+// the extension.
+func LoadKeySet(dir, activeID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.pem keys in %s", dir)
+	}
+	sort.Strings(paths)
+
+	set := &KeySet{keys: make(map[string]*Key, len(paths))}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := parseKey(id, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		set.keys[id] = key
+		set.active = key
+	}
+
+	if activeID != "" {
+		if set.active = set.keys[activeID]; set.active == nil {
+			return nil, fmt.Errorf("active key %q not found in %s", activeID, dir)
+		}
+	}
+	return set, nil
+}
+
+// EphemeralKeySet generates a single Ed25519 key, identified by its JWK
+// thumbprint.
+func EphemeralKeySet() (*KeySet, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	key := &Key{Method: jwt.SigningMethodEdDSA, Private: private}
+	key.ID = thumbprint(key)
+	return &KeySet{keys: map[string]*Key{key.ID: key}, active: key}, nil
+}
+
+// SeedKeySet derives a single Ed25519 key from a base64 encoded 32 byte
+// seed, identified by its JWK thumbprint. It suits hosts where key files
+// cannot be mounted; changing the seed rotates the key.
+func SeedKeySet(seed string) (*KeySet, error) {
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		return nil, fmt.Errorf("decode seed: %w", err)
+	}
+	if len(raw) != ed25519.SeedSize {
+		return nil, fmt.Errorf("seed must be %d bytes, got %d", ed25519.SeedSize, len(raw))
+	}
+	key := &Key{Method: jwt.SigningMethodEdDSA, Private: ed25519.NewKeyFromSeed(raw)}
+	key.ID = thumbprint(key)
+	return &KeySet{keys: map[string]*Key{key.ID: key}, active: key}, nil
+}
+
+func (s *KeySet) Active() *Key {
+	return s.active
+}
+
+// Find returns the key with the given ID, or nil.
+func (s *KeySet) Find(id string) *Key {
+	return s.keys[id]
+}
+
+// JWK is the public half of a key, as published in the JWKS.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys, active key first.
+func (s *KeySet) JWKS() JWKSet {
+	ids := make([]string, 0, len(s.keys))
+	for id := range s.keys {
+		if id != s.active.ID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	set := JWKSet{Keys: []JWK{publicJWK(s.active)}}
+	for _, id := range ids {
+		set.Keys = append(set.Keys, publicJWK(s.keys[id]))
+	}
+	return set
+}
+
+func parseKey(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return &Key{ID: id, Method: jwt.SigningMethodRS256, Private: k}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: id, Method: jwt.SigningMethodEdDSA, Private: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T: use RSA or Ed25519", parsed)
+	}
+}
+
+func publicJWK(key *Key) JWK {
+	jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+	switch pub := key.Private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint of key's public half.
+func thumbprint(key *Key) string {
+	jwk := publicJWK(key)
+	var members interface{}
+	if jwk.Kty == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
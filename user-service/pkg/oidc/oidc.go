@@ -0,0 +1,451 @@
+// Package oidc signs users in with an external OpenID Connect provider using
+// the authorization code flow with PKCE. Endpoints come from the provider's
+// discovery document and ID tokens are checked against its published keys.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/urlshortener/shared/jwk"
+)
+
+const (
+	// How long discovery documents and key sets are used before refetching
+	metadataTTL = time.Hour
+	// Unknown key IDs trigger a refetch at most this often
+	minRefetchInterval = 30 * time.Second
+	// Allowed clock difference with the provider
+	clockSkew = time.Minute
+)
+
+// Signature algorithms accepted on ID tokens
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// Config describes one provider; it is read from a JSON file so the issuer
+// can point at a company IdP or at a local mock server alike.
+type Config struct {
+	// Identifies the provider in URLs, e.g. "google"
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Issuer      string `json:"issuer"`
+	ClientID    string `json:"client_id"`
+	// Empty for public clients, which rely on PKCE alone
+	ClientSecret string `json:"client_secret"`
+	// This service's callback URL, as registered with the provider
+	RedirectURL string `json:"redirect_url"`
+	// Defaults to openid, email and profile
+	Scopes []string `json:"scopes"`
+}
+
+// LoadConfig reads provider configurations from a JSON file of the form
+// {"providers": [...]}. ${VAR} references are expanded from the environment
+// so client secrets can stay out of the file.
+func LoadConfig(path string) ([]Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Providers []Config `json:"providers"`
+	}
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(data))), &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for i, cfg := range file.Providers {
+		switch {
+		case cfg.Name == "" || cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "":
+			return nil, fmt.Errorf("provider %d: name, issuer, client_id and redirect_url are required", i)
+		case seen[cfg.Name]:
+			return nil, fmt.Errorf("provider %q configured twice", cfg.Name)
+		}
+		seen[cfg.Name] = true
+	}
+	return file.Providers, nil
+}
+
+// Claims are the ID token claims used to find or create the local user.
+type Claims struct {
+	Email           string   `json:"email"`
+	EmailVerified   flexBool `json:"email_verified"`
+	Name            string   `json:"name"`
+	Nonce           string   `json:"nonce"`
+	AuthorizedParty string   `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// flexBool accepts both true and "true"; some providers send booleans as
+// strings.
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	case "false", "null":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
+}
+
+type discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Provider talks to one OpenID Connect provider. Its discovery document and
+// keys are fetched on first use and cached.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	// Held (by sending to it) while fetching, so concurrent logins wait for
+	// one fetch instead of each starting their own. mu only guards the
+	// cached values and is never held during a request
+	fetching        chan struct{}
+	mu              sync.Mutex
+	meta            *discovery
+	metaFetchedAt   time.Time
+	keys            map[string]crypto.PublicKey
+	keysFetchedAt   time.Time
+	keysLastAttempt time.Time
+}
+
+func NewProvider(cfg Config) *Provider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if cfg.DisplayName == "" {
+		cfg.DisplayName = cfg.Name
+	}
+	return &Provider{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		fetching: make(chan struct{}, 1),
+	}
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *Provider) DisplayName() string {
+	return p.cfg.DisplayName
+}
+
+// AuthCodeURL returns the provider URL to send the browser to. state and
+// nonce are echoed back and must be checked; verifier is kept for Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := p.discovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified claims of
+// the ID token, which must carry nonce.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	meta, err := p.discovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	basicAuth := p.cfg.ClientSecret != "" && supportsBasicAuth(meta.TokenEndpointAuthMethodsSupported)
+	if !basicAuth {
+		form.Set("client_id", p.cfg.ClientID)
+		if p.cfg.ClientSecret != "" {
+			form.Set("client_secret", p.cfg.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basicAuth {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("token endpoint returned %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		return nil, fmt.Errorf("token endpoint returned %s: %s %s", resp.Status, result.Error, result.ErrorDescription)
+	}
+	if result.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	return p.verify(ctx, result.IDToken, nonce)
+}
+
+// verify checks the ID token's signature, issuer, audience, lifetime and
+// nonce.
+func (p *Provider) verify(ctx context.Context, raw, nonce string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(raw, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithIssuer(p.cfg.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid ID token")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid ID token: no subject")
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("invalid ID token: nonce mismatch")
+	}
+	// A token issued to several audiences must name us as the party it was
+	// issued for
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.cfg.ClientID {
+		return nil, errors.New("invalid ID token: authorized party mismatch")
+	}
+	return claims, nil
+}
+
+func (p *Provider) discovery(ctx context.Context) (*discovery, error) {
+	if meta, fresh := p.cachedDiscovery(); fresh {
+		return meta, nil
+	}
+
+	unlock, err := p.lockFetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Another caller may have fetched it while we waited
+	current, fresh := p.cachedDiscovery()
+	if fresh {
+		return current, nil
+	}
+
+	var meta discovery
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &meta); err != nil {
+		if current != nil {
+			// Keep using the document we have
+			return current, nil
+		}
+		return nil, fmt.Errorf("discovery for %s: %w", p.cfg.Name, err)
+	}
+	if meta.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("discovery for %s: issuer %q does not match configured %q", p.cfg.Name, meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery for %s: missing endpoints", p.cfg.Name)
+	}
+
+	p.mu.Lock()
+	p.meta = &meta
+	p.metaFetchedAt = time.Now()
+	p.mu.Unlock()
+	return &meta, nil
+}
+
+func (p *Provider) cachedDiscovery() (meta *discovery, fresh bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.meta, p.meta != nil && time.Since(p.metaFetchedAt) < metadataTTL
+}
+
+// key returns the provider's public key for kid, refetching the key set when
+// it is stale or does not know kid yet (the provider may have rotated keys).
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	meta, err := p.discovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if key, fresh := p.cachedKey(kid); key != nil && fresh {
+		return key, nil
+	}
+
+	unlock, err := p.lockFetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Another caller may have fetched the keys while we waited
+	key, fresh := p.cachedKey(kid)
+	if key != nil && fresh {
+		return key, nil
+	}
+
+	p.mu.Lock()
+	refetch := p.keys == nil || !fresh || time.Since(p.keysLastAttempt) > minRefetchInterval
+	if refetch {
+		p.keysLastAttempt = time.Now()
+	}
+	p.mu.Unlock()
+
+	if refetch {
+		keys, err := p.fetchKeys(ctx, meta.JWKSURI)
+		p.mu.Lock()
+		if err == nil {
+			p.keys = keys
+			p.keysFetchedAt = time.Now()
+		}
+		haveKeys := p.keys != nil
+		p.mu.Unlock()
+		if err != nil && !haveKeys {
+			return nil, fmt.Errorf("keys for %s: %w", p.cfg.Name, err)
+		}
+		key, _ = p.cachedKey(kid)
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// cachedKey looks kid up in the cached key set, reporting whether the set is
+// fresh.
+func (p *Provider) cachedKey(kid string) (key crypto.PublicKey, fresh bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fresh = p.keys != nil && time.Since(p.keysFetchedAt) <= metadataTTL
+	// Providers with a single key may leave kid out
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, fresh
+		}
+	}
+	return p.keys[kid], fresh
+}
+
+// lockFetch waits until no other fetch is running, or ctx is done.
+func (p *Provider) lockFetch(ctx context.Context) (unlock func(), err error) {
+	select {
+	case p.fetching <- struct{}{}:
+		return func() { <-p.fetching }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *Provider) fetchKeys(ctx context.Context, uri string) (map[string]crypto.PublicKey, error) {
+	var set jwk.Set
+	if err := p.getJSON(ctx, uri, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if !k.Signing() {
+			continue
+		}
+		key, err := k.PublicKey()
+		if err != nil {
+			// Skip key types we cannot use rather than fail the whole set
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, uri string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", uri, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// supportsBasicAuth reports whether the token endpoint takes client
+// credentials in the Authorization header, the default when unadvertised.
+func supportsBasicAuth(methods []string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	for _, m := range methods {
+		if m == "client_secret_basic" {
+			return true
+		}
+	}
+	return false
+}